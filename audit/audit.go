@@ -0,0 +1,145 @@
+package audit
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"example.com/ginhello/logging"
+	"example.com/ginhello/models"
+)
+
+// Actions recorded in the audit log
+const (
+	ActionLogin          = "auth.login"
+	ActionTokenRefresh   = "auth.refresh"
+	ActionPasswordChange = "auth.password_change"
+	ActionPasswordForgot = "auth.password_forgot"
+	ActionPasswordReset  = "auth.password_reset"
+	ActionUserCreate     = "user.create"
+	ActionUserUpdate     = "user.update"
+	ActionUserDelete     = "user.delete"
+	ActionUserRestore    = "user.restore"
+	ActionUserUnlock     = "user.unlock"
+	ActionEmailVerify    = "user.email_verify"
+)
+
+// Outcomes of an audited action
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// TargetUser is the target type of actions performed on a user account
+const TargetUser = "user"
+
+// Sink receives a copy of every recorded event, e.g. to ship it to a log
+// pipeline outside the database
+type Sink interface {
+	Write(event *models.AuditEvent) error
+}
+
+// Recorder writes audit events to the database and any configured sinks
+type Recorder struct {
+	db     *gorm.DB
+	logger *zap.Logger
+	sinks  []Sink
+	now    func() time.Time
+}
+
+// Option configures optional Recorder behaviour
+type Option func(*Recorder)
+
+// WithSink mirrors recorded events to sink
+func WithSink(sink Sink) Option {
+	return func(r *Recorder) {
+		r.sinks = append(r.sinks, sink)
+	}
+}
+
+// NewRecorder creates a recorder storing events in db
+func NewRecorder(db *gorm.DB, logger *zap.Logger, opts ...Option) *Recorder {
+	r := &Recorder{
+		db:     db,
+		logger: logger,
+		now:    time.Now,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Record stores event. Failures are logged rather than returned: a broken
+// audit store must not turn a successful login into an error for the caller.
+// A nil recorder records nothing.
+func (r *Recorder) Record(ctx context.Context, event *models.AuditEvent) {
+	if r == nil {
+		return
+	}
+	logger := logging.FromContext(ctx, r.logger)
+
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = r.now().UTC()
+	}
+	if err := r.db.WithContext(ctx).Create(event).Error; err != nil {
+		logger.Error("Failed to store audit event", zap.String("action", event.Action), zap.Error(err))
+	}
+	for _, sink := range r.sinks {
+		if err := sink.Write(event); err != nil {
+			logger.Error("Failed to write audit event to sink", zap.String("action", event.Action), zap.Error(err))
+		}
+	}
+}
+
+// Filter selects audit events. Zero fields do not filter.
+type Filter struct {
+	ActorID       *uint
+	ActorUsername string
+	Action        string
+	Outcome       string
+	TargetID      string
+	Since         time.Time
+	Until         time.Time
+}
+
+// List returns the events matching filter, newest first, along with the total
+// number of matching events
+func (r *Recorder) List(ctx context.Context, filter Filter, limit, offset int) ([]models.AuditEvent, int64, error) {
+	query := r.db.WithContext(ctx).Model(&models.AuditEvent{})
+	if filter.ActorID != nil {
+		query = query.Where("actor_id = ?", *filter.ActorID)
+	}
+	if filter.ActorUsername != "" {
+		query = query.Where("actor_username = ?", filter.ActorUsername)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.Outcome != "" {
+		query = query.Where("outcome = ?", filter.Outcome)
+	}
+	if filter.TargetID != "" {
+		query = query.Where("target_id = ?", filter.TargetID)
+	}
+	if !filter.Since.IsZero() {
+		query = query.Where("created_at >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		query = query.Where("created_at < ?", filter.Until)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	events := []models.AuditEvent{}
+	err := query.Order("created_at DESC, id DESC").Limit(limit).Offset(offset).Find(&events).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return events, total, nil
+}
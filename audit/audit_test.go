@@ -0,0 +1,113 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"example.com/ginhello/models"
+	"example.com/ginhello/testutils"
+)
+
+type failingSink struct{}
+
+func (failingSink) Write(*models.AuditEvent) error { return errors.New("sink unavailable") }
+
+func TestRecorder_RecordAndList(t *testing.T) {
+	// Setup
+	db, logger := testutils.SetupTestDB(t)
+	recorder := NewRecorder(db, logger)
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	userID := uint(7)
+
+	recorder.Record(context.Background(), &models.AuditEvent{CreatedAt: base, Action: ActionLogin, Outcome: OutcomeFailure, ActorUsername: "alice", Reason: "wrong_password"})
+	recorder.Record(context.Background(), &models.AuditEvent{CreatedAt: base.Add(time.Minute), Action: ActionLogin, Outcome: OutcomeSuccess, ActorID: &userID, ActorUsername: "alice"})
+	recorder.Record(context.Background(), &models.AuditEvent{CreatedAt: base.Add(2 * time.Minute), Action: ActionUserCreate, Outcome: OutcomeSuccess, TargetType: TargetUser, TargetID: "8"})
+	recorder.Record(context.Background(), &models.AuditEvent{Action: ActionTokenRefresh, Outcome: OutcomeSuccess}) // Timestamped by the recorder
+
+	tests := []struct {
+		name          string
+		filter        Filter
+		limit, offset int
+		expectedTotal int64
+		expected      []string // Actions in order
+	}{
+		{"All, newest first", Filter{}, 10, 0, 4, []string{ActionTokenRefresh, ActionUserCreate, ActionLogin, ActionLogin}},
+		{"Paginated", Filter{}, 2, 1, 4, []string{ActionUserCreate, ActionLogin}},
+		{"By action and outcome", Filter{Action: ActionLogin, Outcome: OutcomeFailure}, 10, 0, 1, []string{ActionLogin}},
+		{"By actor ID", Filter{ActorID: &userID}, 10, 0, 1, []string{ActionLogin}},
+		{"By actor username", Filter{ActorUsername: "alice"}, 10, 0, 2, []string{ActionLogin, ActionLogin}},
+		{"By target", Filter{TargetID: "8"}, 10, 0, 1, []string{ActionUserCreate}},
+		{"By time range", Filter{Since: base.Add(time.Minute), Until: base.Add(2 * time.Minute)}, 10, 0, 1, []string{ActionLogin}},
+		{"No match", Filter{Action: "nope"}, 10, 0, 0, []string{}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			events, total, err := recorder.List(context.Background(), tc.filter, tc.limit, tc.offset)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedTotal, total)
+
+			actions := []string{}
+			for _, event := range events {
+				actions = append(actions, event.Action)
+			}
+			assert.Equal(t, tc.expected, actions)
+		})
+	}
+}
+
+func TestRecorder_Sinks(t *testing.T) {
+	// Setup
+	db, logger := testutils.SetupTestDB(t)
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	sink, err := NewFileSink(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	recorder := NewRecorder(db, logger, WithSink(failingSink{}), WithSink(sink))
+
+	// A failing sink does not keep the event from the others
+	recorder.Record(context.Background(), &models.AuditEvent{Action: ActionLogin, Outcome: OutcomeSuccess, ActorUsername: "alice", RequestID: "req-1"})
+	recorder.Record(context.Background(), &models.AuditEvent{Action: ActionLogin, Outcome: OutcomeFailure, ActorUsername: "bob"})
+	assert.NoError(t, sink.Close())
+
+	file, err := os.Open(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer file.Close()
+
+	var lines []models.AuditEvent
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event models.AuditEvent
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		lines = append(lines, event)
+	}
+	if assert.Len(t, lines, 2) {
+		assert.Equal(t, "alice", lines[0].ActorUsername)
+		assert.Equal(t, "req-1", lines[0].RequestID)
+		assert.NotZero(t, lines[0].ID)
+		assert.Equal(t, OutcomeFailure, lines[1].Outcome)
+	}
+
+	// Events are stored in the database as well
+	_, total, err := recorder.List(context.Background(), Filter{}, 10, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), total)
+}
+
+func TestRecorder_Nil(t *testing.T) {
+	var recorder *Recorder
+	assert.NotPanics(t, func() {
+		recorder.Record(context.Background(), &models.AuditEvent{Action: ActionLogin})
+	})
+}
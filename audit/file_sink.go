@@ -0,0 +1,45 @@
+package audit
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+
+	"example.com/ginhello/models"
+)
+
+// FileSink appends events to a file as JSON lines
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileSink opens path for appending, creating it if needed
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{file: file}, nil
+}
+
+// Write appends event as a single JSON line
+func (s *FileSink) Write(event *models.AuditEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.file.Write(line)
+	return err
+}
+
+// Close closes the underlying file
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
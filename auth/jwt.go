@@ -1,21 +1,47 @@
 package auth
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+	"gorm.io/gorm"
 
 	"example.com/ginhello/config"
+	"example.com/ginhello/metrics"
 	"example.com/ginhello/models"
+	"example.com/ginhello/tracing"
 )
 
 var (
-	ErrInvalidToken = errors.New("token is invalid")
-	ErrExpiredToken = errors.New("token has expired")
+	ErrInvalidToken   = errors.New("token is invalid")
+	ErrExpiredToken   = errors.New("token has expired")
+	ErrWrongTokenType = errors.New("token has the wrong type")
+	ErrRevokedToken   = errors.New("token has been revoked")
+	// ErrNoUserDB is returned by RefreshTokens on services without WithUserDB
+	ErrNoUserDB = errors.New("refreshing tokens requires a user database")
+)
+
+// IsTokenRejected reports whether err rejects the token itself, as opposed to
+// the token not being checkable, e.g. because the revocation checker failed.
+// Only rejected tokens should be discarded by clients.
+func IsTokenRejected(err error) bool {
+	return errors.Is(err, ErrInvalidToken) || errors.Is(err, ErrExpiredToken) ||
+		errors.Is(err, ErrWrongTokenType) || errors.Is(err, ErrRevokedToken)
+}
+
+// Token types stored in the "typ" claim
+const (
+	TokenTypeAccess  = "access"
+	TokenTypeRefresh = "refresh"
 )
 
 // TokenPair contains access and refresh tokens
@@ -27,43 +53,120 @@ type TokenPair struct {
 
 // TokenClaims contains the claims for JWT
 type TokenClaims struct {
-	UserID   uint   `json:"user_id"`
-	Username string `json:"username"`
-	TokenID  string `json:"token_id"` // Used for tracking refresh tokens
+	UserID        uint     `json:"user_id"`
+	Username      string   `json:"username"`
+	TokenID       string   `json:"token_id"` // Used for tracking refresh tokens
+	Permissions   []string `json:"permissions,omitempty"`
+	TokenType     string   `json:"typ,omitempty"` // Empty for tokens issued before types were introduced
+	TokenVersion  uint     `json:"ver,omitempty"` // User's token version when the token was issued
+	EmailVerified bool     `json:"email_verified"`
+	ClientID      string   `json:"azp,omitempty"` // OAuth client the token was issued to, if any
 	jwt.RegisteredClaims
 }
 
 // JWTService handles JWT operations
 type JWTService struct {
-	config *config.Config
-	logger *zap.Logger
+	config   *config.Config
+	logger   *zap.Logger
+	metrics  *metrics.AuthMetrics
+	tracer   trace.Tracer
+	sessions *sessionTracker
+	revoker  RevocationChecker
+	users    *gorm.DB
+}
+
+// JWTOption configures optional JWTService behaviour
+type JWTOption func(*JWTService)
+
+// WithMetrics records token issuance, validation failures and active sessions
+func WithMetrics(m *metrics.AuthMetrics) JWTOption {
+	return func(s *JWTService) {
+		s.metrics = m
+	}
+}
+
+// WithTracerProvider traces token signing and validation. Without it the
+// global OpenTelemetry tracer provider is used.
+func WithTracerProvider(tp trace.TracerProvider) JWTOption {
+	return func(s *JWTService) {
+		s.tracer = tracing.Tracer(tp)
+	}
+}
+
+// WithRevocationChecker rejects tokens that checker reports as revoked. Without
+// it tokens are valid until they expire.
+func WithRevocationChecker(checker RevocationChecker) JWTOption {
+	return func(s *JWTService) {
+		s.revoker = checker
+	}
+}
+
+// WithUserDB lets RefreshTokens load the users it issues new tokens for from
+// db, so that the tokens reflect their current state
+func WithUserDB(db *gorm.DB) JWTOption {
+	return func(s *JWTService) {
+		s.users = db
+	}
 }
 
 // NewJWTService creates a new JWT service
-func NewJWTService(config *config.Config, logger *zap.Logger) *JWTService {
-	return &JWTService{
-		config: config,
-		logger: logger,
+func NewJWTService(config *config.Config, logger *zap.Logger, opts ...JWTOption) *JWTService {
+	s := &JWTService{
+		config:   config,
+		logger:   logger,
+		tracer:   tracing.Tracer(otel.GetTracerProvider()),
+		sessions: newSessionTracker(),
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	return s
+}
+
+// Metrics returns the auth metrics recorder, which may be nil
+func (s *JWTService) Metrics() *metrics.AuthMetrics {
+	return s.metrics
+}
+
+// Issuer returns the issuer set on generated tokens
+func (s *JWTService) Issuer() string {
+	return s.config.JWTIssuer
 }
 
 // GenerateTokenPair generates an access token and refresh token
 func (s *JWTService) GenerateTokenPair(user *models.User) (*TokenPair, error) {
+	return s.GenerateTokenPairContext(context.Background(), user)
+}
+
+// GenerateTokenPairContext is GenerateTokenPair with a context used for tracing
+func (s *JWTService) GenerateTokenPairContext(ctx context.Context, user *models.User) (*TokenPair, error) {
+	_, span := s.tracer.Start(ctx, "jwt.GenerateTokenPair",
+		trace.WithAttributes(attribute.Int64("enduser.id", int64(user.ID))),
+	)
+	defer span.End()
+
 	// Generate tokens with a unique token ID
 	tokenID := uuid.NewString()
 
 	// Generate access token
-	accessToken, _, err := s.generateToken(user, tokenID, s.config.JWTAccessExpiry)
+	accessToken, _, err := s.generateToken(user, tokenID, TokenTypeAccess, s.config.JWTAccessExpiry)
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to sign access token")
 		return nil, err
 	}
 
 	// Generate refresh token
-	refreshToken, _, err := s.generateToken(user, tokenID, s.config.JWTRefreshExpiry)
+	refreshToken, refreshExpiry, err := s.generateToken(user, tokenID, TokenTypeRefresh, s.config.JWTRefreshExpiry)
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to sign refresh token")
 		return nil, err
 	}
 
+	// Each token pair starts a session that lives as long as its refresh token
+	s.metrics.SetActiveSessions(s.sessions.start(tokenID, refreshExpiry))
+
 	return &TokenPair{
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
@@ -73,6 +176,14 @@ func (s *JWTService) GenerateTokenPair(user *models.User) (*TokenPair, error) {
 
 // ValidateToken validates the JWT token
 func (s *JWTService) ValidateToken(tokenString string) (*TokenClaims, error) {
+	return s.ValidateTokenContext(context.Background(), tokenString)
+}
+
+// ValidateTokenContext is ValidateToken with a context used for tracing
+func (s *JWTService) ValidateTokenContext(ctx context.Context, tokenString string) (*TokenClaims, error) {
+	_, span := s.tracer.Start(ctx, "jwt.ValidateToken")
+	defer span.End()
+
 	// Parse the token
 	token, err := jwt.ParseWithClaims(
 		tokenString,
@@ -87,6 +198,8 @@ func (s *JWTService) ValidateToken(tokenString string) (*TokenClaims, error) {
 	)
 
 	if err != nil {
+		s.validationFailed(span, validationFailureCause(err))
+
 		// Check if the error is because the token is expired
 		if errors.Is(err, jwt.ErrTokenExpired) {
 			return nil, ErrExpiredToken
@@ -97,40 +210,152 @@ func (s *JWTService) ValidateToken(tokenString string) (*TokenClaims, error) {
 	// Extract claims
 	claims, ok := token.Claims.(*TokenClaims)
 	if !ok || !token.Valid {
+		s.validationFailed(span, metrics.CauseInvalid)
 		return nil, ErrInvalidToken
 	}
 
+	span.SetAttributes(attribute.Int64("enduser.id", int64(claims.UserID)))
 	return claims, nil
 }
 
+// ValidateAccessToken validates a token and checks that it is an access token
+func (s *JWTService) ValidateAccessToken(tokenString string) (*TokenClaims, error) {
+	return s.validateTokenType(context.Background(), tokenString, TokenTypeAccess)
+}
+
+// ValidateAccessTokenContext is ValidateAccessToken with a context used for tracing
+func (s *JWTService) ValidateAccessTokenContext(ctx context.Context, tokenString string) (*TokenClaims, error) {
+	return s.validateTokenType(ctx, tokenString, TokenTypeAccess)
+}
+
+// ValidateRefreshToken validates a token and checks that it is a refresh token
+func (s *JWTService) ValidateRefreshToken(tokenString string) (*TokenClaims, error) {
+	return s.validateTokenType(context.Background(), tokenString, TokenTypeRefresh)
+}
+
+// ValidateRefreshTokenContext is ValidateRefreshToken with a context used for tracing
+func (s *JWTService) ValidateRefreshTokenContext(ctx context.Context, tokenString string) (*TokenClaims, error) {
+	return s.validateTokenType(ctx, tokenString, TokenTypeRefresh)
+}
+
+// EndSession forgets the session started with the given token ID, e.g. once
+// its refresh token has been exchanged for a new pair
+func (s *JWTService) EndSession(tokenID string) {
+	s.metrics.SetActiveSessions(s.sessions.end(tokenID))
+}
+
+// validateTokenType validates a token and checks that it is of tokenType and
+// not revoked. Its span is the parent of the signature check and of the
+// revocation lookup, so that failures are not recorded on the caller's span.
+func (s *JWTService) validateTokenType(ctx context.Context, tokenString, tokenType string) (*TokenClaims, error) {
+	ctx, span := s.tracer.Start(ctx, "jwt.ValidateTokenType",
+		trace.WithAttributes(attribute.String("auth.token_type", tokenType)))
+	defer span.End()
+
+	claims, err := s.ValidateTokenContext(ctx, tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	// Untyped tokens predate token types and are accepted for either use
+	if claims.TokenType != "" && claims.TokenType != tokenType {
+		s.validationFailed(span, metrics.CauseWrongType)
+		return nil, ErrWrongTokenType
+	}
+
+	if s.revoker != nil {
+		revoked, err := s.revoker.Revoked(ctx, claims)
+		if err != nil {
+			// Fail closed: a token that cannot be checked is not accepted
+			s.logger.Error("Failed to check token revocation", zap.Uint("user_id", claims.UserID), zap.Error(err))
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "failed to check token revocation")
+			return nil, err
+		}
+		if revoked {
+			s.validationFailed(span, metrics.CauseRevoked)
+			return nil, ErrRevokedToken
+		}
+	}
+
+	return claims, nil
+}
+
+// validationFailed records a rejected token on the metrics and the span
+func (s *JWTService) validationFailed(span trace.Span, cause string) {
+	s.metrics.TokenValidationFailed(cause)
+	span.SetAttributes(attribute.String("auth.failure_cause", cause))
+}
+
+// validationFailureCause maps a jwt parse error to a metrics cause label
+func validationFailureCause(err error) string {
+	switch {
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return metrics.CauseExpired
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid):
+		return metrics.CauseBadSignature
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return metrics.CauseMalformed
+	default:
+		return metrics.CauseInvalid
+	}
+}
+
 // RefreshTokens generates new tokens using a refresh token
 func (s *JWTService) RefreshTokens(refreshToken string) (*TokenPair, error) {
+	return s.RefreshTokensContext(context.Background(), refreshToken)
+}
+
+// RefreshTokensContext is RefreshTokens with a context used for tracing. The
+// new tokens are issued for the user as currently stored, not as described by
+// the old token's claims, and tokens of deleted users or from before the
+// user's token version was bumped are rejected with ErrRevokedToken.
+func (s *JWTService) RefreshTokensContext(ctx context.Context, refreshToken string) (*TokenPair, error) {
 	// Validate refresh token
-	claims, err := s.ValidateToken(refreshToken)
+	claims, err := s.ValidateRefreshTokenContext(ctx, refreshToken)
 	if err != nil {
 		return nil, err
 	}
+	if s.users == nil {
+		return nil, ErrNoUserDB
+	}
 
-	// Create user object for token generation
-	user := &models.User{
-		Username: claims.Username,
+	var user models.User
+	err = s.users.WithContext(ctx).First(&user, claims.UserID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRevokedToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.TokenVersion != claims.TokenVersion {
+		return nil, ErrRevokedToken
+	}
+
+	// Generate new token pair with a new token ID, replacing the old session
+	tokenPair, err := s.GenerateTokenPairContext(ctx, &user)
+	if err != nil {
+		return nil, err
 	}
-	user.ID = claims.UserID // Set the ID separately
+	s.EndSession(claims.TokenID)
 
-	// Generate new token pair with a new token ID
-	return s.GenerateTokenPair(user)
+	return tokenPair, nil
 }
 
 // Helper to generate a token
-func (s *JWTService) generateToken(user *models.User, tokenID string, expiry time.Duration) (string, time.Time, error) {
+func (s *JWTService) generateToken(user *models.User, tokenID, tokenType string, expiry time.Duration) (string, time.Time, error) {
 	// Set expiration time
 	expiryTime := time.Now().Add(expiry)
 
 	// Create claims
 	claims := &TokenClaims{
-		UserID:   user.ID,
-		Username: user.Username,
-		TokenID:  tokenID,
+		UserID:        user.ID,
+		Username:      user.Username,
+		TokenID:       tokenID,
+		Permissions:   PermissionsFor(user),
+		TokenType:     tokenType,
+		TokenVersion:  user.TokenVersion,
+		EmailVerified: user.EmailVerifiedAt != nil,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expiryTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -149,6 +374,7 @@ func (s *JWTService) generateToken(user *models.User, tokenID string, expiry tim
 		s.logger.Error("Failed to sign token", zap.Error(err))
 		return "", time.Time{}, err
 	}
+	s.metrics.TokenIssued(tokenType)
 
 	return tokenString, expiryTime, nil
 }
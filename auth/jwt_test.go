@@ -1,14 +1,24 @@
 package auth
 
 import (
+	"context"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	"go.uber.org/zap"
+	"gorm.io/gorm"
 
 	"example.com/ginhello/config"
+	"example.com/ginhello/metrics"
 	"example.com/ginhello/models"
+	"example.com/ginhello/testutils"
 )
 
 func TestJWTService_GenerateTokenPair(t *testing.T) {
@@ -79,22 +89,18 @@ func TestJWTService_ValidateToken(t *testing.T) {
 
 func TestJWTService_RefreshTokens(t *testing.T) {
 	// Setup
-	logger := zap.NewNop()
+	db, logger := testutils.SetupTestDB(t)
 	cfg := &config.Config{
 		JWTSecret:        "test_secret",
 		JWTAccessExpiry:  15 * time.Minute,
 		JWTRefreshExpiry: 24 * time.Hour,
 		JWTIssuer:        "test_issuer",
 	}
-	jwtService := NewJWTService(cfg, logger)
-	user := &models.User{
-		Username: "testuser",
-		Email:    "test@example.com",
-	}
-	user.ID = 123 // Set ID manually for testing
+	jwtService := NewJWTService(cfg, logger, WithUserDB(db))
+	user := testutils.CreateTestUser(t, db, "testuser", "test@example.com", "pw")
 
 	// Generate initial tokens
-	tokenPair, err := jwtService.GenerateTokenPair(user)
+	tokenPair, err := jwtService.GenerateTokenPair(&user)
 	assert.NoError(t, err)
 
 	// Test refresh
@@ -105,8 +111,238 @@ func TestJWTService_RefreshTokens(t *testing.T) {
 	assert.NotEqual(t, tokenPair.AccessToken, newTokenPair.AccessToken)
 	assert.NotEqual(t, tokenPair.RefreshToken, newTokenPair.RefreshToken)
 
+	// The new tokens reflect the user as stored, not the old claims
+	assert.NoError(t, db.Model(&user).Updates(map[string]interface{}{"email_verified_at": time.Now(), "is_admin": true}).Error)
+	newTokenPair, err = jwtService.RefreshTokens(newTokenPair.RefreshToken)
+	assert.NoError(t, err)
+	claims, err := jwtService.ValidateAccessToken(newTokenPair.AccessToken)
+	assert.NoError(t, err)
+	assert.True(t, claims.EmailVerified)
+	assert.Equal(t, []string{PermissionAdmin}, claims.Permissions)
+
+	// Tokens from before a token version bump are revoked
+	assert.NoError(t, db.Model(&user).UpdateColumn("token_version", gorm.Expr("token_version + 1")).Error)
+	_, err = jwtService.RefreshTokens(newTokenPair.RefreshToken)
+	assert.Equal(t, ErrRevokedToken, err)
+
 	// Test with invalid token
 	newTokenPair, err = jwtService.RefreshTokens("invalid.token.string")
 	assert.Error(t, err)
 	assert.Nil(t, newTokenPair)
+
+	// Access tokens cannot be used to refresh
+	_, err = jwtService.RefreshTokens(tokenPair.AccessToken)
+	assert.Equal(t, ErrWrongTokenType, err)
+
+	// Without a user database, refreshing is refused
+	_, err = NewJWTService(cfg, logger).RefreshTokens(tokenPair.RefreshToken)
+	assert.Equal(t, ErrNoUserDB, err)
+}
+
+func TestJWTService_Permissions(t *testing.T) {
+	// Setup
+	logger := zap.NewNop()
+	cfg := &config.Config{
+		JWTSecret:        "test_secret",
+		JWTAccessExpiry:  15 * time.Minute,
+		JWTRefreshExpiry: 24 * time.Hour,
+		JWTIssuer:        "test_issuer",
+	}
+	jwtService := NewJWTService(cfg, logger)
+
+	// Regular users have no permissions
+	user := &models.User{Username: "testuser"}
+	user.ID = 123
+	tokenPair, err := jwtService.GenerateTokenPair(user)
+	assert.NoError(t, err)
+	claims, err := jwtService.ValidateToken(tokenPair.AccessToken)
+	assert.NoError(t, err)
+	assert.Empty(t, claims.Permissions)
+
+	// Admins get the admin permission, in refresh tokens as well
+	admin := &models.User{Username: "admin", IsAdmin: true}
+	admin.ID = 1
+	tokenPair, err = jwtService.GenerateTokenPair(admin)
+	assert.NoError(t, err)
+	claims, err = jwtService.ValidateToken(tokenPair.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{PermissionAdmin}, claims.Permissions)
+	claims, err = jwtService.ValidateRefreshToken(tokenPair.RefreshToken)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{PermissionAdmin}, claims.Permissions)
+}
+
+func TestJWTService_EmailVerified(t *testing.T) {
+	// Setup
+	logger := zap.NewNop()
+	cfg := &config.Config{
+		JWTSecret:        "test_secret",
+		JWTAccessExpiry:  15 * time.Minute,
+		JWTRefreshExpiry: 24 * time.Hour,
+		JWTIssuer:        "test_issuer",
+	}
+	jwtService := NewJWTService(cfg, logger)
+
+	user := &models.User{Username: "testuser"}
+	user.ID = 123
+	tokenPair, err := jwtService.GenerateTokenPair(user)
+	assert.NoError(t, err)
+	claims, err := jwtService.ValidateToken(tokenPair.AccessToken)
+	assert.NoError(t, err)
+	assert.False(t, claims.EmailVerified)
+
+	// Verified users get the claim
+	verifiedAt := time.Now()
+	user.EmailVerifiedAt = &verifiedAt
+	tokenPair, err = jwtService.GenerateTokenPair(user)
+	assert.NoError(t, err)
+	claims, err = jwtService.ValidateToken(tokenPair.AccessToken)
+	assert.NoError(t, err)
+	assert.True(t, claims.EmailVerified)
+	assert.True(t, NewPrincipal(claims).EmailVerified)
+}
+
+func TestJWTService_TokenTypes(t *testing.T) {
+	// Setup
+	logger := zap.NewNop()
+	cfg := &config.Config{
+		JWTSecret:        "test_secret",
+		JWTAccessExpiry:  15 * time.Minute,
+		JWTRefreshExpiry: 24 * time.Hour,
+		JWTIssuer:        "test_issuer",
+	}
+	jwtService := NewJWTService(cfg, logger)
+	user := &models.User{Username: "testuser"}
+	user.ID = 123
+
+	tokenPair, err := jwtService.GenerateTokenPair(user)
+	assert.NoError(t, err)
+
+	// Each token is only accepted for its own use
+	claims, err := jwtService.ValidateAccessToken(tokenPair.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, TokenTypeAccess, claims.TokenType)
+
+	_, err = jwtService.ValidateAccessToken(tokenPair.RefreshToken)
+	assert.Equal(t, ErrWrongTokenType, err)
+
+	claims, err = jwtService.ValidateRefreshToken(tokenPair.RefreshToken)
+	assert.NoError(t, err)
+	assert.Equal(t, TokenTypeRefresh, claims.TokenType)
+
+	_, err = jwtService.ValidateRefreshToken(tokenPair.AccessToken)
+	assert.Equal(t, ErrWrongTokenType, err)
+}
+
+func TestJWTService_Metrics(t *testing.T) {
+	// Setup
+	logger := zap.NewNop()
+	cfg := &config.Config{
+		JWTSecret:        "test_secret",
+		JWTAccessExpiry:  15 * time.Minute,
+		JWTRefreshExpiry: 24 * time.Hour,
+		JWTIssuer:        "test_issuer",
+	}
+	registry := prometheus.NewRegistry()
+	jwtService := NewJWTService(cfg, logger, WithMetrics(metrics.NewAuthMetrics(registry)))
+	user := &models.User{Username: "testuser"}
+	user.ID = 123
+
+	tokenPair, err := jwtService.GenerateTokenPair(user)
+	assert.NoError(t, err)
+
+	// Tokens signed with another secret have a bad signature
+	otherService := NewJWTService(&config.Config{JWTSecret: "other_secret", JWTAccessExpiry: time.Minute, JWTRefreshExpiry: time.Minute}, logger)
+	otherPair, err := otherService.GenerateTokenPair(user)
+	assert.NoError(t, err)
+
+	// Expired tokens
+	expiredService := NewJWTService(&config.Config{JWTSecret: "test_secret", JWTAccessExpiry: -time.Minute, JWTRefreshExpiry: -time.Minute}, logger)
+	expiredPair, err := expiredService.GenerateTokenPair(user)
+	assert.NoError(t, err)
+
+	_, _ = jwtService.ValidateToken("invalid.token.string")
+	_, _ = jwtService.ValidateToken(otherPair.AccessToken)
+	_, _ = jwtService.ValidateToken(expiredPair.AccessToken)
+	_, _ = jwtService.ValidateAccessToken(tokenPair.RefreshToken)
+
+	// Refreshing replaces the session rather than adding one
+	claims, err := jwtService.ValidateRefreshToken(tokenPair.RefreshToken)
+	assert.NoError(t, err)
+	_, err = jwtService.GenerateTokenPair(user)
+	assert.NoError(t, err)
+	jwtService.EndSession(claims.TokenID)
+
+	expected := `
+# HELP ginhello_auth_active_sessions Sessions with an unexpired refresh token issued by this instance.
+# TYPE ginhello_auth_active_sessions gauge
+ginhello_auth_active_sessions 1
+# HELP ginhello_auth_token_validation_failures_total Token validation failures by cause.
+# TYPE ginhello_auth_token_validation_failures_total counter
+ginhello_auth_token_validation_failures_total{cause="bad_signature"} 1
+ginhello_auth_token_validation_failures_total{cause="expired"} 1
+ginhello_auth_token_validation_failures_total{cause="malformed"} 1
+ginhello_auth_token_validation_failures_total{cause="wrong_type"} 1
+# HELP ginhello_auth_tokens_issued_total Tokens issued by token type.
+# TYPE ginhello_auth_tokens_issued_total counter
+ginhello_auth_tokens_issued_total{type="access"} 2
+ginhello_auth_tokens_issued_total{type="refresh"} 2
+`
+	err = testutil.GatherAndCompare(registry, strings.NewReader(expected),
+		"ginhello_auth_active_sessions",
+		"ginhello_auth_token_validation_failures_total",
+		"ginhello_auth_tokens_issued_total",
+	)
+	assert.NoError(t, err)
+}
+
+func TestJWTService_Tracing(t *testing.T) {
+	// Setup
+	logger := zap.NewNop()
+	cfg := &config.Config{
+		JWTSecret:        "test_secret",
+		JWTAccessExpiry:  15 * time.Minute,
+		JWTRefreshExpiry: 24 * time.Hour,
+		JWTIssuer:        "test_issuer",
+	}
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	jwtService := NewJWTService(cfg, logger, WithTracerProvider(tp))
+	user := &models.User{Username: "testuser"}
+	user.ID = 123
+
+	// Test under a parent span
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+	tokenPair, err := jwtService.GenerateTokenPairContext(ctx, user)
+	assert.NoError(t, err)
+	_, err = jwtService.ValidateAccessTokenContext(ctx, tokenPair.AccessToken)
+	assert.NoError(t, err)
+	_, err = jwtService.ValidateAccessTokenContext(ctx, "invalid.token.string")
+	assert.Error(t, err)
+	_, err = jwtService.ValidateAccessTokenContext(ctx, tokenPair.RefreshToken)
+	assert.Equal(t, ErrWrongTokenType, err)
+	parent.End()
+
+	// Assert: spans end, and are exported, before their parents
+	spans := exporter.GetSpans()
+	names := []string{}
+	for _, span := range spans {
+		names = append(names, span.Name)
+	}
+	assert.Equal(t, []string{
+		"jwt.GenerateTokenPair",
+		"jwt.ValidateToken", "jwt.ValidateTokenType",
+		"jwt.ValidateToken", "jwt.ValidateTokenType",
+		"jwt.ValidateToken", "jwt.ValidateTokenType",
+		"parent",
+	}, names)
+	for _, i := range []int{0, 2, 4, 6} {
+		assert.Equal(t, parent.SpanContext().SpanID(), spans[i].Parent.SpanID())
+	}
+	for _, i := range []int{1, 3, 5} {
+		assert.Equal(t, spans[i+1].SpanContext.SpanID(), spans[i].Parent.SpanID())
+	}
+	assert.Contains(t, spans[3].Attributes, attribute.String("auth.failure_cause", metrics.CauseMalformed))
+	assert.Contains(t, spans[6].Attributes, attribute.String("auth.failure_cause", metrics.CauseWrongType))
+	assert.Empty(t, spans[7].Attributes, "failures are not recorded on the caller's span")
 }
@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// LockoutPolicy controls how failed login attempts are throttled
+type LockoutPolicy struct {
+	Threshold       int           // Failures before the key is locked out
+	LockoutDuration time.Duration // How long a locked key stays locked
+	BaseDelay       time.Duration // Delay after the first failure, doubled on each further one
+	MaxDelay        time.Duration // Upper bound for the backoff delay
+}
+
+// attemptState tracks consecutive failures for one key
+type attemptState struct {
+	failures    int
+	lastFailure time.Time
+}
+
+// AttemptLimiter tracks failed attempts per key (an account or a client IP),
+// applying exponential backoff between attempts and a temporary lockout once
+// the threshold is reached. State is kept in memory and forgotten once a key
+// has been quiet for the lockout duration.
+type AttemptLimiter struct {
+	policy    LockoutPolicy
+	mu        sync.Mutex
+	attempts  map[string]*attemptState
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// attemptSweepInterval bounds how often the limiter scans for expired keys
+const attemptSweepInterval = time.Minute
+
+// NewAttemptLimiter creates an attempt limiter enforcing the given policy
+func NewAttemptLimiter(policy LockoutPolicy) *AttemptLimiter {
+	return &AttemptLimiter{
+		policy:   policy,
+		attempts: make(map[string]*attemptState),
+		now:      time.Now,
+	}
+}
+
+// AccountKey returns the limiter key for a username
+func AccountKey(username string) string {
+	return "account:" + username
+}
+
+// IPKey returns the limiter key for a client IP
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Wait returns how long the caller must wait before key may attempt again.
+// Zero means an attempt is allowed now.
+func (l *AttemptLimiter) Wait(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	state, ok := l.attempts[key]
+	if !ok {
+		return 0
+	}
+
+	now := l.now()
+	if l.expired(state, now) {
+		delete(l.attempts, key)
+		return 0
+	}
+
+	wait := state.lastFailure.Add(l.delay(state.failures)).Sub(now)
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// Fail records a failed attempt for key
+func (l *AttemptLimiter) Fail(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	state, ok := l.attempts[key]
+	if !ok || l.expired(state, now) {
+		state = &attemptState{}
+		l.attempts[key] = state
+	}
+	state.failures++
+	state.lastFailure = now
+}
+
+// Reset clears all failures recorded for key, e.g. after a successful login
+// or when an administrator unlocks an account
+func (l *AttemptLimiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.attempts, key)
+}
+
+// delay returns the wait imposed after the given number of failures
+func (l *AttemptLimiter) delay(failures int) time.Duration {
+	if l.policy.Threshold > 0 && failures >= l.policy.Threshold {
+		return l.policy.LockoutDuration
+	}
+
+	delay := l.policy.BaseDelay
+	for i := 1; i < failures && delay < l.policy.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > l.policy.MaxDelay {
+		delay = l.policy.MaxDelay
+	}
+	return delay
+}
+
+// expired reports whether the key has been quiet long enough to start over
+func (l *AttemptLimiter) expired(state *attemptState, now time.Time) bool {
+	return now.Sub(state.lastFailure) >= l.policy.LockoutDuration
+}
+
+// sweep drops expired keys so that the map does not grow without bound.
+// Callers must hold l.mu.
+func (l *AttemptLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < attemptSweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	for key, state := range l.attempts {
+		if l.expired(state, now) {
+			delete(l.attempts, key)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAttemptLimiter(t *testing.T) {
+	// Setup limiter with a controllable clock
+	now := time.Unix(1700000000, 0)
+	limiter := NewAttemptLimiter(LockoutPolicy{
+		Threshold:       4,
+		LockoutDuration: 10 * time.Minute,
+		BaseDelay:       time.Second,
+		MaxDelay:        3 * time.Second,
+	})
+	limiter.now = func() time.Time { return now }
+	key := AccountKey("alice")
+
+	// No failures, no wait
+	assert.Equal(t, time.Duration(0), limiter.Wait(key))
+
+	// Backoff doubles with each failure and is capped
+	limiter.Fail(key)
+	assert.Equal(t, time.Second, limiter.Wait(key))
+	limiter.Fail(key)
+	assert.Equal(t, 2*time.Second, limiter.Wait(key))
+	limiter.Fail(key)
+	assert.Equal(t, 3*time.Second, limiter.Wait(key))
+
+	// Waiting long enough allows another attempt
+	now = now.Add(3 * time.Second)
+	assert.Equal(t, time.Duration(0), limiter.Wait(key))
+
+	// Reaching the threshold locks the key out
+	limiter.Fail(key)
+	assert.Equal(t, 10*time.Minute, limiter.Wait(key))
+
+	// Other keys are unaffected
+	assert.Equal(t, time.Duration(0), limiter.Wait(AccountKey("bob")))
+
+	// The lockout expires and the failure count starts over
+	now = now.Add(10 * time.Minute)
+	assert.Equal(t, time.Duration(0), limiter.Wait(key))
+	limiter.Fail(key)
+	assert.Equal(t, time.Second, limiter.Wait(key))
+}
+
+func TestAttemptLimiter_Reset(t *testing.T) {
+	limiter := NewAttemptLimiter(LockoutPolicy{
+		Threshold:       1,
+		LockoutDuration: 10 * time.Minute,
+		BaseDelay:       time.Second,
+		MaxDelay:        time.Second,
+	})
+	key := IPKey("10.0.0.1")
+
+	limiter.Fail(key)
+	assert.Greater(t, limiter.Wait(key), time.Duration(0))
+
+	limiter.Reset(key)
+	assert.Equal(t, time.Duration(0), limiter.Wait(key))
+}
@@ -0,0 +1,14 @@
+package auth
+
+import "example.com/ginhello/models"
+
+// PermissionAdmin allows managing other users' accounts
+const PermissionAdmin = "admin"
+
+// PermissionsFor returns the permissions granted to a user
+func PermissionsFor(user *models.User) []string {
+	if user.IsAdmin {
+		return []string{PermissionAdmin}
+	}
+	return nil
+}
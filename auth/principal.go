@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"context"
+	"slices"
+)
+
+// PrincipalContextKey is the gin context key under which the authenticated
+// principal is stored. *gin.Context resolves string keys from its own key
+// store, so PrincipalFrom works on it without importing gin here.
+const PrincipalContextKey = "auth.principal"
+
+type principalKey struct{}
+
+// Principal identifies the caller of an authenticated request
+type Principal struct {
+	UserID      uint
+	Username    string
+	TokenID     string
+	Permissions []string
+
+	// Whether the user's email address was verified when the token was issued
+	EmailVerified bool
+
+	// OAuth client the token was issued to; empty for first-party tokens
+	ClientID string
+}
+
+// NewPrincipal creates a principal from validated token claims
+func NewPrincipal(claims *TokenClaims) *Principal {
+	return &Principal{
+		UserID:        claims.UserID,
+		Username:      claims.Username,
+		TokenID:       claims.TokenID,
+		Permissions:   claims.Permissions,
+		EmailVerified: claims.EmailVerified,
+		ClientID:      claims.ClientID,
+	}
+}
+
+// HasPermission reports whether the principal was granted permission
+func (p *Principal) HasPermission(permission string) bool {
+	return slices.Contains(p.Permissions, permission)
+}
+
+// WithPrincipal returns a copy of ctx carrying the principal
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFrom returns the principal stored in ctx. It accepts both a
+// *gin.Context and a plain context.Context derived from the request.
+func PrincipalFrom(ctx context.Context) (*Principal, bool) {
+	if p, ok := ctx.Value(PrincipalContextKey).(*Principal); ok && p != nil {
+		return p, true
+	}
+	if p, ok := ctx.Value(principalKey{}).(*Principal); ok && p != nil {
+		return p, true
+	}
+	return nil, false
+}
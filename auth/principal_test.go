@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrincipalFrom(t *testing.T) {
+	principal := &Principal{UserID: 123, Username: "testuser", TokenID: "token-id"}
+
+	// Empty context
+	p, ok := PrincipalFrom(context.Background())
+	assert.False(t, ok)
+	assert.Nil(t, p)
+
+	// Context carrying a principal
+	ctx := WithPrincipal(context.Background(), principal)
+	p, ok = PrincipalFrom(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, principal, p)
+
+	// Derived contexts keep the principal
+	derived, cancel := context.WithCancel(ctx)
+	defer cancel()
+	p, ok = PrincipalFrom(derived)
+	assert.True(t, ok)
+	assert.Equal(t, uint(123), p.UserID)
+}
+
+func TestNewPrincipal(t *testing.T) {
+	claims := &TokenClaims{UserID: 7, Username: "alice", TokenID: "abc", ClientID: "mobile-app"}
+
+	p := NewPrincipal(claims)
+
+	assert.Equal(t, uint(7), p.UserID)
+	assert.Equal(t, "alice", p.Username)
+	assert.Equal(t, "abc", p.TokenID)
+	assert.Equal(t, "mobile-app", p.ClientID)
+}
@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	"gorm.io/gorm"
+
+	"example.com/ginhello/models"
+)
+
+// RevocationChecker decides whether tokens that are otherwise valid have been
+// revoked, e.g. because their user was deleted
+type RevocationChecker interface {
+	Revoked(ctx context.Context, claims *TokenClaims) (bool, error)
+}
+
+// UserRevocationChecker revokes tokens whose user no longer exists or whose
+// token version is older than the user's current one
+type UserRevocationChecker struct {
+	db *gorm.DB
+}
+
+// NewUserRevocationChecker creates a revocation checker looking users up in db
+func NewUserRevocationChecker(db *gorm.DB) *UserRevocationChecker {
+	return &UserRevocationChecker{db: db}
+}
+
+// Revoked implements RevocationChecker
+func (c *UserRevocationChecker) Revoked(ctx context.Context, claims *TokenClaims) (bool, error) {
+	var user models.User
+	err := c.db.WithContext(ctx).Select("id", "token_version").First(&user, claims.UserID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return user.TokenVersion != claims.TokenVersion, nil
+}
@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+
+	"example.com/ginhello/testutils"
+)
+
+func TestUserRevocationChecker(t *testing.T) {
+	// Setup
+	db, logger := testutils.SetupTestDB(t)
+	cfg := testutils.SetupTestConfig(t)
+	jwtService := NewJWTService(cfg, logger, WithRevocationChecker(NewUserRevocationChecker(db)))
+	user := testutils.CreateTestUser(t, db, "revokeme", "revokeme@example.com", "pw")
+
+	tokens, err := jwtService.GenerateTokenPair(&user)
+	assert.NoError(t, err)
+
+	// Tokens are valid while the user exists with the same token version
+	_, err = jwtService.ValidateAccessTokenContext(context.Background(), tokens.AccessToken)
+	assert.NoError(t, err)
+
+	// Bumping the version revokes every token issued before
+	assert.NoError(t, db.Model(&user).UpdateColumn("token_version", gorm.Expr("token_version + 1")).Error)
+	_, err = jwtService.ValidateAccessTokenContext(context.Background(), tokens.AccessToken)
+	assert.Equal(t, ErrRevokedToken, err)
+	_, err = jwtService.ValidateRefreshTokenContext(context.Background(), tokens.RefreshToken)
+	assert.Equal(t, ErrRevokedToken, err)
+
+	// Tokens issued with the new version are valid until the user is deleted
+	assert.NoError(t, db.First(&user, user.ID).Error)
+	tokens, err = jwtService.GenerateTokenPair(&user)
+	assert.NoError(t, err)
+	_, err = jwtService.ValidateAccessTokenContext(context.Background(), tokens.AccessToken)
+	assert.NoError(t, err)
+
+	assert.NoError(t, db.Delete(&user).Error)
+	_, err = jwtService.ValidateAccessTokenContext(context.Background(), tokens.AccessToken)
+	assert.Equal(t, ErrRevokedToken, err)
+}
@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// sessionTracker counts the sessions started by this instance whose refresh
+// token has not yet expired or been exchanged. It only feeds the active
+// sessions metric; token validity never depends on it.
+type sessionTracker struct {
+	mu        sync.Mutex
+	sessions  map[string]time.Time // Token ID to refresh token expiry
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+// sessionPruneInterval bounds how often expired sessions are dropped, so the
+// count may include sessions that expired less than this long ago
+const sessionPruneInterval = time.Minute
+
+func newSessionTracker() *sessionTracker {
+	return &sessionTracker{
+		sessions: make(map[string]time.Time),
+		now:      time.Now,
+	}
+}
+
+// start records a session and returns the number of active sessions
+func (t *sessionTracker) start(tokenID string, expiry time.Time) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sessions[tokenID] = expiry
+	return t.activeLocked()
+}
+
+// end forgets a session and returns the number of active sessions
+func (t *sessionTracker) end(tokenID string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.sessions, tokenID)
+	return t.activeLocked()
+}
+
+// activeLocked prunes expired sessions and counts the rest. Callers must hold t.mu.
+func (t *sessionTracker) activeLocked() int {
+	now := t.now()
+	if now.Sub(t.lastPrune) < sessionPruneInterval {
+		return len(t.sessions)
+	}
+	t.lastPrune = now
+
+	for tokenID, expiry := range t.sessions {
+		if !now.Before(expiry) {
+			delete(t.sessions, tokenID)
+		}
+	}
+	return len(t.sessions)
+}
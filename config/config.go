@@ -3,6 +3,9 @@ package config
 import (
 	"fmt"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -12,6 +15,50 @@ import (
 const (
 	DefaultJWTAccessExpiry  = 15 * time.Minute
 	DefaultJWTRefreshExpiry = 72 * time.Hour
+
+	DefaultLoginRateLimit  = 10
+	DefaultLoginRateWindow = time.Minute
+	DefaultAPIRateLimit    = 100
+	DefaultAPIRateWindow   = time.Minute
+
+	DefaultLockoutThreshold   = 5
+	DefaultIPLockoutThreshold = 20
+	DefaultLockoutDuration    = 15 * time.Minute
+	DefaultLoginBackoffBase   = time.Second
+	DefaultLoginBackoffMax    = 30 * time.Second
+
+	DefaultUserRetention     = 30 * 24 * time.Hour
+	DefaultUserPurgeInterval = time.Hour
+
+	DefaultSMTPPort                    = 587
+	DefaultPasswordResetExpiry         = time.Hour
+	DefaultPasswordResetResendInterval = time.Minute
+
+	DefaultEmailVerificationExpiry         = 24 * time.Hour
+	DefaultEmailVerificationResendInterval = time.Minute
+
+	DefaultPasswordMinLength  = 8
+	DefaultPasswordMaxBytes   = 72
+	DefaultPasswordMinClasses = 1
+	DefaultPasswordMinEntropy = 30
+
+	DefaultArgon2Memory      = 19 * 1024 // KiB
+	DefaultArgon2Iterations  = 2
+	DefaultArgon2Parallelism = 1
+	DefaultBcryptCost        = 10
+)
+
+// Policies for users whose email address has not been verified
+const (
+	UnverifiedEmailAllow    = "allow"    // No restrictions
+	UnverifiedEmailRestrict = "restrict" // Only the user's own account can be used
+	UnverifiedEmailBlock    = "block"    // Login is refused
+)
+
+// Password hash algorithms
+const (
+	PasswordHashArgon2id = "argon2id"
+	PasswordHashBcrypt   = "bcrypt"
 )
 
 // Config holds all configuration for the application
@@ -27,6 +74,87 @@ type Config struct {
 	DBPassword string
 	DBName     string
 	DBSource   string // Constructed DSN
+
+	// Proxies, as IPs or CIDRs, whose X-Forwarded-For and X-Real-IP headers
+	// are trusted to name the client IP. By default none are, since clients
+	// could otherwise pick the IP that rate limits and lockouts apply to.
+	TrustedProxies []string
+
+	// Rate limits allow N requests per window; a limit of 0 disables limiting
+	LoginRateLimit  int
+	LoginRateWindow time.Duration
+	APIRateLimit    int
+	APIRateWindow   time.Duration
+
+	// Failed login tracking; a threshold of 0 disables tracking for that key
+	LockoutThreshold   int // Failures per account before lockout
+	IPLockoutThreshold int // Failures per client IP before lockout
+	LockoutDuration    time.Duration
+	LoginBackoffBase   time.Duration // Delay after the first failure, doubled on each further one
+	LoginBackoffMax    time.Duration
+
+	// OpenTelemetry tracing; the OTLP exporter reads OTEL_EXPORTER_OTLP_* itself
+	TracingEnabled bool
+	ServiceName    string
+
+	// Path of a JSON lines file mirroring the audit log; empty disables it
+	AuditLogFile string
+
+	// Log redaction; the key and header lists extend the built-in defaults
+	LogRedactKeys    []string
+	LogRedactHeaders []string
+	LogMaskEmails    bool
+	LogAnonymizeIP   bool
+
+	// Soft deleted users are purged once they have been deleted for longer
+	// than the retention period; a retention of 0 disables purging
+	UserRetention     time.Duration
+	UserPurgeInterval time.Duration
+
+	// Outgoing mail; MailDriver is smtp, file (writes to MailDir) or memory.
+	// Mail is disabled when it is empty, and with it password reset and
+	// email verification.
+	MailDriver   string
+	MailFrom     string
+	MailDir      string
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+
+	// Password reset links are PasswordResetURL with the token appended as
+	// the "token" query parameter. A new link is sent at most once per
+	// resend interval.
+	PasswordResetURL            string
+	PasswordResetExpiry         time.Duration
+	PasswordResetResendInterval time.Duration
+
+	// Email verification links work like password reset links. A new link
+	// is sent at most once per resend interval.
+	EmailVerificationURL            string
+	EmailVerificationExpiry         time.Duration
+	EmailVerificationResendInterval time.Duration
+	UnverifiedEmailPolicy           string
+
+	// Password policy; a value of 0 disables the rule. Passwords must not
+	// contain the banned words, the username or the email address.
+	PasswordMinLength   int
+	PasswordMaxBytes    int
+	PasswordMinClasses  int // Of lowercase, uppercase, digits and other characters
+	PasswordMinEntropy  int // Estimated bits
+	PasswordBannedWords []string
+
+	// Sorted "SHA1:COUNT" file of breached passwords; empty disables the check
+	PasswordBreachFile string
+
+	// New passwords are hashed with PasswordHashAlgorithm. Stored hashes
+	// made with another algorithm or other parameters are replaced when
+	// their users next log in.
+	PasswordHashAlgorithm string
+	Argon2Memory          int // KiB
+	Argon2Iterations      int
+	Argon2Parallelism     int
+	BcryptCost            int
 }
 
 // Load loads configuration from environment variables
@@ -49,6 +177,22 @@ func Load(logger *zap.Logger) (*Config, error) {
 		refreshExpiry = DefaultJWTRefreshExpiry
 	}
 
+	// Parse rate limits
+	loginRateLimit := getEnvInt(logger, "LOGIN_RATE_LIMIT", DefaultLoginRateLimit)
+	loginRateWindow := getEnvPositiveDuration(logger, "LOGIN_RATE_WINDOW", DefaultLoginRateWindow)
+	apiRateLimit := getEnvInt(logger, "API_RATE_LIMIT", DefaultAPIRateLimit)
+	apiRateWindow := getEnvPositiveDuration(logger, "API_RATE_WINDOW", DefaultAPIRateWindow)
+
+	// Parse login lockout policy
+	lockoutThreshold := getEnvInt(logger, "LOCKOUT_THRESHOLD", DefaultLockoutThreshold)
+	ipLockoutThreshold := getEnvInt(logger, "IP_LOCKOUT_THRESHOLD", DefaultIPLockoutThreshold)
+	lockoutDuration := getEnvDuration(logger, "LOCKOUT_DURATION", DefaultLockoutDuration)
+	loginBackoffBase := getEnvDuration(logger, "LOGIN_BACKOFF_BASE", DefaultLoginBackoffBase)
+	loginBackoffMax := getEnvDuration(logger, "LOGIN_BACKOFF_MAX", DefaultLoginBackoffMax)
+
+	// Parse tracing settings
+	tracingEnabled := getEnvBool(logger, "TRACING_ENABLED", false)
+
 	// Construct DSN
 	dbHost := getEnv("DB_HOST", "localhost")
 	dbPort := getEnv("DB_PORT", "5432")
@@ -70,6 +214,63 @@ func Load(logger *zap.Logger) (*Config, error) {
 		DBPassword: dbPassword,
 		DBName:     dbName,
 		DBSource:   dbSource,
+
+		TrustedProxies: getEnvList("TRUSTED_PROXIES"),
+
+		LoginRateLimit:  loginRateLimit,
+		LoginRateWindow: loginRateWindow,
+		APIRateLimit:    apiRateLimit,
+		APIRateWindow:   apiRateWindow,
+
+		LockoutThreshold:   lockoutThreshold,
+		IPLockoutThreshold: ipLockoutThreshold,
+		LockoutDuration:    lockoutDuration,
+		LoginBackoffBase:   loginBackoffBase,
+		LoginBackoffMax:    loginBackoffMax,
+
+		TracingEnabled: tracingEnabled,
+		ServiceName:    getEnv("OTEL_SERVICE_NAME", "ginhello"),
+
+		AuditLogFile: getEnv("AUDIT_LOG_FILE", ""),
+
+		LogRedactKeys:    getEnvList("LOG_REDACT_KEYS"),
+		LogRedactHeaders: getEnvList("LOG_REDACT_HEADERS"),
+		LogMaskEmails:    getEnvBool(logger, "LOG_MASK_EMAILS", true),
+		LogAnonymizeIP:   getEnvBool(logger, "LOG_ANONYMIZE_IP", false),
+
+		UserRetention:     getEnvDuration(logger, "USER_RETENTION", DefaultUserRetention),
+		UserPurgeInterval: getEnvDuration(logger, "USER_PURGE_INTERVAL", DefaultUserPurgeInterval),
+
+		MailDriver:   getEnv("MAIL_DRIVER", ""),
+		MailFrom:     getEnv("MAIL_FROM", "ginhello <noreply@localhost>"),
+		MailDir:      getEnv("MAIL_DIR", "outbox"),
+		SMTPHost:     getEnv("SMTP_HOST", ""),
+		SMTPPort:     getEnvInt(logger, "SMTP_PORT", DefaultSMTPPort),
+		SMTPUsername: getEnv("SMTP_USERNAME", ""),
+		SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+
+		PasswordResetURL:            getEnv("PASSWORD_RESET_URL", "http://localhost:8080/reset-password"),
+		PasswordResetExpiry:         getEnvDuration(logger, "PASSWORD_RESET_EXPIRY", DefaultPasswordResetExpiry),
+		PasswordResetResendInterval: getEnvDuration(logger, "PASSWORD_RESET_RESEND_INTERVAL", DefaultPasswordResetResendInterval),
+
+		EmailVerificationURL:            getEnv("EMAIL_VERIFICATION_URL", "http://localhost:8080/verify-email"),
+		EmailVerificationExpiry:         getEnvDuration(logger, "EMAIL_VERIFICATION_EXPIRY", DefaultEmailVerificationExpiry),
+		EmailVerificationResendInterval: getEnvDuration(logger, "EMAIL_VERIFICATION_RESEND_INTERVAL", DefaultEmailVerificationResendInterval),
+		UnverifiedEmailPolicy: getEnvChoice(logger, "UNVERIFIED_EMAIL_POLICY", UnverifiedEmailAllow,
+			UnverifiedEmailRestrict, UnverifiedEmailBlock),
+
+		PasswordMinLength:   getEnvInt(logger, "PASSWORD_MIN_LENGTH", DefaultPasswordMinLength),
+		PasswordMaxBytes:    getEnvInt(logger, "PASSWORD_MAX_BYTES", DefaultPasswordMaxBytes),
+		PasswordMinClasses:  getEnvInt(logger, "PASSWORD_MIN_CLASSES", DefaultPasswordMinClasses),
+		PasswordMinEntropy:  getEnvInt(logger, "PASSWORD_MIN_ENTROPY", DefaultPasswordMinEntropy),
+		PasswordBannedWords: getEnvList("PASSWORD_BANNED_WORDS"),
+		PasswordBreachFile:  getEnv("PASSWORD_BREACH_FILE", ""),
+
+		PasswordHashAlgorithm: getEnvChoice(logger, "PASSWORD_HASH_ALGORITHM", PasswordHashArgon2id, PasswordHashBcrypt),
+		Argon2Memory:          getEnvInt(logger, "ARGON2_MEMORY", DefaultArgon2Memory),
+		Argon2Iterations:      getEnvInt(logger, "ARGON2_ITERATIONS", DefaultArgon2Iterations),
+		Argon2Parallelism:     getEnvInt(logger, "ARGON2_PARALLELISM", DefaultArgon2Parallelism),
+		BcryptCost:            getEnvInt(logger, "BCRYPT_COST", DefaultBcryptCost),
 	}, nil
 }
 
@@ -80,3 +281,78 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+// Helper to get an integer environment variable, logging and falling back on parse errors
+func getEnvInt(logger *zap.Logger, key string, fallback int) int {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		logger.Error("Invalid "+key, zap.Error(err))
+		return fallback
+	}
+	return parsed
+}
+
+// Helper to get a duration environment variable, logging and falling back on parse errors
+func getEnvDuration(logger *zap.Logger, key string, fallback time.Duration) time.Duration {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		logger.Error("Invalid "+key, zap.Error(err))
+		return fallback
+	}
+	return parsed
+}
+
+// Helper to get a duration environment variable that must be positive, such
+// as a rate limit window that refill rates are divided by
+func getEnvPositiveDuration(logger *zap.Logger, key string, fallback time.Duration) time.Duration {
+	value := getEnvDuration(logger, key, fallback)
+	if value <= 0 {
+		logger.Error("Invalid "+key+", must be positive", zap.Duration("value", value))
+		return fallback
+	}
+	return value
+}
+
+// Helper to get a boolean environment variable, logging and falling back on parse errors
+func getEnvBool(logger *zap.Logger, key string, fallback bool) bool {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		logger.Error("Invalid "+key, zap.Error(err))
+		return fallback
+	}
+	return parsed
+}
+
+// Helper to get an environment variable that must be one of the given
+// choices, logging and falling back on other values
+func getEnvChoice(logger *zap.Logger, key, fallback string, choices ...string) string {
+	value := getEnv(key, fallback)
+	if value != fallback && !slices.Contains(choices, value) {
+		logger.Error("Invalid "+key, zap.String("value", value), zap.Strings("choices", append([]string{fallback}, choices...)))
+		return fallback
+	}
+	return value
+}
+
+// Helper to get a comma-separated list environment variable, ignoring empty entries
+func getEnvList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(getEnv(key, ""), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
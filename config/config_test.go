@@ -18,6 +18,7 @@ func TestLoad_WithDefaultValues(t *testing.T) {
 	os.Unsetenv("JWT_ACCESS_EXPIRY")
 	os.Unsetenv("JWT_REFRESH_EXPIRY")
 	os.Unsetenv("JWT_ISSUER")
+	os.Unsetenv("MAIL_DRIVER")
 
 	// Test
 	cfg, err := Load(logger)
@@ -28,6 +29,7 @@ func TestLoad_WithDefaultValues(t *testing.T) {
 	assert.Equal(t, 15*time.Minute, cfg.JWTAccessExpiry)
 	assert.Equal(t, 72*time.Hour, cfg.JWTRefreshExpiry)
 	assert.Equal(t, "ginhello", cfg.JWTIssuer)
+	assert.Empty(t, cfg.MailDriver, "mail is disabled by default")
 }
 
 func TestLoad_WithEnvironmentValues(t *testing.T) {
@@ -90,3 +92,179 @@ func TestGetEnv(t *testing.T) {
 	result = getEnv("NON_EXISTENT_KEY", "default_value")
 	assert.Equal(t, "default_value", result)
 }
+
+func TestLoad_RateLimits(t *testing.T) {
+	// Setup
+	logger := zap.NewNop()
+
+	// Defaults
+	cfg, err := Load(logger)
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultLoginRateLimit, cfg.LoginRateLimit)
+	assert.Equal(t, DefaultLoginRateWindow, cfg.LoginRateWindow)
+	assert.Equal(t, DefaultAPIRateLimit, cfg.APIRateLimit)
+	assert.Equal(t, DefaultAPIRateWindow, cfg.APIRateWindow)
+
+	// Environment values, falling back to defaults when invalid
+	os.Setenv("LOGIN_RATE_LIMIT", "5")
+	os.Setenv("LOGIN_RATE_WINDOW", "30s")
+	os.Setenv("API_RATE_LIMIT", "not-a-number")
+	os.Setenv("API_RATE_WINDOW", "2m")
+	defer func() {
+		os.Unsetenv("LOGIN_RATE_LIMIT")
+		os.Unsetenv("LOGIN_RATE_WINDOW")
+		os.Unsetenv("API_RATE_LIMIT")
+		os.Unsetenv("API_RATE_WINDOW")
+	}()
+
+	cfg, err = Load(logger)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, cfg.LoginRateLimit)
+	assert.Equal(t, 30*time.Second, cfg.LoginRateWindow)
+	assert.Equal(t, DefaultAPIRateLimit, cfg.APIRateLimit)
+	assert.Equal(t, 2*time.Minute, cfg.APIRateWindow)
+
+	// Windows that are not positive would give infinite refill rates
+	os.Setenv("LOGIN_RATE_WINDOW", "0s")
+	os.Setenv("API_RATE_WINDOW", "-1m")
+	cfg, err = Load(logger)
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultLoginRateWindow, cfg.LoginRateWindow)
+	assert.Equal(t, DefaultAPIRateWindow, cfg.APIRateWindow)
+}
+
+func TestLoad_TrustedProxies(t *testing.T) {
+	logger := zap.NewNop()
+	defer os.Unsetenv("TRUSTED_PROXIES")
+
+	// None are trusted by default
+	cfg, err := Load(logger)
+	assert.NoError(t, err)
+	assert.Empty(t, cfg.TrustedProxies)
+
+	os.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, 192.168.1.1")
+	cfg, err = Load(logger)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.0/8", "192.168.1.1"}, cfg.TrustedProxies)
+}
+
+func TestLoad_LockoutPolicy(t *testing.T) {
+	// Setup
+	logger := zap.NewNop()
+	os.Setenv("LOCKOUT_THRESHOLD", "3")
+	os.Setenv("LOCKOUT_DURATION", "1h")
+	defer func() {
+		os.Unsetenv("LOCKOUT_THRESHOLD")
+		os.Unsetenv("LOCKOUT_DURATION")
+	}()
+
+	// Test
+	cfg, err := Load(logger)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, 3, cfg.LockoutThreshold)
+	assert.Equal(t, time.Hour, cfg.LockoutDuration)
+	assert.Equal(t, DefaultIPLockoutThreshold, cfg.IPLockoutThreshold)
+	assert.Equal(t, DefaultLoginBackoffBase, cfg.LoginBackoffBase)
+	assert.Equal(t, DefaultLoginBackoffMax, cfg.LoginBackoffMax)
+}
+
+func TestLoad_LogRedaction(t *testing.T) {
+	// Setup
+	logger := zap.NewNop()
+	os.Setenv("LOG_REDACT_KEYS", "otp, pin,,")
+	os.Setenv("LOG_ANONYMIZE_IP", "true")
+	defer func() {
+		os.Unsetenv("LOG_REDACT_KEYS")
+		os.Unsetenv("LOG_ANONYMIZE_IP")
+	}()
+
+	// Test
+	cfg, err := Load(logger)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"otp", "pin"}, cfg.LogRedactKeys)
+	assert.Nil(t, cfg.LogRedactHeaders)
+	assert.True(t, cfg.LogMaskEmails)
+	assert.True(t, cfg.LogAnonymizeIP)
+}
+
+func TestLoad_UserRetention(t *testing.T) {
+	// Setup
+	logger := zap.NewNop()
+	os.Setenv("USER_RETENTION", "0")
+	defer os.Unsetenv("USER_RETENTION")
+
+	// Test
+	cfg, err := Load(logger)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), cfg.UserRetention)
+	assert.Equal(t, DefaultUserPurgeInterval, cfg.UserPurgeInterval)
+}
+
+func TestLoad_Mail(t *testing.T) {
+	// Setup
+	logger := zap.NewNop()
+	os.Setenv("MAIL_DRIVER", "smtp")
+	os.Setenv("SMTP_HOST", "smtp.example.com")
+	os.Setenv("PASSWORD_RESET_EXPIRY", "30m")
+	defer func() {
+		os.Unsetenv("MAIL_DRIVER")
+		os.Unsetenv("SMTP_HOST")
+		os.Unsetenv("PASSWORD_RESET_EXPIRY")
+	}()
+
+	// Test
+	cfg, err := Load(logger)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, "smtp", cfg.MailDriver)
+	assert.Equal(t, "smtp.example.com", cfg.SMTPHost)
+	assert.Equal(t, DefaultSMTPPort, cfg.SMTPPort)
+	assert.Equal(t, 30*time.Minute, cfg.PasswordResetExpiry)
+	assert.Equal(t, DefaultPasswordResetResendInterval, cfg.PasswordResetResendInterval)
+}
+
+func TestLoad_UnverifiedEmailPolicy(t *testing.T) {
+	logger := zap.NewNop()
+	defer os.Unsetenv("UNVERIFIED_EMAIL_POLICY")
+
+	os.Setenv("UNVERIFIED_EMAIL_POLICY", "block")
+	cfg, err := Load(logger)
+	assert.NoError(t, err)
+	assert.Equal(t, UnverifiedEmailBlock, cfg.UnverifiedEmailPolicy)
+
+	// Unknown policies fall back to the default
+	os.Setenv("UNVERIFIED_EMAIL_POLICY", "sometimes")
+	cfg, err = Load(logger)
+	assert.NoError(t, err)
+	assert.Equal(t, UnverifiedEmailAllow, cfg.UnverifiedEmailPolicy)
+	assert.Equal(t, DefaultEmailVerificationExpiry, cfg.EmailVerificationExpiry)
+}
+
+func TestLoad_PasswordHashing(t *testing.T) {
+	// Setup
+	logger := zap.NewNop()
+	os.Setenv("PASSWORD_HASH_ALGORITHM", "bcrypt")
+	os.Setenv("BCRYPT_COST", "12")
+	defer func() {
+		os.Unsetenv("PASSWORD_HASH_ALGORITHM")
+		os.Unsetenv("BCRYPT_COST")
+	}()
+
+	// Test
+	cfg, err := Load(logger)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, PasswordHashBcrypt, cfg.PasswordHashAlgorithm)
+	assert.Equal(t, 12, cfg.BcryptCost)
+	assert.Equal(t, DefaultArgon2Memory, cfg.Argon2Memory)
+	assert.Equal(t, DefaultArgon2Iterations, cfg.Argon2Iterations)
+	assert.Equal(t, DefaultArgon2Parallelism, cfg.Argon2Parallelism)
+}
@@ -1,8 +1,9 @@
 package database
 
 import (
+	"time"
+
 	"go.uber.org/zap"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 
@@ -21,7 +22,7 @@ func Connect(cfg *config.Config, logger *zap.Logger) (*gorm.DB, error) {
 	logger.Info("Database connection established successfully")
 
 	// Migrate the schema
-	err = db.AutoMigrate(&models.User{})
+	err = Migrate(db, logger)
 	if err != nil {
 		logger.Fatal("Failed to migrate database schema", zap.Error(err))
 		return nil, err
@@ -31,8 +32,25 @@ func Connect(cfg *config.Config, logger *zap.Logger) (*gorm.DB, error) {
 	return db, nil
 }
 
-// HashPassword hashes a password using bcrypt (moved from handlers)
-func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	return string(bytes), err
+// Migrate creates or updates the tables for all models. Problems that need
+// manual attention, such as users that collide once normalized, are logged.
+func Migrate(db *gorm.DB, logger *zap.Logger) error {
+	// Users registered before email verification was introduced could not
+	// verify their address; they count as verified so that the unverified
+	// email policy does not lock them out
+	backfillVerified := db.Migrator().HasTable(&models.User{}) &&
+		!db.Migrator().HasColumn(&models.User{}, "EmailVerifiedAt")
+
+	if err := db.AutoMigrate(&models.User{}, &models.AuditEvent{}, &models.PasswordResetToken{}, &models.EmailVerificationToken{}); err != nil {
+		return err
+	}
+	if backfillVerified {
+		result := db.Unscoped().Model(&models.User{}).Where("email_verified_at IS NULL").
+			UpdateColumn("email_verified_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		logger.Info("Marked the emails of existing users as verified", zap.Int64("users", result.RowsAffected))
+	}
+	return migrateCaseInsensitiveIndexes(db, logger)
 }
@@ -0,0 +1,66 @@
+package database_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"example.com/ginhello/database"
+	"example.com/ginhello/models"
+)
+
+// baselineUser is the users table as it was before deleted users were kept
+// and names were normalized
+type baselineUser struct {
+	gorm.Model
+	Username string `gorm:"uniqueIndex;not null"`
+	Email    string `gorm:"uniqueIndex;not null"`
+	Password string `gorm:"not null"`
+}
+
+func (baselineUser) TableName() string { return "users" }
+
+// setupBaselineDB creates a database with the users table as it was before
+func setupBaselineDB(t *testing.T, name string) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file:"+name+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to connect to test database: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, _ := db.DB(); sqlDB != nil {
+			sqlDB.Close()
+		}
+	})
+	if err := db.AutoMigrate(&baselineUser{}); err != nil {
+		t.Fatalf("Failed to create baseline schema: %v", err)
+	}
+	return db
+}
+
+func TestMigrate_FromBaselineMarksEmailsVerified(t *testing.T) {
+	// Setup
+	db := setupBaselineDB(t, "baseline_verified")
+	existing := baselineUser{Username: "existing", Email: "existing@example.com", Password: "x"}
+	assert.NoError(t, db.Create(&existing).Error)
+
+	// Test
+	err := database.Migrate(db, zap.NewNop())
+
+	// Assert: users from before email verification are not locked out,
+	// users registered afterwards still have to verify
+	assert.NoError(t, err)
+	var stored models.User
+	assert.NoError(t, db.First(&stored, existing.ID).Error)
+	assert.NotNil(t, stored.EmailVerifiedAt)
+
+	registered := models.User{Username: "new", Email: "new@example.com", Password: "x"}
+	assert.NoError(t, db.Create(&registered).Error)
+	assert.NoError(t, database.Migrate(db, zap.NewNop()))
+	var storedRegistered models.User
+	assert.NoError(t, db.First(&storedRegistered, registered.ID).Error)
+	assert.Nil(t, storedRegistered.EmailVerifiedAt)
+}
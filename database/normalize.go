@@ -0,0 +1,231 @@
+package database
+
+import (
+	"cmp"
+	"maps"
+	"slices"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"example.com/ginhello/models"
+	"example.com/ginhello/normalize"
+)
+
+// Collision is a group of active users whose usernames or emails are the same
+// once normalized. They have to be resolved by hand, for example by renaming
+// or deleting all but one of the users, before the column can get its
+// case-insensitive unique index.
+type Collision struct {
+	Column     string // "username" or "email"
+	Normalized string
+	UserIDs    []uint
+}
+
+// normalizedColumn is a user column stored in normalized form
+type normalizedColumn struct {
+	name      string
+	normalize func(string) string
+	value     func(*models.User) string
+}
+
+// normalizedColumns are the user columns stored in normalized form
+var normalizedColumns = []normalizedColumn{
+	{name: "username", normalize: normalize.Username, value: func(u *models.User) string { return u.Username }},
+	{name: "email", normalize: normalize.Email, value: func(u *models.User) string { return u.Email }},
+}
+
+// normalizeBatchSize bounds the rows read, looked up or updated at once, so
+// that normalizing a large users table neither holds it in memory nor keeps
+// one long transaction open
+const normalizeBatchSize = 500
+
+// NormalizeUsers rewrites the usernames and emails of all users, including
+// deleted ones, in normalized form. Users are scanned in batches and only
+// those with a value that is not normalized yet are kept and updated. Active
+// users whose normalized username or email would collide with another active
+// user's keep that value as it is and are reported instead.
+func NormalizeUsers(db *gorm.DB) ([]Collision, error) {
+	var pending []models.User
+	var batch []models.User
+	err := db.Unscoped().Select("id", "username", "email", "deleted_at").
+		FindInBatches(&batch, normalizeBatchSize, func(*gorm.DB, int) error {
+			for _, user := range batch {
+				for _, column := range normalizedColumns {
+					if value := column.value(&user); column.normalize(value) != value {
+						pending = append(pending, user)
+						break
+					}
+				}
+			}
+			return nil
+		}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var collisions []Collision
+	colliding := map[string]map[uint]bool{}
+	for _, column := range normalizedColumns {
+		found, err := findCollisions(db, column, pending)
+		if err != nil {
+			return nil, err
+		}
+		colliding[column.name] = map[uint]bool{}
+		for _, collision := range found {
+			for _, id := range collision.UserIDs {
+				colliding[column.name][id] = true
+			}
+		}
+		collisions = append(collisions, found...)
+	}
+	slices.SortFunc(collisions, func(a, b Collision) int {
+		return cmp.Or(cmp.Compare(a.Column, b.Column), cmp.Compare(a.Normalized, b.Normalized))
+	})
+
+	for chunk := range slices.Chunk(pending, normalizeBatchSize) {
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			for i := range chunk {
+				if err := normalizeUser(tx, &chunk[i], colliding); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			return collisions, err
+		}
+	}
+	return collisions, nil
+}
+
+// findCollisions groups the active users in pending whose column would be
+// normalized with each other and with the active users already holding the
+// normalized value, and returns the groups of more than one user
+func findCollisions(db *gorm.DB, column normalizedColumn, pending []models.User) ([]Collision, error) {
+	groups := map[string][]uint{}
+	for i := range pending {
+		value := column.value(&pending[i])
+		if key := column.normalize(value); key != value && !pending[i].DeletedAt.Valid {
+			groups[key] = append(groups[key], pending[i].ID)
+		}
+	}
+
+	// Users already holding a normalized value are not pending themselves
+	keys := slices.Collect(maps.Keys(groups))
+	for chunk := range slices.Chunk(keys, normalizeBatchSize) {
+		var holders []models.User
+		if err := db.Select("id", column.name).Where(column.name+" IN ?", chunk).Find(&holders).Error; err != nil {
+			return nil, err
+		}
+		for i := range holders {
+			key := column.value(&holders[i])
+			groups[key] = append(groups[key], holders[i].ID)
+		}
+	}
+
+	var collisions []Collision
+	for key, ids := range groups {
+		if len(ids) > 1 {
+			slices.Sort(ids)
+			collisions = append(collisions, Collision{Column: column.name, Normalized: key, UserIDs: ids})
+		}
+	}
+	return collisions, nil
+}
+
+// normalizeUser rewrites the values of user that are not normalized and do
+// not collide
+func normalizeUser(tx *gorm.DB, user *models.User, colliding map[string]map[uint]bool) error {
+	updates := map[string]interface{}{}
+	for _, column := range normalizedColumns {
+		value := column.value(user)
+		if normalized := column.normalize(value); normalized != value && !colliding[column.name][user.ID] {
+			updates[column.name] = normalized
+		}
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+
+	// Pending verification links are for the address, not its spelling
+	if email, ok := updates["email"]; ok {
+		if err := tx.Model(&models.EmailVerificationToken{}).
+			Where("user_id = ? AND email = ?", user.ID, user.Email).
+			Update("email", email).Error; err != nil {
+			return err
+		}
+	}
+	// Updates also bumps updated_at, so clients see a new ETag
+	return tx.Unscoped().Model(user).Updates(updates).Error
+}
+
+// migrateCaseInsensitiveIndexes normalizes existing users and replaces the
+// unique indexes on username and email with partial indexes on their
+// lower-cased values that skip deleted users. The application stores
+// normalized values, so the indexes are a safeguard against rows written by
+// other means. Once both indexes exist the migration is done and later
+// startups skip normalizing. A column with collisions gets a case-sensitive
+// partial unique index instead, and the collisions are logged. Old indexes
+// are only dropped once their replacement exists, so the columns are never
+// left without a unique index.
+func migrateCaseInsensitiveIndexes(db *gorm.DB, logger *zap.Logger) error {
+	done := true
+	for _, column := range normalizedColumns {
+		done = done && db.Migrator().HasIndex(&models.User{}, foldedIndex(column))
+	}
+	var collisions []Collision
+	if !done {
+		var err error
+		if collisions, err = NormalizeUsers(db); err != nil {
+			return err
+		}
+	}
+
+	for _, column := range normalizedColumns {
+		var blocked bool
+		for _, collision := range collisions {
+			if collision.Column == column.name {
+				blocked = true
+				logger.Warn("Users collide once normalized and keep their original value, which then only matches when "+
+					"entered exactly; resolve them and restart to enforce case-insensitive uniqueness",
+					zap.String("column", collision.Column),
+					zap.String("normalized", collision.Normalized),
+					zap.Uints("user_ids", collision.UserIDs))
+			}
+		}
+
+		// The indexes of earlier versions: the first covered deleted users
+		// as well, the second is case-sensitive
+		index, expression := foldedIndex(column), "lower("+column.name+")"
+		obsolete := []string{"idx_users_" + column.name, activeIndex(column)}
+		if blocked {
+			index, expression = activeIndex(column), column.name
+			obsolete = obsolete[:1]
+		}
+
+		// Expression and partial indexes are supported by Postgres and SQLite alike
+		if err := db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS " + index +
+			" ON users (" + expression + ") WHERE deleted_at IS NULL").Error; err != nil {
+			return err
+		}
+		for _, old := range obsolete {
+			if db.Migrator().HasIndex(&models.User{}, old) {
+				if err := db.Migrator().DropIndex(&models.User{}, old); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// activeIndex is the name of the case-sensitive unique index on column, used
+// while the column has collisions
+func activeIndex(column normalizedColumn) string {
+	return "idx_users_" + column.name + "_active"
+}
+
+// foldedIndex is the name of the case-insensitive unique index on column
+func foldedIndex(column normalizedColumn) string {
+	return "idx_users_" + column.name + "_folded"
+}
@@ -0,0 +1,137 @@
+package database_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	"example.com/ginhello/database"
+	"example.com/ginhello/models"
+	"example.com/ginhello/testutils"
+)
+
+func TestMigrate_NormalizesUsers(t *testing.T) {
+	// Setup: a database from before normalization, without the
+	// case-insensitive indexes
+	db, _ := testutils.SetupTestDB(t)
+	for _, index := range []string{"idx_users_username_folded", "idx_users_email_folded"} {
+		assert.NoError(t, db.Migrator().DropIndex(&models.User{}, index))
+	}
+	alice := testutils.CreateTestUser(t, db, "Alice", "alice@example.com", "pw")
+	aliceLower := testutils.CreateTestUser(t, db, "alice", "alice.lower@example.com", "pw")
+	bob := testutils.CreateTestUser(t, db, " Bob", "Bob@Example.COM", "pw")
+	deleted := testutils.CreateTestUser(t, db, "ALICE", "ALICE@example.com", "pw")
+	assert.NoError(t, db.Delete(&deleted).Error)
+	assert.NoError(t, db.Create(&models.EmailVerificationToken{
+		UserID: bob.ID, Email: bob.Email, TokenHash: "hash", ExpiresAt: time.Now().Add(time.Hour),
+	}).Error)
+
+	core, logs := observer.New(zap.WarnLevel)
+
+	// Test
+	err := database.Migrate(db, zap.New(core))
+
+	// Assert
+	assert.NoError(t, err)
+	username := func(user models.User) string {
+		var stored models.User
+		assert.NoError(t, db.Unscoped().First(&stored, user.ID).Error)
+		return stored.Username
+	}
+	assert.Equal(t, "Alice", username(alice), "colliding users are left alone")
+	assert.Equal(t, "alice", username(aliceLower))
+	assert.Equal(t, "bob", username(bob))
+	assert.Equal(t, "alice", username(deleted), "deleted users do not collide")
+
+	var storedBob models.User
+	assert.NoError(t, db.First(&storedBob, bob.ID).Error)
+	assert.Equal(t, "bob@example.com", storedBob.Email)
+	assert.True(t, storedBob.UpdatedAt.After(bob.UpdatedAt))
+	var token models.EmailVerificationToken
+	assert.NoError(t, db.Where("user_id = ?", bob.ID).First(&token).Error)
+	assert.Equal(t, "bob@example.com", token.Email)
+
+	// The collision is reported, and only the email index could be created
+	entries := logs.FilterMessageSnippet("collide").All()
+	if assert.Len(t, entries, 1) {
+		fields := entries[0].ContextMap()
+		assert.Equal(t, "username", fields["column"])
+		assert.Equal(t, "alice", fields["normalized"])
+		assert.Equal(t, []interface{}{alice.ID, aliceLower.ID}, fields["user_ids"])
+	}
+	assert.False(t, db.Migrator().HasIndex(&models.User{}, "idx_users_username_folded"))
+	assert.True(t, db.Migrator().HasIndex(&models.User{}, "idx_users_username_active"))
+	assert.True(t, db.Migrator().HasIndex(&models.User{}, "idx_users_email_folded"))
+}
+
+func TestMigrate_FromBaselineWithCollisions(t *testing.T) {
+	// Setup
+	db := setupBaselineDB(t, "baseline_collisions")
+	assert.NoError(t, db.Create(&baselineUser{Username: "Alice", Email: "alice@example.com", Password: "x"}).Error)
+	assert.NoError(t, db.Create(&baselineUser{Username: "alice", Email: "alice.lower@example.com", Password: "x"}).Error)
+
+	// Test
+	err := database.Migrate(db, zap.NewNop())
+
+	// Assert: the colliding column keeps a case-sensitive unique index
+	assert.NoError(t, err)
+	for _, index := range []string{"idx_users_username", "idx_users_email", "idx_users_email_active", "idx_users_username_folded"} {
+		assert.False(t, db.Migrator().HasIndex(&models.User{}, index), index)
+	}
+	for _, index := range []string{"idx_users_username_active", "idx_users_email_folded"} {
+		assert.True(t, db.Migrator().HasIndex(&models.User{}, index), index)
+	}
+
+	assert.NoError(t, db.Create(&models.User{Username: "bob", Email: "bob@example.com", Password: "x"}).Error)
+	assert.Error(t, db.Create(&models.User{Username: "bob", Email: "bob.other@example.com", Password: "x"}).Error)
+	assert.Error(t, db.Create(&models.User{Username: "carol", Email: "BOB@example.com", Password: "x"}).Error)
+}
+
+func TestMigrate_NormalizesOnce(t *testing.T) {
+	// Setup: the case-insensitive indexes exist, so normalization has run
+	db, logger := testutils.SetupTestDB(t)
+	eve := testutils.CreateTestUser(t, db, "Eve", "Eve@example.com", "pw")
+
+	// Test
+	err := database.Migrate(db, logger)
+
+	// Assert: users are not scanned again on every startup
+	assert.NoError(t, err)
+	var stored models.User
+	assert.NoError(t, db.First(&stored, eve.ID).Error)
+	assert.Equal(t, "Eve", stored.Username)
+}
+
+func TestMigrate_CaseInsensitiveIndexes(t *testing.T) {
+	// Setup
+	db, _ := testutils.SetupTestDB(t)
+	testutils.CreateTestUser(t, db, "carol", "carol@example.com", "pw")
+
+	// Test: rows written without normalization still cannot collide
+	duplicateName := db.Create(&models.User{Username: "CAROL", Email: "other@example.com", Password: "x"}).Error
+	duplicateEmail := db.Create(&models.User{Username: "other", Email: "Carol@Example.com", Password: "x"}).Error
+
+	// Assert
+	assert.Error(t, duplicateName)
+	assert.Error(t, duplicateEmail)
+}
+
+func TestNormalizeUsers(t *testing.T) {
+	// Setup
+	db, _ := testutils.SetupTestDB(t)
+	dave := testutils.CreateTestUser(t, db, "Dave", "Dave@example.com", "pw")
+
+	// Test
+	collisions, err := database.NormalizeUsers(db)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Empty(t, collisions)
+	var stored models.User
+	assert.NoError(t, db.First(&stored, dave.ID).Error)
+	assert.Equal(t, "dave", stored.Username)
+	assert.Equal(t, "dave@example.com", stored.Email)
+}
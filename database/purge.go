@@ -0,0 +1,76 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"example.com/ginhello/models"
+)
+
+// PurgeDeletedUsers permanently removes users that were soft deleted before
+// cutoff and returns how many were removed
+func PurgeDeletedUsers(ctx context.Context, db *gorm.DB, cutoff time.Time) (int64, error) {
+	var purged int64
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		expired := tx.Unscoped().Model(&models.User{}).Select("id").
+			Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff)
+		if err := tx.Where("user_id IN (?)", expired).Delete(&models.PasswordResetToken{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id IN (?)", expired).Delete(&models.EmailVerificationToken{}).Error; err != nil {
+			return err
+		}
+
+		result := tx.Unscoped().
+			Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).
+			Delete(&models.User{})
+		purged = result.RowsAffected
+		return result.Error
+	})
+	return purged, err
+}
+
+// PurgeExpiredTokens removes password reset and email verification tokens
+// that expired before cutoff
+func PurgeExpiredTokens(ctx context.Context, db *gorm.DB, cutoff time.Time) (int64, error) {
+	var purged int64
+	for _, model := range []interface{}{&models.PasswordResetToken{}, &models.EmailVerificationToken{}} {
+		result := db.WithContext(ctx).Where("expires_at < ?", cutoff).Delete(model)
+		if result.Error != nil {
+			return purged, result.Error
+		}
+		purged += result.RowsAffected
+	}
+	return purged, nil
+}
+
+// RunUserPurge purges users deleted longer than retention ago, along with
+// expired reset and verification tokens, every interval until ctx is cancelled
+func RunUserPurge(ctx context.Context, db *gorm.DB, logger *zap.Logger, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := PurgeDeletedUsers(ctx, db, time.Now().Add(-retention))
+		if err != nil {
+			logger.Error("Failed to purge deleted users", zap.Error(err))
+		} else if purged > 0 {
+			logger.Info("Purged deleted users", zap.Int64("count", purged))
+		}
+		purged, err = PurgeExpiredTokens(ctx, db, time.Now())
+		if err != nil {
+			logger.Error("Failed to purge expired tokens", zap.Error(err))
+		} else if purged > 0 {
+			logger.Info("Purged expired tokens", zap.Int64("count", purged))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
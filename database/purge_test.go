@@ -0,0 +1,81 @@
+package database_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"example.com/ginhello/database"
+	"example.com/ginhello/models"
+	"example.com/ginhello/testutils"
+)
+
+func TestPurgeDeletedUsers(t *testing.T) {
+	// Setup
+	db, _ := testutils.SetupTestDB(t)
+	active := testutils.CreateTestUser(t, db, "active", "active@example.com", "pw")
+	expired := testutils.CreateTestUser(t, db, "expired", "expired@example.com", "pw")
+	recent := testutils.CreateTestUser(t, db, "recent", "recent@example.com", "pw")
+
+	now := time.Now()
+	assert.NoError(t, db.Unscoped().Model(&expired).Update("deleted_at", now.Add(-48*time.Hour)).Error)
+	assert.NoError(t, db.Unscoped().Model(&recent).Update("deleted_at", now.Add(-time.Hour)).Error)
+
+	// Test
+	purged, err := database.PurgeDeletedUsers(context.Background(), db, now.Add(-24*time.Hour))
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+
+	var remaining []models.User
+	assert.NoError(t, db.Unscoped().Order("id").Find(&remaining).Error)
+	if assert.Len(t, remaining, 2) {
+		assert.Equal(t, active.ID, remaining[0].ID)
+		assert.Equal(t, recent.ID, remaining[1].ID)
+	}
+}
+
+func TestPurgeExpiredTokens(t *testing.T) {
+	// Setup
+	db, _ := testutils.SetupTestDB(t)
+	user := testutils.CreateTestUser(t, db, "resetting", "resetting@example.com", "pw")
+	now := time.Now()
+	assert.NoError(t, db.Create(&[]models.PasswordResetToken{
+		{UserID: user.ID, TokenHash: "expired", ExpiresAt: now.Add(-time.Minute)},
+		{UserID: user.ID, TokenHash: "valid", ExpiresAt: now.Add(time.Hour)},
+	}).Error)
+	assert.NoError(t, db.Create(&models.EmailVerificationToken{
+		UserID: user.ID, Email: user.Email, TokenHash: "expired", ExpiresAt: now.Add(-time.Minute),
+	}).Error)
+
+	// Test
+	purged, err := database.PurgeExpiredTokens(context.Background(), db, now)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), purged)
+
+	var remaining []models.PasswordResetToken
+	assert.NoError(t, db.Find(&remaining).Error)
+	if assert.Len(t, remaining, 1) {
+		assert.Equal(t, "valid", remaining[0].TokenHash)
+	}
+}
+
+func TestMigrate_ReRegisterDeletedUsername(t *testing.T) {
+	// Setup
+	db, _ := testutils.SetupTestDB(t)
+	user := testutils.CreateTestUser(t, db, "reused", "reused@example.com", "pw")
+
+	// Active users still have unique names
+	duplicate := models.User{Username: "reused", Email: "reused@example.com", Password: "x"}
+	assert.Error(t, db.Create(&duplicate).Error)
+
+	// Once the user is deleted its name and email can be registered again
+	assert.NoError(t, db.Delete(&user).Error)
+	duplicate = models.User{Username: "reused", Email: "reused@example.com", Password: "x"}
+	assert.NoError(t, db.Create(&duplicate).Error)
+}
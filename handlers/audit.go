@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"example.com/ginhello/audit"
+	"example.com/ginhello/auth"
+	"example.com/ginhello/logging"
+	"example.com/ginhello/middleware"
+	"example.com/ginhello/models"
+	"example.com/ginhello/normalize"
+	"example.com/ginhello/problem"
+)
+
+const (
+	defaultAuditLimit = 50
+	maxAuditLimit     = 200
+)
+
+// AuditHandler serves the audit log
+type AuditHandler struct {
+	recorder *audit.Recorder
+	logger   *zap.Logger
+}
+
+// NewAuditHandler creates a new AuditHandler
+func NewAuditHandler(recorder *audit.Recorder, logger *zap.Logger) *AuditHandler {
+	return &AuditHandler{
+		recorder: recorder,
+		logger:   logger,
+	}
+}
+
+// AuditListResponse is a page of audit events
+type AuditListResponse struct {
+	Events []models.AuditEvent `json:"events"`
+	Total  int64               `json:"total"`
+	Limit  int                 `json:"limit"`
+	Offset int                 `json:"offset"`
+}
+
+// List returns audit events, newest first (admin only). Supported query
+// parameters are actor_id, actor, action, outcome, target_id, since and until
+// (RFC 3339) for filtering and limit and offset for pagination.
+func (h *AuditHandler) List(c *gin.Context) {
+	logger := logging.FromContext(c, h.logger)
+	ctx := c.Request.Context()
+
+	filter := audit.Filter{
+		ActorUsername: normalize.Username(c.Query("actor")),
+		Action:        c.Query("action"),
+		Outcome:       c.Query("outcome"),
+		TargetID:      c.Query("target_id"),
+	}
+	if actorID := c.Query("actor_id"); actorID != "" {
+		id, err := strconv.ParseUint(actorID, 10, 32)
+		if err != nil {
+			problem.Error(c, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid actor_id")
+			return
+		}
+		uid := uint(id)
+		filter.ActorID = &uid
+	}
+	var err error
+	if filter.Since, err = parseTimeQuery(c, "since"); err != nil {
+		problem.Error(c, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid since, expected an RFC 3339 timestamp")
+		return
+	}
+	if filter.Until, err = parseTimeQuery(c, "until"); err != nil {
+		problem.Error(c, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid until, expected an RFC 3339 timestamp")
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultAuditLimit)))
+	if err != nil || limit < 1 || limit > maxAuditLimit {
+		problem.Error(c, http.StatusBadRequest, problem.CodeInvalidRequest, "limit must be between 1 and "+strconv.Itoa(maxAuditLimit))
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		problem.Error(c, http.StatusBadRequest, problem.CodeInvalidRequest, "offset must not be negative")
+		return
+	}
+
+	events, total, err := h.recorder.List(ctx, filter, limit, offset)
+	if err != nil {
+		logger.Error("Database error fetching audit events", zap.Error(err))
+		problem.Error(c, http.StatusInternalServerError, problem.CodeInternal, "Database error")
+		return
+	}
+
+	c.JSON(http.StatusOK, AuditListResponse{
+		Events: events,
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+	})
+}
+
+// parseTimeQuery parses an optional RFC 3339 query parameter
+func parseTimeQuery(c *gin.Context, key string) (time.Time, error) {
+	value := c.Query(key)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// newAuditEvent creates an audit event for the current request, filling in
+// the client details, the request ID and, when authenticated, the actor
+func newAuditEvent(c *gin.Context, action, outcome string) *models.AuditEvent {
+	event := &models.AuditEvent{
+		Action:    action,
+		Outcome:   outcome,
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		RequestID: middleware.RequestIDFrom(c),
+	}
+	if principal, ok := auth.PrincipalFrom(c); ok {
+		actorID := principal.UserID
+		event.ActorID = &actorID
+		event.ActorUsername = principal.Username
+	}
+	return event
+}
+
+// withActor sets the actor of event to user
+func withActor(event *models.AuditEvent, user *models.User) *models.AuditEvent {
+	id := user.ID
+	event.ActorID = &id
+	event.ActorUsername = user.Username
+	return event
+}
+
+// withTarget sets the target of event to the user with the given ID
+func withTarget(event *models.AuditEvent, userID uint) *models.AuditEvent {
+	event.TargetType = audit.TargetUser
+	event.TargetID = strconv.FormatUint(uint64(userID), 10)
+	return event
+}
@@ -0,0 +1,149 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"example.com/ginhello/audit"
+	"example.com/ginhello/auth"
+	"example.com/ginhello/handlers"
+	"example.com/ginhello/models"
+	"example.com/ginhello/testutils"
+)
+
+func TestAuthHandler_Login_Audit(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db, logger := testutils.SetupTestDB(t)
+	cfg := testutils.SetupTestConfig(t)
+	recorder := audit.NewRecorder(db, logger)
+	authHandler := handlers.NewAuthHandler(auth.NewJWTService(cfg, logger), db, logger, handlers.WithAuditRecorder(recorder))
+	testUser := testutils.CreateTestUser(t, db, "audituser", "audit@example.com", "password123")
+
+	login := func(username, password string) {
+		body, _ := json.Marshal(map[string]string{"username": username, "password": password})
+		req := httptest.NewRequest("POST", "/api/auth/login", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "audit-test")
+		req.RemoteAddr = "192.0.2.1:1234"
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = req
+		authHandler.Login(c)
+	}
+
+	login("audituser", "password123")
+	login("audituser", "wrong")
+	login("nobody", "password123")
+
+	// Assert, newest first
+	events, total, err := recorder.List(context.Background(), audit.Filter{Action: audit.ActionLogin}, 10, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), total)
+	if assert.Len(t, events, 3) {
+		assert.Equal(t, audit.OutcomeFailure, events[0].Outcome)
+		assert.Equal(t, "nobody", events[0].ActorUsername)
+		assert.Nil(t, events[0].ActorID)
+		assert.Equal(t, "unknown_user", events[0].Reason)
+
+		assert.Equal(t, audit.OutcomeFailure, events[1].Outcome)
+		assert.Equal(t, testUser.ID, *events[1].ActorID)
+		assert.Equal(t, "wrong_password", events[1].Reason)
+
+		assert.Equal(t, audit.OutcomeSuccess, events[2].Outcome)
+		assert.Equal(t, testUser.ID, *events[2].ActorID)
+		assert.Equal(t, "192.0.2.1", events[2].IP)
+		assert.Equal(t, "audit-test", events[2].UserAgent)
+	}
+}
+
+func TestCreateUser_Audit(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db, logger := testutils.SetupTestDB(t)
+	recorder := audit.NewRecorder(db, logger)
+	userHandler := handlers.NewUserHandler(db, logger, handlers.WithUserAuditRecorder(recorder))
+
+	create := func() {
+		body := `{"username":"audited","email":"audited@example.com","password":"correct-horse-battery"}`
+		req := httptest.NewRequest("POST", "/api/users", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = req
+		userHandler.CreateUser(c)
+	}
+
+	create()
+	create() // Conflict
+
+	events, _, err := recorder.List(context.Background(), audit.Filter{Action: audit.ActionUserCreate}, 10, 0)
+	assert.NoError(t, err)
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, audit.OutcomeFailure, events[0].Outcome)
+		assert.Equal(t, "conflict", events[0].Reason)
+		assert.Equal(t, audit.OutcomeSuccess, events[1].Outcome)
+		assert.Equal(t, audit.TargetUser, events[1].TargetType)
+		assert.NotEmpty(t, events[1].TargetID)
+	}
+}
+
+func TestAuditHandler_List(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db, logger := testutils.SetupTestDB(t)
+	recorder := audit.NewRecorder(db, logger)
+	auditHandler := handlers.NewAuditHandler(recorder, logger)
+
+	for i := 0; i < 3; i++ {
+		recorder.Record(context.Background(), &models.AuditEvent{Action: audit.ActionLogin, Outcome: audit.OutcomeSuccess, ActorUsername: "user" + strconv.Itoa(i)})
+	}
+	recorder.Record(context.Background(), &models.AuditEvent{Action: audit.ActionLogin, Outcome: audit.OutcomeFailure, ActorUsername: "user0"})
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedTotal  int64
+		expectedLen    int
+	}{
+		{"Default page", "", http.StatusOK, 4, 4},
+		{"Filtered", "?outcome=failure", http.StatusOK, 1, 1},
+		{"By actor", "?actor=user0", http.StatusOK, 2, 2},
+		{"Paginated", "?limit=2&offset=3", http.StatusOK, 4, 1},
+		{"Since in the future", "?since=2999-01-01T00:00:00Z", http.StatusOK, 0, 0},
+		{"Invalid limit", "?limit=0", http.StatusBadRequest, 0, 0},
+		{"Limit too large", "?limit=1000", http.StatusBadRequest, 0, 0},
+		{"Invalid offset", "?offset=-1", http.StatusBadRequest, 0, 0},
+		{"Invalid since", "?since=yesterday", http.StatusBadRequest, 0, 0},
+		{"Invalid actor ID", "?actor_id=abc", http.StatusBadRequest, 0, 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("GET", "/api/audit"+tc.query, nil)
+
+			auditHandler.List(c)
+
+			assert.Equal(t, tc.expectedStatus, w.Code)
+			if tc.expectedStatus != http.StatusOK {
+				assert.Contains(t, w.Body.String(), "error")
+				return
+			}
+			var response handlers.AuditListResponse
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Equal(t, tc.expectedTotal, response.Total)
+			assert.Len(t, response.Events, tc.expectedLen)
+		})
+	}
+}
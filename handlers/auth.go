@@ -1,15 +1,25 @@
 package handlers
 
 import (
+	"context"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 
+	"example.com/ginhello/audit"
 	"example.com/ginhello/auth"
+	"example.com/ginhello/logging"
+	"example.com/ginhello/mail"
+	"example.com/ginhello/metrics"
 	"example.com/ginhello/models"
+	"example.com/ginhello/normalize"
+	"example.com/ginhello/password"
+	"example.com/ginhello/problem"
 )
 
 // LoginRequest represents the login request body
@@ -23,99 +33,499 @@ type RefreshRequest struct {
 	RefreshToken string `json:"refresh_token" binding:"required"`
 }
 
+// comparePassword checks a password against a stored hash. It is a variable so
+// that tests can verify how much hashing work each login path performs.
+var comparePassword = (*password.Hasher).Verify
+
+// UnlockRequest represents the optional body for unlocking an account
+type UnlockRequest struct {
+	IP string `json:"ip"` // Also clear failures recorded for this client IP
+}
+
 // AuthHandler handles authentication endpoints
 type AuthHandler struct {
-	jwtService *auth.JWTService
-	db         *gorm.DB
-	logger     *zap.Logger
+	jwtService     *auth.JWTService
+	db             *gorm.DB
+	logger         *zap.Logger
+	accountLimiter *auth.AttemptLimiter
+	ipLimiter      *auth.AttemptLimiter
+	audit          *audit.Recorder
+
+	// Password reset; the endpoints are disabled without a mailer
+	mailer              mail.Mailer
+	resetURL            string
+	resetExpiry         time.Duration
+	resetResendInterval time.Duration
+
+	// Email verification; the endpoints are disabled when nil
+	verification *EmailVerification
+
+	passwordPolicy *password.Policy
+	passwordHasher *password.Hasher
+
+	// Hashes of a dummy password for every algorithm and set of parameters
+	// that stored password hashes use, keyed by password.Params; loaded on
+	// the first login, see verifyLogin
+	dummyMu     sync.Mutex
+	dummyHashes map[string]string
+}
+
+// AuthHandlerOption configures optional AuthHandler behaviour
+type AuthHandlerOption func(*AuthHandler)
+
+// WithLoginLimiters enables failed login tracking per account and per client
+// IP. Either limiter may be nil to disable tracking for that key.
+func WithLoginLimiters(accountLimiter, ipLimiter *auth.AttemptLimiter) AuthHandlerOption {
+	return func(h *AuthHandler) {
+		h.accountLimiter = accountLimiter
+		h.ipLimiter = ipLimiter
+	}
+}
+
+// WithAuditRecorder records logins, token refreshes and unlocks in the audit log
+func WithAuditRecorder(recorder *audit.Recorder) AuthHandlerOption {
+	return func(h *AuthHandler) {
+		h.audit = recorder
+	}
+}
+
+// WithPasswordReset enables the password reset endpoints. Reset links are
+// sent through mailer, point to resetURL and stay valid for expiry. A user is
+// sent at most one link per resendInterval; 0 disables the limit.
+func WithPasswordReset(mailer mail.Mailer, resetURL string, expiry, resendInterval time.Duration) AuthHandlerOption {
+	return func(h *AuthHandler) {
+		h.mailer = mailer
+		h.resetURL = resetURL
+		h.resetExpiry = expiry
+		h.resetResendInterval = resendInterval
+	}
+}
+
+// WithEmailVerification enables the email verification endpoints and, if
+// v.BlockLogin is set, refuses logins to unverified accounts
+func WithEmailVerification(v *EmailVerification) AuthHandlerOption {
+	return func(h *AuthHandler) {
+		h.verification = v
+	}
+}
+
+// WithPasswordPolicy sets the rules for new passwords. Without it
+// password.DefaultPolicy applies.
+func WithPasswordPolicy(policy *password.Policy) AuthHandlerOption {
+	return func(h *AuthHandler) {
+		h.passwordPolicy = policy
+	}
+}
+
+// WithPasswordHasher sets how new passwords are hashed. Stored hashes made
+// with other algorithms or parameters are replaced on login. Without it
+// password.DefaultHasher is used.
+func WithPasswordHasher(hasher *password.Hasher) AuthHandlerOption {
+	return func(h *AuthHandler) {
+		h.passwordHasher = hasher
+	}
 }
 
 // NewAuthHandler creates a new auth handler
-func NewAuthHandler(jwtService *auth.JWTService, db *gorm.DB, logger *zap.Logger) *AuthHandler {
-	return &AuthHandler{
+func NewAuthHandler(jwtService *auth.JWTService, db *gorm.DB, logger *zap.Logger, opts ...AuthHandlerOption) *AuthHandler {
+	h := &AuthHandler{
 		jwtService: jwtService,
 		db:         db,
 		logger:     logger,
 	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	if h.passwordPolicy == nil {
+		h.passwordPolicy = password.DefaultPolicy()
+	}
+	if h.passwordHasher == nil {
+		h.passwordHasher = password.DefaultHasher()
+	}
+	return h
+}
+
+// loadDummyHashes returns a hash of a dummy password for every algorithm and
+// set of parameters that stored password hashes use. Hashes are only ever
+// upgraded to the configured ones, so the set found on the first login covers
+// all later ones; after a failure it is looked up again on the next login.
+func (h *AuthHandler) loadDummyHashes(ctx context.Context) (map[string]string, error) {
+	h.dummyMu.Lock()
+	defer h.dummyMu.Unlock()
+	if h.dummyHashes != nil {
+		return h.dummyHashes, nil
+	}
+
+	current, err := h.passwordHasher.Hash(dummyPassword)
+	if err != nil {
+		return nil, err
+	}
+	hashes := map[string]string{password.Params(current): current}
+
+	// Most users have a hash made with the configured parameters, so only
+	// the others are read. Deleted users are included as they can be restored.
+	var batch []models.User
+	err = h.db.WithContext(ctx).Unscoped().Select("id", "password").
+		Where("password NOT LIKE ?", password.Params(current)+"%").
+		FindInBatches(&batch, 1000, func(*gorm.DB, int) error {
+			for _, user := range batch {
+				params := password.Params(user.Password)
+				if _, ok := hashes[params]; ok || params == "" {
+					continue
+				}
+				hasher, err := password.HasherFor(user.Password)
+				if err != nil {
+					return err
+				}
+				if hashes[params], err = hasher.Hash(dummyPassword); err != nil {
+					return err
+				}
+			}
+			return nil
+		}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	h.dummyHashes = hashes
+	return hashes, nil
+}
+
+// dummyPassword is hashed to do the work of verifying a password without one
+const dummyPassword = "dummy password for timing"
+
+// verifyLogin checks a login password against the stored hash of user, or
+// only does the work of doing so when user is nil. Besides the stored hash it
+// verifies the dummy hash of every other algorithm and set of parameters still
+// stored, so that response times tell neither whether the user exists nor
+// whether their hash predates a change of algorithm or parameters.
+func (h *AuthHandler) verifyLogin(dummies map[string]string, user *models.User, plaintext string) error {
+	err := password.ErrMismatch
+	var stored string
+	if user != nil {
+		stored = password.Params(user.Password)
+		err = comparePassword(h.passwordHasher, user.Password, plaintext)
+	}
+	for params, dummy := range dummies {
+		if user == nil || params != stored {
+			_ = comparePassword(h.passwordHasher, dummy, plaintext)
+		}
+	}
+	return err
+}
+
+// findUserByNormalized finds the user whose column holds value exactly as
+// given or, failing that, its normalized form. Users whose value collided with
+// another user's when existing values were normalized kept it as it was (see
+// database.NormalizeUsers) and are only found by its exact spelling.
+func findUserByNormalized(db *gorm.DB, user *models.User, column, value string, normalizeValue func(string) string) error {
+	err := db.Where(column+" = ?", value).First(user).Error
+	if normalized := normalizeValue(value); err == gorm.ErrRecordNotFound && normalized != value {
+		err = db.Where(column+" = ?", normalized).First(user).Error
+	}
+	return err
 }
 
 // Login handles user login and token generation
 func (h *AuthHandler) Login(c *gin.Context) {
+	logger := logging.FromContext(c, h.logger)
+	ctx := c.Request.Context()
+	authMetrics := h.jwtService.Metrics()
+
 	var req LoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		h.logger.Error("Invalid login request", zap.Error(err))
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		logger.Error("Invalid login request", zap.Error(err))
+		authMetrics.LoginFailed(metrics.LoginReasonInvalidRequest)
+		h.auditLoginFailure(c, nil, "", metrics.LoginReasonInvalidRequest)
+		problem.BindError(c, err)
+		return
+	}
+	username := normalize.Username(req.Username)
+
+	// Every path below does the same hashing work, so this has to succeed
+	// before any of them can be taken
+	dummies, err := h.loadDummyHashes(ctx)
+	if err != nil {
+		logger.Error("Failed to prepare dummy password hashes", zap.Error(err))
+		authMetrics.LoginFailed(metrics.LoginReasonError)
+		h.auditLoginFailure(c, nil, username, metrics.LoginReasonError)
+		problem.Error(c, http.StatusInternalServerError, problem.CodeInternal, "Failed to process request")
+		return
+	}
+
+	// Refuse attempts while the account or client is backing off or locked out.
+	// The response is the same as for wrong credentials so that it reveals
+	// neither whether the account exists nor whether it is locked.
+	accountKey, ipKey := auth.AccountKey(username), auth.IPKey(c.ClientIP())
+	if wait := h.loginWait(accountKey, ipKey); wait > 0 {
+		logger.Warn("Login attempt while throttled", zap.Duration("wait", wait))
+		_ = h.verifyLogin(dummies, nil, req.Password)
+		authMetrics.LoginFailed(metrics.LoginReasonThrottled)
+		h.auditLoginFailure(c, nil, username, metrics.LoginReasonThrottled)
+		problem.Error(c, http.StatusUnauthorized, problem.CodeInvalidCredentials, "Invalid credentials")
 		return
 	}
 
 	// Find user by username
 	var foundUser models.User
-	result := h.db.Where("username = ?", req.Username).First(&foundUser)
-	if result.Error != nil {
-		if result.Error == gorm.ErrRecordNotFound {
-			h.logger.Warn("Login attempt with non-existent user", zap.String("username", req.Username))
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+	if err := findUserByNormalized(h.db.WithContext(ctx), &foundUser, "username", req.Username, normalize.Username); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			// The attempted username is often a mistyped password, so it
+			// is kept out of the logs and only stored in the audit log
+			logger.Warn("Login attempt with non-existent user")
+			_ = h.verifyLogin(dummies, nil, req.Password)
+			h.recordLoginFailure(accountKey, ipKey)
+			authMetrics.LoginFailed(metrics.LoginReasonUnknownUser)
+			h.auditLoginFailure(c, nil, username, metrics.LoginReasonUnknownUser)
+			problem.Error(c, http.StatusUnauthorized, problem.CodeInvalidCredentials, "Invalid credentials")
 		} else {
-			h.logger.Error("Database error during login", zap.Error(result.Error))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			logger.Error("Database error during login", zap.Error(err))
+			authMetrics.LoginFailed(metrics.LoginReasonError)
+			h.auditLoginFailure(c, nil, username, metrics.LoginReasonError)
+			problem.Error(c, http.StatusInternalServerError, problem.CodeInternal, "Database error")
 		}
 		return
 	}
 
 	// Compare password hash
-	compareErr := bcrypt.CompareHashAndPassword([]byte(foundUser.Password), []byte(req.Password))
+	compareErr := h.verifyLogin(dummies, &foundUser, req.Password)
 	if compareErr != nil {
-		h.logger.Warn("Failed login attempt (wrong password)", zap.String("username", req.Username))
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		logger.Warn("Failed login attempt (wrong password)", zap.Uint("user_id", foundUser.ID))
+		h.recordLoginFailure(accountKey, ipKey)
+		authMetrics.LoginFailed(metrics.LoginReasonWrongPassword)
+		h.auditLoginFailure(c, &foundUser.ID, username, metrics.LoginReasonWrongPassword)
+		problem.Error(c, http.StatusUnauthorized, problem.CodeInvalidCredentials, "Invalid credentials")
+		return
+	}
+	if h.passwordHasher.NeedsRehash(foundUser.Password) {
+		h.upgradePasswordHash(c, &foundUser, req.Password)
+	}
+
+	// Only users who know the password learn that the address is unverified
+	if h.verification != nil && h.verification.BlockLogin && foundUser.EmailVerifiedAt == nil {
+		logger.Warn("Login to account with unverified email", zap.Uint("user_id", foundUser.ID))
+		authMetrics.LoginFailed(metrics.LoginReasonUnverified)
+		h.auditLoginFailure(c, &foundUser.ID, username, metrics.LoginReasonUnverified)
+		problem.Error(c, http.StatusForbidden, problem.CodeEmailNotVerified, "Email address has not been verified")
 		return
 	}
 
 	// Generate tokens
-	tokens, err := h.jwtService.GenerateTokenPair(&foundUser)
+	tokens, err := h.jwtService.GenerateTokenPairContext(ctx, &foundUser)
 	if err != nil {
-		h.logger.Error("Failed to generate tokens", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate tokens"})
+		logger.Error("Failed to generate tokens", zap.Error(err))
+		authMetrics.LoginFailed(metrics.LoginReasonError)
+		h.auditLoginFailure(c, &foundUser.ID, username, metrics.LoginReasonError)
+		problem.Error(c, http.StatusInternalServerError, problem.CodeInternal, "Failed to generate tokens")
 		return
 	}
 
-	h.logger.Info("Successful login", zap.String("username", req.Username))
+	// Only the account is reset: a caller must not be able to clear its IP
+	// failures by logging in to an account of its own
+	if h.accountLimiter != nil {
+		h.accountLimiter.Reset(accountKey)
+	}
+
+	// UpdateColumn leaves updated_at, and with it the user's ETag, alone
+	if err := h.db.WithContext(ctx).Model(&foundUser).UpdateColumn("last_login_at", time.Now()).Error; err != nil {
+		logger.Error("Failed to record last login", zap.Uint("user_id", foundUser.ID), zap.Error(err))
+	}
+
+	authMetrics.LoginSucceeded()
+	h.audit.Record(ctx, withActor(newAuditEvent(c, audit.ActionLogin, audit.OutcomeSuccess), &foundUser))
+	logger.Info("Successful login", zap.Uint("user_id", foundUser.ID), zap.String("username", foundUser.Username))
 	c.JSON(http.StatusOK, tokens)
 }
 
+// upgradePasswordHash replaces the stored hash of user, made with an outdated
+// algorithm or parameters, with a new hash of the password they logged in
+// with. The login goes ahead if that fails.
+func (h *AuthHandler) upgradePasswordHash(c *gin.Context, user *models.User, plaintext string) {
+	logger := logging.FromContext(c, h.logger)
+	hash, err := h.passwordHasher.Hash(plaintext)
+	if err != nil {
+		logger.Error("Failed to rehash password", zap.Uint("user_id", user.ID), zap.Error(err))
+		return
+	}
+
+	// Only the hash that was verified is replaced, so a concurrent password
+	// change wins. The tokens and ETag of the user stay valid since the
+	// password itself is unchanged.
+	result := h.db.WithContext(c.Request.Context()).Model(user).
+		Where("password = ?", user.Password).
+		UpdateColumn("password", hash)
+	if result.Error != nil {
+		logger.Error("Failed to store rehashed password", zap.Uint("user_id", user.ID), zap.Error(result.Error))
+		return
+	}
+	if result.RowsAffected > 0 {
+		logger.Info("Upgraded password hash", zap.Uint("user_id", user.ID), zap.String("algorithm", h.passwordHasher.Algorithm))
+	}
+}
+
 // RefreshToken handles token refresh
 func (h *AuthHandler) RefreshToken(c *gin.Context) {
+	logger := logging.FromContext(c, h.logger)
+	ctx := c.Request.Context()
+	authMetrics := h.jwtService.Metrics()
+
 	var req RefreshRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		h.logger.Error("Invalid refresh token request", zap.Error(err))
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		logger.Error("Invalid refresh token request", zap.Error(err))
+		authMetrics.RefreshAttempted(metrics.ResultFailure)
+		problem.BindError(c, err)
 		return
 	}
 
 	// Validate refresh token and get claims
-	claims, err := h.jwtService.ValidateToken(req.RefreshToken)
+	claims, err := h.jwtService.ValidateRefreshTokenContext(ctx, req.RefreshToken)
+	if err != nil && !auth.IsTokenRejected(err) {
+		logger.Error("Failed to validate refresh token", zap.Error(err))
+		authMetrics.RefreshAttempted(metrics.ResultFailure)
+		h.auditRefreshFailure(c, nil, "error")
+		problem.Error(c, http.StatusServiceUnavailable, problem.CodeUnavailable, "Token could not be checked, try again later")
+		return
+	}
 	if err != nil {
-		h.logger.Warn("Invalid refresh token received", zap.Error(err))
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		logger.Warn("Invalid refresh token received", zap.Error(err))
+		authMetrics.RefreshAttempted(metrics.ResultFailure)
+		h.auditRefreshFailure(c, nil, "invalid_token")
+		problem.Error(c, http.StatusUnauthorized, problem.CodeInvalidToken, "Invalid refresh token")
 		return
 	}
 
 	// Fetch user from DB to ensure they still exist
 	var user models.User
-	result := h.db.First(&user, claims.UserID)
+	result := h.db.WithContext(ctx).First(&user, claims.UserID)
 	if result.Error != nil {
-		h.logger.Error("User for refresh token not found in DB", zap.Uint("user_id", claims.UserID))
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User associated with token not found"})
+		logger.Error("User for refresh token not found in DB", zap.Uint("user_id", claims.UserID))
+		authMetrics.RefreshAttempted(metrics.ResultFailure)
+		h.auditRefreshFailure(c, claims, "unknown_user")
+		problem.Error(c, http.StatusUnauthorized, problem.CodeInvalidToken, "User associated with token not found")
+		return
+	}
+
+	// Tokens issued before a password change or deletion are revoked, even
+	// when the JWT service has no revocation checker of its own
+	if user.TokenVersion != claims.TokenVersion {
+		logger.Warn("Revoked refresh token received", zap.Uint("user_id", claims.UserID))
+		authMetrics.RefreshAttempted(metrics.ResultFailure)
+		h.auditRefreshFailure(c, claims, "revoked")
+		problem.Error(c, http.StatusUnauthorized, problem.CodeInvalidToken, "Refresh token has been revoked")
 		return
 	}
 
 	// Generate new tokens using the user data from the DB
-	newTokens, err := h.jwtService.GenerateTokenPair(&user)
+	newTokens, err := h.jwtService.GenerateTokenPairContext(ctx, &user)
 	if err != nil {
-		h.logger.Error("Failed to refresh tokens", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh tokens"})
+		logger.Error("Failed to refresh tokens", zap.Error(err))
+		authMetrics.RefreshAttempted(metrics.ResultFailure)
+		h.auditRefreshFailure(c, claims, "error")
+		problem.Error(c, http.StatusInternalServerError, problem.CodeInternal, "Failed to refresh tokens")
 		return
 	}
 
-	h.logger.Info("Token refreshed successfully", zap.Uint("user_id", claims.UserID))
+	// The new pair replaces the session of the exchanged refresh token
+	h.jwtService.EndSession(claims.TokenID)
+	authMetrics.RefreshAttempted(metrics.ResultSuccess)
+	h.audit.Record(ctx, withActor(newAuditEvent(c, audit.ActionTokenRefresh, audit.OutcomeSuccess), &user))
+
+	logger.Info("Token refreshed successfully", zap.Uint("user_id", claims.UserID))
 	c.JSON(http.StatusOK, newTokens)
 }
+
+// Unlock clears the failed login state of a user account (admin only)
+func (h *AuthHandler) Unlock(c *gin.Context) {
+	logger := logging.FromContext(c, h.logger)
+	ctx := c.Request.Context()
+	idStr := c.Param("id")
+
+	// The body is optional
+	var req UnlockRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			logger.Error("Invalid unlock request", zap.Error(err))
+			problem.BindError(c, err)
+			return
+		}
+	}
+
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		logger.Warn("Invalid user ID format", zap.String("id", idStr), zap.Error(err))
+		problem.Error(c, http.StatusNotFound, problem.CodeNotFound, "User not found")
+		return
+	}
+
+	var user models.User
+	result := h.db.WithContext(ctx).First(&user, uint(id))
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			problem.Error(c, http.StatusNotFound, problem.CodeNotFound, "User not found")
+		} else {
+			logger.Error("Database error fetching user to unlock", zap.Uint64("id", id), zap.Error(result.Error))
+			problem.Error(c, http.StatusInternalServerError, problem.CodeInternal, "Database error")
+		}
+		return
+	}
+
+	if h.accountLimiter != nil {
+		// Login keys attempts on the normalized username, which differs
+		// from the stored one for users that kept theirs after a collision
+		h.accountLimiter.Reset(auth.AccountKey(normalize.Username(user.Username)))
+	}
+	if h.ipLimiter != nil && req.IP != "" {
+		h.ipLimiter.Reset(auth.IPKey(req.IP))
+	}
+
+	h.audit.Record(ctx, withTarget(newAuditEvent(c, audit.ActionUserUnlock, audit.OutcomeSuccess), user.ID))
+	logger.Info("Unlocked user account", zap.Uint("user_id", user.ID), zap.String("ip", req.IP))
+	c.Status(http.StatusNoContent)
+}
+
+// loginWait returns how long the account or client must wait before trying again
+func (h *AuthHandler) loginWait(accountKey, ipKey string) time.Duration {
+	var wait time.Duration
+	if h.accountLimiter != nil {
+		wait = h.accountLimiter.Wait(accountKey)
+	}
+	if h.ipLimiter != nil {
+		wait = max(wait, h.ipLimiter.Wait(ipKey))
+	}
+	return wait
+}
+
+// recordLoginFailure counts a failed login against the account and the client
+func (h *AuthHandler) recordLoginFailure(accountKey, ipKey string) {
+	if h.accountLimiter != nil {
+		h.accountLimiter.Fail(accountKey)
+	}
+	if h.ipLimiter != nil {
+		h.ipLimiter.Fail(ipKey)
+	}
+}
+
+// auditLoginFailure records a failed login attempt for username. The user ID
+// is nil when no such user exists.
+func (h *AuthHandler) auditLoginFailure(c *gin.Context, userID *uint, username, reason string) {
+	event := newAuditEvent(c, audit.ActionLogin, audit.OutcomeFailure)
+	if userID != nil {
+		id := *userID
+		event.ActorID = &id
+	}
+	event.ActorUsername = username
+	event.Reason = reason
+	h.audit.Record(c.Request.Context(), event)
+}
+
+// auditRefreshFailure records a failed token refresh, attributed to the token
+// owner when the token itself was valid
+func (h *AuthHandler) auditRefreshFailure(c *gin.Context, claims *auth.TokenClaims, reason string) {
+	event := newAuditEvent(c, audit.ActionTokenRefresh, audit.OutcomeFailure)
+	if claims != nil {
+		event.ActorID = &claims.UserID
+		event.ActorUsername = claims.Username
+	}
+	event.Reason = reason
+	h.audit.Record(c.Request.Context(), event)
+}
@@ -2,18 +2,22 @@ package handlers_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 
 	"example.com/ginhello/auth"
 	"example.com/ginhello/handlers"
-
-	// "example.com/ginhello/models" // Removed as not directly used here
+	"example.com/ginhello/models"
+	"example.com/ginhello/password"
 	"example.com/ginhello/testutils"
 )
 
@@ -105,6 +109,11 @@ func TestAuthHandler_Login(t *testing.T) {
 				assert.NotEmpty(t, response.AccessToken)
 				assert.NotEmpty(t, response.RefreshToken)
 				assert.Greater(t, response.ExpiresIn, int64(0))
+
+				// The login time is recorded for the user's self view
+				var stored models.User
+				assert.NoError(t, db.First(&stored, testUser.ID).Error)
+				assert.NotNil(t, stored.LastLoginAt)
 			} else {
 				var response map[string]string
 				err := json.NewDecoder(w.Body).Decode(&response)
@@ -115,6 +124,99 @@ func TestAuthHandler_Login(t *testing.T) {
 	}
 }
 
+func TestAuthHandler_Login_CaseInsensitive(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db, logger := testutils.SetupTestDB(t)
+	cfg := testutils.SetupTestConfig(t)
+	authHandler := handlers.NewAuthHandler(auth.NewJWTService(cfg, logger), db, logger)
+	testutils.CreateTestUser(t, db, "casey", "casey@example.com", "password123")
+
+	for _, username := range []string{"casey", "Casey", " CASEY ", "ｃａｓｅｙ"} {
+		t.Run(username, func(t *testing.T) {
+			// Test
+			w := testutils.CallHandler(authHandler.Login, testutils.HandlerRequest{Method: "POST", Path: "/api/auth/login", Body: handlers.LoginRequest{Username: username, Password: "password123"}})
+
+			// Assert
+			assert.Equal(t, http.StatusOK, w.Code)
+		})
+	}
+}
+
+func TestAuthHandler_Login_CollidingUsername(t *testing.T) {
+	// Setup: two users whose usernames collided when existing usernames were
+	// normalized, so "Alice" was kept as it is
+	gin.SetMode(gin.TestMode)
+	db, logger := testutils.SetupTestDB(t)
+	cfg := testutils.SetupTestConfig(t)
+	authHandler := handlers.NewAuthHandler(auth.NewJWTService(cfg, logger), db, logger)
+	assert.NoError(t, db.Migrator().DropIndex(&models.User{}, "idx_users_username_folded"))
+	testutils.CreateTestUser(t, db, "Alice", "alice.upper@example.com", "upper-password")
+	testutils.CreateTestUser(t, db, "alice", "alice.lower@example.com", "lower-password")
+
+	tests := []struct {
+		name           string
+		username       string
+		password       string
+		expectedStatus int
+	}{
+		{name: "Exact spelling of the colliding user", username: "Alice", password: "upper-password", expectedStatus: http.StatusOK},
+		{name: "Normalized spelling finds the normalized user", username: "alice", password: "lower-password", expectedStatus: http.StatusOK},
+		{name: "Normalized spelling does not fall back", username: "alice", password: "upper-password", expectedStatus: http.StatusUnauthorized},
+		{name: "Other spellings find the normalized user", username: "ALICE", password: "lower-password", expectedStatus: http.StatusOK},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Test
+			w := testutils.CallHandler(authHandler.Login, testutils.HandlerRequest{Method: "POST", Path: "/api/auth/login", Body: handlers.LoginRequest{Username: tc.username, Password: tc.password}})
+
+			// Assert
+			assert.Equal(t, tc.expectedStatus, w.Code)
+		})
+	}
+}
+
+func TestAuthHandler_Login_RehashesOutdatedHash(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db, logger := testutils.SetupTestDB(t)
+	cfg := testutils.SetupTestConfig(t)
+	hasher := password.DefaultHasher()
+	authHandler := handlers.NewAuthHandler(auth.NewJWTService(cfg, logger), db, logger,
+		handlers.WithPasswordHasher(hasher))
+
+	// A user whose password was hashed with bcrypt before argon2id was introduced
+	legacy := password.DefaultHasher()
+	legacy.Algorithm = password.AlgorithmBcrypt
+	legacyHash, err := legacy.Hash("password123")
+	assert.NoError(t, err)
+	user := testutils.CreateTestUser(t, db, "legacy", "legacy@example.com", "password123")
+	assert.NoError(t, db.Model(&user).UpdateColumn("password", legacyHash).Error)
+	var before models.User
+	assert.NoError(t, db.First(&before, user.ID).Error)
+
+	// Test
+	w := testutils.CallHandler(authHandler.Login, testutils.HandlerRequest{Method: "POST", Path: "/api/auth/login", Body: handlers.LoginRequest{Username: "legacy", Password: "password123"}})
+
+	// Assert: the hash is upgraded without revoking tokens or changing the ETag
+	assert.Equal(t, http.StatusOK, w.Code)
+	var after models.User
+	assert.NoError(t, db.First(&after, user.ID).Error)
+	assert.NotEqual(t, legacyHash, after.Password)
+	assert.False(t, hasher.NeedsRehash(after.Password))
+	assert.NoError(t, hasher.Verify(after.Password, "password123"))
+	assert.Equal(t, before.TokenVersion, after.TokenVersion)
+	assert.Equal(t, before.UpdatedAt, after.UpdatedAt)
+
+	// Logging in again leaves the current hash alone
+	w = testutils.CallHandler(authHandler.Login, testutils.HandlerRequest{Method: "POST", Path: "/api/auth/login", Body: handlers.LoginRequest{Username: "legacy", Password: "password123"}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	var again models.User
+	assert.NoError(t, db.First(&again, user.ID).Error)
+	assert.Equal(t, after.Password, again.Password)
+}
+
 func TestAuthHandler_RefreshToken(t *testing.T) {
 	// Setup
 	gin.SetMode(gin.TestMode)
@@ -150,6 +252,14 @@ func TestAuthHandler_RefreshToken(t *testing.T) {
 			expectedStatus: http.StatusUnauthorized,
 			expectedError:  true,
 		},
+		{
+			name: "Access token used as refresh token",
+			requestBody: map[string]interface{}{
+				"refresh_token": tokenPair.AccessToken,
+			},
+			expectedStatus: http.StatusUnauthorized,
+			expectedError:  true,
+		},
 		{
 			name:           "Missing refresh token",
 			requestBody:    map[string]interface{}{},
@@ -208,3 +318,133 @@ func TestAuthHandler_RefreshToken(t *testing.T) {
 		})
 	}
 }
+
+// failingRevocationChecker simulates a revocation checker whose database is
+// unavailable
+type failingRevocationChecker struct{}
+
+func (failingRevocationChecker) Revoked(context.Context, *auth.TokenClaims) (bool, error) {
+	return false, errors.New("database unavailable")
+}
+
+func TestAuthHandler_RefreshToken_RevocationCheckFails(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db, logger := testutils.SetupTestDB(t)
+	cfg := testutils.SetupTestConfig(t)
+	jwtService := auth.NewJWTService(cfg, logger, auth.WithRevocationChecker(failingRevocationChecker{}))
+	authHandler := handlers.NewAuthHandler(jwtService, db, logger)
+	testUser := testutils.CreateTestUser(t, db, "blipuser", "blip@example.com", "password123")
+	tokenPair, _ := jwtService.GenerateTokenPair(&testUser)
+
+	// Test
+	w := testutils.CallHandler(authHandler.RefreshToken, testutils.HandlerRequest{Method: "POST", Path: "/api/auth/refresh", Body: handlers.RefreshRequest{RefreshToken: tokenPair.RefreshToken}})
+
+	// Assert: the client is not told to discard its refresh token
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestAuthHandler_Login_Lockout(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db, logger := testutils.SetupTestDB(t)
+	cfg := testutils.SetupTestConfig(t)
+	jwtService := auth.NewJWTService(cfg, logger)
+	policy := auth.LockoutPolicy{Threshold: 2, LockoutDuration: time.Hour, BaseDelay: 0, MaxDelay: 0}
+	accountLimiter := auth.NewAttemptLimiter(policy)
+	authHandler := handlers.NewAuthHandler(jwtService, db, logger,
+		handlers.WithLoginLimiters(accountLimiter, nil),
+	)
+
+	testUser := testutils.CreateTestUser(t, db, "lockuser", "lock@example.com", "password123")
+
+	login := func(username, password string) *httptest.ResponseRecorder {
+		jsonBody, _ := json.Marshal(map[string]string{"username": username, "password": password})
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/api/auth/login", bytes.NewBuffer(jsonBody))
+		c.Request.Header.Set("Content-Type", "application/json")
+		authHandler.Login(c)
+		return w
+	}
+
+	// Reach the threshold with wrong passwords
+	for i := 0; i < 2; i++ {
+		w := login(testUser.Username, "wrongpassword")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	}
+
+	// The correct password is now refused with the same response
+	locked := login(testUser.Username, "password123")
+	assert.Equal(t, http.StatusUnauthorized, locked.Code)
+
+	// Unknown accounts are tracked the same way, so lockout reveals nothing
+	for i := 0; i < 2; i++ {
+		login("nonexistent", "password123")
+	}
+	unknown := login("nonexistent", "password123")
+	assert.Equal(t, http.StatusUnauthorized, unknown.Code)
+	assert.JSONEq(t, locked.Body.String(), unknown.Body.String())
+
+	// Unlocking the account restores access
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/api/users/"+strconv.Itoa(int(testUser.ID))+"/unlock", nil)
+	c.Params = gin.Params{{Key: "id", Value: strconv.Itoa(int(testUser.ID))}}
+	authHandler.Unlock(c)
+	c.Writer.WriteHeaderNow()
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = login(testUser.Username, "password123")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestAuthHandler_Unlock_NonNormalizedUsername(t *testing.T) {
+	// Setup: a user that kept a mixed-case username when usernames were
+	// normalized, locked out after a wrong password
+	gin.SetMode(gin.TestMode)
+	db, logger := testutils.SetupTestDB(t)
+	cfg := testutils.SetupTestConfig(t)
+	policy := auth.LockoutPolicy{Threshold: 1, LockoutDuration: time.Hour}
+	authHandler := handlers.NewAuthHandler(auth.NewJWTService(cfg, logger), db, logger,
+		handlers.WithLoginLimiters(auth.NewAttemptLimiter(policy), nil),
+	)
+	testUser := testutils.CreateTestUser(t, db, "MixedCase", "mixed@example.com", "password123")
+
+	login := func() int {
+		return testutils.CallHandler(authHandler.Login, testutils.HandlerRequest{Method: "POST", Path: "/api/auth/login", Body: handlers.LoginRequest{Username: "MixedCase", Password: "password123"}}).Code
+	}
+	testutils.CallHandler(authHandler.Login, testutils.HandlerRequest{Method: "POST", Path: "/api/auth/login", Body: handlers.LoginRequest{Username: "MixedCase", Password: "wrongpassword"}})
+	assert.Equal(t, http.StatusUnauthorized, login())
+
+	// Test
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/api/users/"+strconv.Itoa(int(testUser.ID))+"/unlock", nil)
+	c.Params = gin.Params{{Key: "id", Value: strconv.Itoa(int(testUser.ID))}}
+	authHandler.Unlock(c)
+	c.Writer.WriteHeaderNow()
+
+	// Assert
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, http.StatusOK, login())
+}
+
+func TestAuthHandler_Unlock_NotFound(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db, logger := testutils.SetupTestDB(t)
+	cfg := testutils.SetupTestConfig(t)
+	authHandler := handlers.NewAuthHandler(auth.NewJWTService(cfg, logger), db, logger)
+
+	for _, id := range []string{"9999", "abc"} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/api/users/"+id+"/unlock", nil)
+		c.Params = gin.Params{{Key: "id", Value: id}}
+
+		authHandler.Unlock(c)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	}
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+
+	"example.com/ginhello/auth"
+	"example.com/ginhello/password"
+	"example.com/ginhello/testutils"
+)
+
+// TestLogin_EqualHashingWork verifies that logging in as an unknown user does
+// the same hashing work as logging in with a wrong password, whichever
+// algorithm the user's password was hashed with, so response times cannot be
+// used to enumerate usernames.
+func TestLogin_EqualHashingWork(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db, logger := testutils.SetupTestDB(t)
+	cfg := testutils.SetupTestConfig(t)
+	policy := auth.LockoutPolicy{Threshold: 1, LockoutDuration: time.Hour}
+	authHandler := NewAuthHandler(auth.NewJWTService(cfg, logger), db, logger,
+		WithLoginLimiters(auth.NewAttemptLimiter(policy), nil),
+	)
+	testUser := testutils.CreateTestUser(t, db, "timinguser", "timing@example.com", "password123")
+
+	// Users whose hashes predate argon2id or the current argon2id parameters
+	// and who have not logged in since
+	staleHash := func(change func(h *password.Hasher)) string {
+		hasher := password.DefaultHasher()
+		change(hasher)
+		hash, err := hasher.Hash("password123")
+		assert.NoError(t, err)
+		return hash
+	}
+	staleHashes := map[string]string{
+		"bcryptlow":  staleHash(func(h *password.Hasher) { h.Algorithm, h.BcryptCost = password.AlgorithmBcrypt, bcrypt.MinCost }),
+		"bcrypthigh": staleHash(func(h *password.Hasher) { h.Algorithm, h.BcryptCost = password.AlgorithmBcrypt, bcrypt.MinCost+1 }),
+		"argon2old":  staleHash(func(h *password.Hasher) { h.Argon2.Iterations = 1 }),
+	}
+	for username, hash := range staleHashes {
+		user := testutils.CreateTestUser(t, db, username, username+"@example.com", "password123")
+		assert.NoError(t, db.Model(&user).UpdateColumn("password", hash).Error)
+	}
+
+	// Record the cost of every hash compared
+	var costs []string
+	original := comparePassword
+	comparePassword = func(hasher *password.Hasher, hash, plaintext string) error {
+		costs = append(costs, password.Params(hash))
+		return original(hasher, hash, plaintext)
+	}
+	t.Cleanup(func() { comparePassword = original })
+
+	login := func(username string) int {
+		jsonBody, _ := json.Marshal(map[string]string{"username": username, "password": "wrongpassword"})
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/api/auth/login", bytes.NewBuffer(jsonBody))
+		c.Request.Header.Set("Content-Type", "application/json")
+		authHandler.Login(c)
+		return w.Code
+	}
+
+	tests := []struct {
+		name     string
+		username string
+	}{
+		{name: "Existing user, wrong password", username: testUser.Username},
+		{name: "Bcrypt user, wrong password", username: "bcryptlow"},
+		{name: "Bcrypt user with another cost, wrong password", username: "bcrypthigh"},
+		{name: "Argon2id user with old parameters, wrong password", username: "argon2old"},
+		{name: "Unknown user", username: "nonexistent"},
+		{name: "Locked out user", username: testUser.Username},
+	}
+
+	// Every path compares one hash of each algorithm and set of parameters
+	// stored
+	expected := []string{password.Params(testUser.Password)}
+	for _, hash := range staleHashes {
+		expected = append(expected, password.Params(hash))
+	}
+	slices.Sort(expected)
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			costs = nil
+
+			assert.Equal(t, http.StatusUnauthorized, login(tc.username))
+
+			slices.Sort(costs)
+			assert.Equal(t, expected, costs)
+		})
+	}
+}
+
+func TestLogin_DummyHashesRetried(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db, logger := testutils.SetupTestDB(t)
+	cfg := testutils.SetupTestConfig(t)
+	authHandler := NewAuthHandler(auth.NewJWTService(cfg, logger), db, logger)
+	testutils.CreateTestUser(t, db, "retryuser", "retry@example.com", "password123")
+	login := func() int {
+		jsonBody, _ := json.Marshal(map[string]string{"username": "retryuser", "password": "password123"})
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/api/auth/login", bytes.NewBuffer(jsonBody))
+		c.Request.Header.Set("Content-Type", "application/json")
+		authHandler.Login(c)
+		return w.Code
+	}
+
+	// Test: stored hashes cannot be looked up
+	assert.NoError(t, db.Migrator().RenameTable("users", "users_unavailable"))
+	failed := login()
+	assert.NoError(t, db.Migrator().RenameTable("users_unavailable", "users"))
+
+	// Assert: the failure is an error rather than a crash, and not remembered
+	assert.Equal(t, http.StatusInternalServerError, failed)
+	assert.Equal(t, http.StatusOK, login())
+}
@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"example.com/ginhello/audit"
+	"example.com/ginhello/logging"
+	"example.com/ginhello/mail"
+	"example.com/ginhello/models"
+	"example.com/ginhello/normalize"
+	"example.com/ginhello/problem"
+)
+
+// EmailVerification configures how users verify their email addresses
+type EmailVerification struct {
+	Mailer         mail.Mailer
+	URL            string // Links are URL with the token appended as "token"
+	Expiry         time.Duration
+	ResendInterval time.Duration // Minimum time between two emails to a user
+	BlockLogin     bool          // Refuse logins until the address is verified
+}
+
+// VerifyEmailRequest represents the body for verifying an email address
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// ResendVerificationRequest represents the body for requesting another
+// verification email
+type ResendVerificationRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// errVerificationThrottled is returned when a user was sent a verification
+// email less than the resend interval ago
+var errVerificationThrottled = errors.New("verification email sent too recently")
+
+// VerifyEmail marks a user's email address as verified using a token from a
+// verification email. Tokens sent to an address the user has since changed
+// no longer work. Tokens issued before verification keep the email_verified
+// claim they were issued with until they are refreshed.
+func (h *AuthHandler) VerifyEmail(c *gin.Context) {
+	logger := logging.FromContext(c, h.logger)
+	ctx := c.Request.Context()
+	if h.verification == nil {
+		problem.Error(c, http.StatusNotFound, problem.CodeNotFound, "Email verification is not enabled")
+		return
+	}
+
+	var req VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Warn("Invalid email verification request", zap.Error(err))
+		problem.BindError(c, err)
+		return
+	}
+
+	var token models.EmailVerificationToken
+	var user models.User
+	now := time.Now()
+	err := h.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", hashSecretToken(req.Token), now).
+			First(&token).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("email = ?", token.Email).First(&user, token.UserID).Error; err != nil {
+			return err
+		}
+
+		result := tx.Model(&token).Where("used_at IS NULL").Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errTokenUsed
+		}
+		if user.EmailVerifiedAt != nil {
+			return nil
+		}
+		// The verification state is part of the self view, so the ETag changes
+		return tx.Model(&user).Updates(map[string]interface{}{
+			"email_verified_at": now,
+			"updated_at":        versionTimestamp(),
+		}).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, errTokenUsed) {
+			logger.Warn("Email verification with invalid or expired token")
+			event := newAuditEvent(c, audit.ActionEmailVerify, audit.OutcomeFailure)
+			event.Reason = "invalid_token"
+			h.audit.Record(ctx, event)
+			problem.Error(c, http.StatusBadRequest, problem.CodeInvalidToken, "Invalid or expired verification token")
+		} else {
+			logger.Error("Failed to verify email", zap.Error(err))
+			problem.Error(c, http.StatusInternalServerError, problem.CodeInternal, "Failed to verify email")
+		}
+		return
+	}
+
+	h.audit.Record(ctx, withActor(newAuditEvent(c, audit.ActionEmailVerify, audit.OutcomeSuccess), &user))
+	logger.Info("Verified email address", zap.Uint("user_id", user.ID))
+	c.Status(http.StatusNoContent)
+}
+
+// ResendVerification sends another verification email to the user with the
+// given address, unless it is already verified or the user was sent one less
+// than the resend interval ago. Like ForgotPassword, the response does not
+// reveal whether the user exists.
+func (h *AuthHandler) ResendVerification(c *gin.Context) {
+	logger := logging.FromContext(c, h.logger)
+	if h.verification == nil {
+		problem.Error(c, http.StatusNotFound, problem.CodeNotFound, "Email verification is not enabled")
+		return
+	}
+
+	var req ResendVerificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Warn("Invalid verification resend request", zap.Error(err))
+		problem.BindError(c, err)
+		return
+	}
+
+	go func(ctx context.Context) {
+		var user models.User
+		if err := findUserByNormalized(h.db.WithContext(ctx), &user, "email", req.Email, normalize.Email); err != nil {
+			if err != gorm.ErrRecordNotFound {
+				logger.Error("Database error fetching user for verification email", zap.Error(err))
+			}
+			return
+		}
+		if user.EmailVerifiedAt != nil {
+			return
+		}
+		err := h.verification.send(ctx, h.db, &user, false)
+		if err != nil && !errors.Is(err, errVerificationThrottled) {
+			logger.Error("Failed to send verification email", zap.Uint("user_id", user.ID), zap.Error(err))
+		}
+	}(context.WithoutCancel(c.Request.Context()))
+
+	c.Status(http.StatusAccepted)
+}
+
+// send emails user a link to verify their current address. Unless force is
+// set, nothing is sent if the user was sent a link less than the resend
+// interval ago. Links sent earlier stop working.
+func (v *EmailVerification) send(ctx context.Context, db *gorm.DB, user *models.User, force bool) error {
+	token, err := newSecretToken()
+	if err != nil {
+		return err
+	}
+	link, err := tokenLink(v.URL, token)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	err = db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if !force && v.ResendInterval > 0 {
+			var recent int64
+			if err := tx.Model(&models.EmailVerificationToken{}).
+				Where("user_id = ? AND created_at > ?", user.ID, now.Add(-v.ResendInterval)).
+				Count(&recent).Error; err != nil {
+				return err
+			}
+			if recent > 0 {
+				return errVerificationThrottled
+			}
+		}
+
+		if err := tx.Model(&models.EmailVerificationToken{}).
+			Where("user_id = ? AND used_at IS NULL", user.ID).
+			Update("used_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.EmailVerificationToken{
+			UserID:    user.ID,
+			Email:     user.Email,
+			TokenHash: hashSecretToken(token),
+			ExpiresAt: now.Add(v.Expiry),
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	msg, err := mail.Render(mail.TemplateEmailVerification, user.Email, mail.EmailVerificationData{
+		Username:  user.Username,
+		VerifyURL: link,
+		ExpiresIn: humanDuration(v.Expiry),
+	})
+	if err != nil {
+		return err
+	}
+	return v.Mailer.Send(ctx, msg)
+}
+
+// sendInBackground sends user a verification email without holding up the
+// request, logging failures
+func (v *EmailVerification) sendInBackground(c *gin.Context, db *gorm.DB, logger *zap.Logger, user models.User) {
+	go func(ctx context.Context) {
+		if err := v.send(ctx, db, &user, true); err != nil {
+			logger.Error("Failed to send verification email", zap.Uint("user_id", user.ID), zap.Error(err))
+			return
+		}
+		logger.Info("Sent verification email", zap.Uint("user_id", user.ID))
+	}(context.WithoutCancel(c.Request.Context()))
+}
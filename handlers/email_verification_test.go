@@ -0,0 +1,163 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"example.com/ginhello/auth"
+	"example.com/ginhello/handlers"
+	"example.com/ginhello/mail"
+	"example.com/ginhello/models"
+	"example.com/ginhello/problem"
+	"example.com/ginhello/testutils"
+)
+
+// verifyLinkPattern extracts the token from a verification email
+var verifyLinkPattern = regexp.MustCompile(`https://app\.example\.com/verify\?token=(\S+)`)
+
+// newEmailVerification returns a verification setup sending to a memory mailer
+func newEmailVerification(blockLogin bool) (*handlers.EmailVerification, *mail.MemoryMailer) {
+	mailer := mail.NewMemoryMailer()
+	return &handlers.EmailVerification{
+		Mailer:         mailer,
+		URL:            "https://app.example.com/verify",
+		Expiry:         24 * time.Hour,
+		ResendInterval: time.Minute,
+		BlockLogin:     blockLogin,
+	}, mailer
+}
+
+// awaitVerificationToken waits for the n-th email sent by mailer and returns
+// the verification token in it
+func awaitVerificationToken(t *testing.T, mailer *mail.MemoryMailer, n int) string {
+	t.Helper()
+	assert.Eventually(t, func() bool { return len(mailer.Messages()) >= n }, time.Second, 5*time.Millisecond)
+	match := verifyLinkPattern.FindStringSubmatch(mailer.Messages()[n-1].Body)
+	if !assert.NotNil(t, match) {
+		t.FailNow()
+	}
+	token, err := url.QueryUnescape(match[1])
+	assert.NoError(t, err)
+	return token
+}
+
+func TestEmailVerification(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db, logger := testutils.SetupTestDB(t)
+	cfg := testutils.SetupTestConfig(t)
+	verification, mailer := newEmailVerification(false)
+	userHandler := handlers.NewUserHandler(db, logger, handlers.WithUserEmailVerification(verification))
+	authHandler := handlers.NewAuthHandler(auth.NewJWTService(cfg, logger), db, logger,
+		handlers.WithEmailVerification(verification))
+
+	// Test: creating a user sends a verification email
+	w := testutils.CallHandler(userHandler.CreateUser, testutils.HandlerRequest{Method: "POST", Path: "/api/users", Body: handlers.CreateUserRequest{
+		Username: "verifier", Email: "verifier@example.com", Password: "password123",
+	}})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	token := awaitVerificationToken(t, mailer, 1)
+	assert.Equal(t, "verifier@example.com", mailer.Messages()[0].To)
+
+	w = testutils.CallHandler(authHandler.VerifyEmail, testutils.HandlerRequest{Method: "POST", Path: "/api/auth/email/verify", Body: handlers.VerifyEmailRequest{Token: token}})
+
+	// Assert
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	var stored models.User
+	assert.NoError(t, db.Where("username = ?", "verifier").First(&stored).Error)
+	assert.NotNil(t, stored.EmailVerifiedAt)
+
+	// The token cannot be used twice
+	w = testutils.CallHandler(authHandler.VerifyEmail, testutils.HandlerRequest{Method: "POST", Path: "/api/auth/email/verify", Body: handlers.VerifyEmailRequest{Token: token}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestEmailVerification_ChangedEmail(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db, logger := testutils.SetupTestDB(t)
+	cfg := testutils.SetupTestConfig(t)
+	verification, mailer := newEmailVerification(false)
+	authHandler := handlers.NewAuthHandler(auth.NewJWTService(cfg, logger), db, logger,
+		handlers.WithEmailVerification(verification))
+	user := testutils.CreateTestUser(t, db, "mover", "old@example.com", "password123")
+
+	w := testutils.CallHandler(authHandler.ResendVerification, testutils.HandlerRequest{Method: "POST", Path: "/api/auth/email/resend", Body: handlers.ResendVerificationRequest{Email: "old@example.com"}})
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	token := awaitVerificationToken(t, mailer, 1)
+
+	// Test: a link sent to the old address does not verify the new one
+	assert.NoError(t, db.Model(&user).Update("email", "new@example.com").Error)
+	w = testutils.CallHandler(authHandler.VerifyEmail, testutils.HandlerRequest{Method: "POST", Path: "/api/auth/email/verify", Body: handlers.VerifyEmailRequest{Token: token}})
+
+	// Assert
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var response map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, problem.CodeInvalidToken, response["error_code"])
+}
+
+func TestAuthHandler_ResendVerification_Throttled(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db, logger := testutils.SetupTestDB(t)
+	cfg := testutils.SetupTestConfig(t)
+	verification, mailer := newEmailVerification(false)
+	authHandler := handlers.NewAuthHandler(auth.NewJWTService(cfg, logger), db, logger,
+		handlers.WithEmailVerification(verification))
+	testutils.CreateTestUser(t, db, "impatient", "impatient@example.com", "password123")
+
+	// Test
+	first := testutils.CallHandler(authHandler.ResendVerification, testutils.HandlerRequest{Method: "POST", Path: "/api/auth/email/resend", Body: handlers.ResendVerificationRequest{Email: "impatient@example.com"}})
+	awaitVerificationToken(t, mailer, 1)
+	second := testutils.CallHandler(authHandler.ResendVerification, testutils.HandlerRequest{Method: "POST", Path: "/api/auth/email/resend", Body: handlers.ResendVerificationRequest{Email: "impatient@example.com"}})
+	unknown := testutils.CallHandler(authHandler.ResendVerification, testutils.HandlerRequest{Method: "POST", Path: "/api/auth/email/resend", Body: handlers.ResendVerificationRequest{Email: "nobody@example.com"}})
+
+	// Assert: every request looks the same, but only one email is sent
+	for _, w := range []int{first.Code, second.Code, unknown.Code} {
+		assert.Equal(t, http.StatusAccepted, w)
+	}
+	assert.Never(t, func() bool { return len(mailer.Messages()) > 1 }, 100*time.Millisecond, 5*time.Millisecond)
+}
+
+func TestAuthHandler_Login_UnverifiedEmailBlocked(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db, logger := testutils.SetupTestDB(t)
+	cfg := testutils.SetupTestConfig(t)
+	verification, _ := newEmailVerification(true)
+	authHandler := handlers.NewAuthHandler(auth.NewJWTService(cfg, logger), db, logger,
+		handlers.WithEmailVerification(verification))
+	user := testutils.CreateTestUser(t, db, "unverified", "unverified@example.com", "password123")
+	login := handlers.LoginRequest{Username: "unverified", Password: "password123"}
+
+	// Test
+	w := testutils.CallHandler(authHandler.Login, testutils.HandlerRequest{Method: "POST", Path: "/api/auth/login", Body: login})
+
+	// Assert
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	var response map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, problem.CodeEmailNotVerified, response["error_code"])
+
+	// Wrong passwords do not reveal the verification state
+	w = testutils.CallHandler(authHandler.Login, testutils.HandlerRequest{Method: "POST", Path: "/api/auth/login", Body: handlers.LoginRequest{Username: "unverified", Password: "wrong"}})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Once verified, the user can log in and the tokens say so
+	assert.NoError(t, db.Model(&user).Update("email_verified_at", time.Now()).Error)
+	w = testutils.CallHandler(authHandler.Login, testutils.HandlerRequest{Method: "POST", Path: "/api/auth/login", Body: login})
+	assert.Equal(t, http.StatusOK, w.Code)
+	var tokens auth.TokenPair
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &tokens))
+	claims, err := auth.NewJWTService(cfg, logger).ValidateAccessToken(tokens.AccessToken)
+	assert.NoError(t, err)
+	assert.True(t, claims.EmailVerified)
+}
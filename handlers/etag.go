@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"example.com/ginhello/models"
+	"example.com/ginhello/problem"
+)
+
+// userETag returns the entity tag of a user's current version. It is derived
+// from UpdatedAt at microsecond precision, which is what Postgres stores.
+func userETag(user *models.User) string {
+	return `"` + strconv.FormatUint(uint64(user.ID), 10) + "-" + strconv.FormatInt(user.UpdatedAt.UnixMicro(), 10) + `"`
+}
+
+// versionTimestamp returns the time to store as UpdatedAt on a modification,
+// truncated so that the ETag computed from it survives a round trip through
+// the database
+func versionTimestamp() time.Time {
+	return time.Now().Truncate(time.Microsecond)
+}
+
+// checkIfMatch enforces optimistic concurrency: the request must carry an
+// If-Match header matching etag. It renders a 428 or 412 problem and returns
+// false otherwise.
+func checkIfMatch(c *gin.Context, etag string) bool {
+	ifMatch := c.GetHeader("If-Match")
+	if ifMatch == "" {
+		problem.Error(c, http.StatusPreconditionRequired, problem.CodePreconditionRequired,
+			"If-Match header with the ETag of the current version is required")
+		return false
+	}
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	problem.Error(c, http.StatusPreconditionFailed, problem.CodePreconditionFailed,
+		"The user has been modified; fetch it again and retry")
+	return false
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"example.com/ginhello/audit"
+	"example.com/ginhello/auth"
+	"example.com/ginhello/logging"
+	"example.com/ginhello/models"
+	"example.com/ginhello/password"
+	"example.com/ginhello/problem"
+)
+
+// ChangePasswordRequest represents the body for changing the caller's password
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+// ChangePassword changes the authenticated user's password. The current
+// password must be given. All tokens issued before the change are revoked,
+// including the caller's, so a new token pair is returned.
+func (h *AuthHandler) ChangePassword(c *gin.Context) {
+	logger := logging.FromContext(c, h.logger)
+	ctx := c.Request.Context()
+	id, ok := selfID(c)
+	if !ok {
+		return
+	}
+
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Warn("Invalid password change request", zap.Error(err))
+		problem.BindError(c, err)
+		return
+	}
+
+	var user models.User
+	result := h.db.WithContext(ctx).First(&user, id)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			problem.Error(c, http.StatusNotFound, problem.CodeNotFound, "User not found")
+		} else {
+			logger.Error("Database error fetching user for password change", zap.Uint("user_id", id), zap.Error(result.Error))
+			problem.Error(c, http.StatusInternalServerError, problem.CodeInternal, "Database error")
+		}
+		return
+	}
+
+	// Wrong current passwords count as failed logins, so that a stolen access
+	// token cannot be used to guess the password without limit
+	accountKey, ipKey := auth.AccountKey(user.Username), auth.IPKey(c.ClientIP())
+	if wait := h.loginWait(accountKey, ipKey); wait > 0 {
+		logger.Warn("Password change attempt while throttled", zap.Uint("user_id", user.ID), zap.Duration("wait", wait))
+		h.auditPasswordChangeFailure(c, &user, "throttled")
+		problem.Error(c, http.StatusForbidden, problem.CodeInvalidCredentials, "Current password is incorrect")
+		return
+	}
+	if err := comparePassword(h.passwordHasher, user.Password, req.CurrentPassword); err != nil {
+		logger.Warn("Password change with wrong current password", zap.Uint("user_id", user.ID))
+		h.recordLoginFailure(accountKey, ipKey)
+		h.auditPasswordChangeFailure(c, &user, "wrong_password")
+		problem.Error(c, http.StatusForbidden, problem.CodeInvalidCredentials, "Current password is incorrect")
+		return
+	}
+
+	fieldErrors := h.checkPassword(c, &user, "new_password", req.NewPassword)
+	if req.NewPassword == req.CurrentPassword {
+		fieldErrors = append(fieldErrors, problem.FieldError{Field: "new_password", Code: "reused", Message: "must differ from the current password"})
+	}
+	if len(fieldErrors) > 0 {
+		h.auditPasswordChangeFailure(c, &user, "policy")
+		problem.Render(c, problem.Validation(fieldErrors...))
+		return
+	}
+
+	hashedPassword, err := h.passwordHasher.Hash(req.NewPassword)
+	if err != nil {
+		logger.Error("Failed to hash password", zap.Error(err))
+		problem.Error(c, http.StatusInternalServerError, problem.CodeInternal, "Failed to process request")
+		return
+	}
+
+	// Bumping the token version revokes every token issued so far. The
+	// version check makes a concurrent change fail instead of being lost.
+	tokenVersion := user.TokenVersion + 1
+	result = h.db.WithContext(ctx).Model(&user).Where("token_version = ?", user.TokenVersion).Updates(map[string]interface{}{
+		"password":      hashedPassword,
+		"token_version": tokenVersion,
+		"updated_at":    versionTimestamp(),
+	})
+	if result.Error != nil {
+		logger.Error("Failed to update password", zap.Uint("user_id", user.ID), zap.Error(result.Error))
+		h.auditPasswordChangeFailure(c, &user, "error")
+		problem.Error(c, http.StatusInternalServerError, problem.CodeInternal, "Failed to change password")
+		return
+	}
+	if result.RowsAffected == 0 {
+		h.auditPasswordChangeFailure(c, &user, "conflict")
+		problem.Error(c, http.StatusConflict, problem.CodeConflict, "The password was changed concurrently")
+		return
+	}
+	user.TokenVersion = tokenVersion
+
+	if h.accountLimiter != nil {
+		h.accountLimiter.Reset(accountKey)
+	}
+
+	tokens, err := h.jwtService.GenerateTokenPairContext(ctx, &user)
+	if err != nil {
+		// The password has been changed; the caller has to log in again
+		logger.Error("Failed to generate tokens after password change", zap.Uint("user_id", user.ID), zap.Error(err))
+		problem.Error(c, http.StatusInternalServerError, problem.CodeInternal, "Failed to generate tokens")
+		return
+	}
+
+	h.audit.Record(ctx, withActor(newAuditEvent(c, audit.ActionPasswordChange, audit.OutcomeSuccess), &user))
+	logger.Info("Changed password", zap.Uint("user_id", user.ID))
+	c.JSON(http.StatusOK, tokens)
+}
+
+// checkPassword applies the password policy to a new password of user
+func (h *AuthHandler) checkPassword(c *gin.Context, user *models.User, field, newPassword string) []problem.FieldError {
+	return checkPassword(c, h.logger, h.passwordPolicy, field, newPassword, password.User{Username: user.Username, Email: user.Email})
+}
+
+// checkPassword applies policy to a new password, reporting the rules it
+// breaks as errors of field. A failed breach lookup is logged and otherwise
+// ignored so that a broken breach file does not stop users from signing up.
+func checkPassword(c *gin.Context, logger *zap.Logger, policy *password.Policy, field, newPassword string, user password.User) []problem.FieldError {
+	violations, err := policy.Check(c.Request.Context(), newPassword, user)
+	if err != nil {
+		logging.FromContext(c, logger).Error("Failed to check password against breaches", zap.Error(err))
+	}
+
+	fieldErrors := make([]problem.FieldError, len(violations))
+	for i, v := range violations {
+		fieldErrors[i] = problem.FieldError{Field: field, Code: v.Code, Message: v.Message}
+	}
+	return fieldErrors
+}
+
+// auditPasswordChangeFailure records a rejected password change
+func (h *AuthHandler) auditPasswordChangeFailure(c *gin.Context, user *models.User, reason string) {
+	event := withActor(newAuditEvent(c, audit.ActionPasswordChange, audit.OutcomeFailure), user)
+	event.Reason = reason
+	h.audit.Record(c.Request.Context(), event)
+}
@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"example.com/ginhello/audit"
+	"example.com/ginhello/auth"
+	"example.com/ginhello/logging"
+	"example.com/ginhello/mail"
+	"example.com/ginhello/models"
+	"example.com/ginhello/normalize"
+	"example.com/ginhello/problem"
+)
+
+// ForgotPasswordRequest represents the body for requesting a password reset
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// ResetPasswordRequest represents the body for resetting a password with a
+// token from a reset email
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
+}
+
+// ForgotPassword emails a password reset link to the user with the given
+// email. The response is the same whether or not the user exists, and the
+// email is sent in the background so that response times do not tell either.
+func (h *AuthHandler) ForgotPassword(c *gin.Context) {
+	logger := logging.FromContext(c, h.logger)
+	if h.mailer == nil {
+		problem.Error(c, http.StatusNotFound, problem.CodeNotFound, "Password reset is not enabled")
+		return
+	}
+
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Warn("Invalid forgot password request", zap.Error(err))
+		problem.BindError(c, err)
+		return
+	}
+
+	// The gin context must not be used once the handler returns
+	event := newAuditEvent(c, audit.ActionPasswordForgot, audit.OutcomeSuccess)
+	go h.sendPasswordReset(context.WithoutCancel(c.Request.Context()), logger, event, req.Email)
+
+	c.Status(http.StatusAccepted)
+}
+
+// errPasswordResetThrottled is returned when a user was sent a password reset
+// email less than the resend interval ago
+var errPasswordResetThrottled = errors.New("password reset email sent too recently")
+
+// sendPasswordReset creates a reset token for the user with the given email,
+// if any, and emails them a link to use it. Earlier tokens stop working,
+// unless the user was sent a link less than the resend interval ago, in which
+// case nothing is sent.
+func (h *AuthHandler) sendPasswordReset(ctx context.Context, logger *zap.Logger, event *models.AuditEvent, email string) {
+	var user models.User
+	if err := findUserByNormalized(h.db.WithContext(ctx), &user, "email", email, normalize.Email); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			logger.Info("Password reset requested for unknown email")
+			event.Outcome = audit.OutcomeFailure
+			event.Reason = "unknown_email"
+			h.audit.Record(ctx, event)
+		} else {
+			logger.Error("Database error fetching user for password reset", zap.Error(err))
+		}
+		return
+	}
+
+	token, err := newSecretToken()
+	if err != nil {
+		logger.Error("Failed to generate password reset token", zap.Error(err))
+		return
+	}
+	link, err := tokenLink(h.resetURL, token)
+	if err != nil {
+		logger.Error("Invalid password reset URL", zap.String("url", h.resetURL), zap.Error(err))
+		return
+	}
+
+	now := time.Now()
+	err = h.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if h.resetResendInterval > 0 {
+			var recent int64
+			if err := tx.Model(&models.PasswordResetToken{}).
+				Where("user_id = ? AND created_at > ?", user.ID, now.Add(-h.resetResendInterval)).
+				Count(&recent).Error; err != nil {
+				return err
+			}
+			if recent > 0 {
+				return errPasswordResetThrottled
+			}
+		}
+
+		if err := tx.Model(&models.PasswordResetToken{}).
+			Where("user_id = ? AND used_at IS NULL", user.ID).
+			Update("used_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.PasswordResetToken{
+			UserID:    user.ID,
+			TokenHash: hashSecretToken(token),
+			ExpiresAt: now.Add(h.resetExpiry),
+		}).Error
+	})
+	if errors.Is(err, errPasswordResetThrottled) {
+		logger.Info("Password reset email sent too recently", zap.Uint("user_id", user.ID))
+		event.Outcome = audit.OutcomeFailure
+		event.Reason = "throttled"
+		h.audit.Record(ctx, withActor(event, &user))
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to store password reset token", zap.Uint("user_id", user.ID), zap.Error(err))
+		return
+	}
+
+	msg, err := mail.Render(mail.TemplatePasswordReset, user.Email, mail.PasswordResetData{
+		Username:  user.Username,
+		ResetURL:  link,
+		ExpiresIn: humanDuration(h.resetExpiry),
+	})
+	if err != nil {
+		logger.Error("Failed to render password reset email", zap.Error(err))
+		return
+	}
+	if err := h.mailer.Send(ctx, msg); err != nil {
+		logger.Error("Failed to send password reset email", zap.Uint("user_id", user.ID), zap.Error(err))
+		return
+	}
+
+	h.audit.Record(ctx, withActor(event, &user))
+	logger.Info("Sent password reset email", zap.Uint("user_id", user.ID))
+}
+
+// ResetPassword sets a new password using a token from a reset email. The
+// token can only be used once, and all tokens issued before the reset are
+// revoked.
+func (h *AuthHandler) ResetPassword(c *gin.Context) {
+	logger := logging.FromContext(c, h.logger)
+	ctx := c.Request.Context()
+	if h.mailer == nil {
+		problem.Error(c, http.StatusNotFound, problem.CodeNotFound, "Password reset is not enabled")
+		return
+	}
+
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Warn("Invalid password reset request", zap.Error(err))
+		problem.BindError(c, err)
+		return
+	}
+
+	var token models.PasswordResetToken
+	result := h.db.WithContext(ctx).
+		Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", hashSecretToken(req.Token), time.Now()).
+		First(&token)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			logger.Warn("Password reset with invalid or expired token")
+			h.auditPasswordResetFailure(c, nil, "invalid_token")
+			problem.Error(c, http.StatusBadRequest, problem.CodeInvalidToken, "Invalid or expired reset token")
+		} else {
+			logger.Error("Database error fetching password reset token", zap.Error(result.Error))
+			problem.Error(c, http.StatusInternalServerError, problem.CodeInternal, "Database error")
+		}
+		return
+	}
+
+	// Deleted users keep their tokens until they are purged
+	var user models.User
+	result = h.db.WithContext(ctx).First(&user, token.UserID)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			h.auditPasswordResetFailure(c, nil, "invalid_token")
+			problem.Error(c, http.StatusBadRequest, problem.CodeInvalidToken, "Invalid or expired reset token")
+		} else {
+			logger.Error("Database error fetching user for password reset", zap.Uint("user_id", token.UserID), zap.Error(result.Error))
+			problem.Error(c, http.StatusInternalServerError, problem.CodeInternal, "Database error")
+		}
+		return
+	}
+
+	if fieldErrors := h.checkPassword(c, &user, "new_password", req.NewPassword); len(fieldErrors) > 0 {
+		h.auditPasswordResetFailure(c, &user, "policy")
+		problem.Render(c, problem.Validation(fieldErrors...))
+		return
+	}
+
+	hashedPassword, err := h.passwordHasher.Hash(req.NewPassword)
+	if err != nil {
+		logger.Error("Failed to hash password", zap.Error(err))
+		problem.Error(c, http.StatusInternalServerError, problem.CodeInternal, "Failed to process request")
+		return
+	}
+
+	// Marking the token used only if it still is unused makes it single-use
+	// even when it is submitted twice at the same time
+	now := time.Now()
+	err = h.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&token).Where("used_at IS NULL").Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errTokenUsed
+		}
+		return tx.Model(&user).Updates(map[string]interface{}{
+			"password":      hashedPassword,
+			"token_version": gorm.Expr("token_version + 1"),
+			"updated_at":    versionTimestamp(),
+		}).Error
+	})
+	if err != nil {
+		if errors.Is(err, errTokenUsed) {
+			h.auditPasswordResetFailure(c, &user, "invalid_token")
+			problem.Error(c, http.StatusBadRequest, problem.CodeInvalidToken, "Invalid or expired reset token")
+		} else {
+			logger.Error("Failed to reset password", zap.Uint("user_id", user.ID), zap.Error(err))
+			h.auditPasswordResetFailure(c, &user, "error")
+			problem.Error(c, http.StatusInternalServerError, problem.CodeInternal, "Failed to reset password")
+		}
+		return
+	}
+
+	// Whoever reset the password has proven access to the account's email
+	if h.accountLimiter != nil {
+		h.accountLimiter.Reset(auth.AccountKey(user.Username))
+	}
+
+	h.audit.Record(ctx, withActor(newAuditEvent(c, audit.ActionPasswordReset, audit.OutcomeSuccess), &user))
+	logger.Info("Reset password", zap.Uint("user_id", user.ID))
+	c.Status(http.StatusNoContent)
+}
+
+// auditPasswordResetFailure records a rejected password reset. The user is
+// nil when the token did not identify one.
+func (h *AuthHandler) auditPasswordResetFailure(c *gin.Context, user *models.User, reason string) {
+	event := newAuditEvent(c, audit.ActionPasswordReset, audit.OutcomeFailure)
+	if user != nil {
+		withActor(event, user)
+	}
+	event.Reason = reason
+	h.audit.Record(c.Request.Context(), event)
+}
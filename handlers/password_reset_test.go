@@ -0,0 +1,199 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"example.com/ginhello/auth"
+	"example.com/ginhello/handlers"
+	"example.com/ginhello/mail"
+	"example.com/ginhello/models"
+	"example.com/ginhello/password"
+	"example.com/ginhello/problem"
+	"example.com/ginhello/testutils"
+)
+
+// resetLinkPattern extracts the token from a reset email
+var resetLinkPattern = regexp.MustCompile(`https://app\.example\.com/reset\?token=(\S+)`)
+
+// requestReset asks for a reset link for email and returns the token from the
+// email sent to it
+func requestReset(t *testing.T, h *handlers.AuthHandler, mailer *mail.MemoryMailer, email string) string {
+	t.Helper()
+	sent := len(mailer.Messages())
+	w := testutils.CallHandler(h.ForgotPassword, testutils.HandlerRequest{Method: "POST", Path: "/api/auth/password/forgot", Body: handlers.ForgotPasswordRequest{Email: email}})
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	assert.Eventually(t, func() bool { return len(mailer.Messages()) > sent }, time.Second, 5*time.Millisecond)
+	msg := mailer.Messages()[sent]
+	assert.Equal(t, email, msg.To)
+	match := resetLinkPattern.FindStringSubmatch(msg.Body)
+	if !assert.NotNil(t, match, "reset link in %q", msg.Body) {
+		t.FailNow()
+	}
+	token, err := url.QueryUnescape(match[1])
+	assert.NoError(t, err)
+	return token
+}
+
+func TestAuthHandler_PasswordReset(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db, logger := testutils.SetupTestDB(t)
+	cfg := testutils.SetupTestConfig(t)
+	jwtService := auth.NewJWTService(cfg, logger)
+	mailer := mail.NewMemoryMailer()
+	authHandler := handlers.NewAuthHandler(jwtService, db, logger,
+		handlers.WithPasswordReset(mailer, "https://app.example.com/reset", time.Hour, 0))
+	user := testutils.CreateTestUser(t, db, "forgetful", "forgetful@example.com", "old-password")
+
+	// Test
+	token := requestReset(t, authHandler, mailer, "forgetful@example.com")
+	w := testutils.CallHandler(authHandler.ResetPassword, testutils.HandlerRequest{Method: "POST", Path: "/api/auth/password/reset", Body: handlers.ResetPasswordRequest{Token: token, NewPassword: "new-password"}})
+
+	// Assert
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Contains(t, mailer.Messages()[0].Body, "1 hour")
+
+	var stored models.User
+	assert.NoError(t, db.First(&stored, user.ID).Error)
+	assert.NoError(t, password.DefaultHasher().Verify(stored.Password, "new-password"))
+	assert.Equal(t, user.TokenVersion+1, stored.TokenVersion)
+
+	// Only a hash of the token is stored
+	var storedToken models.PasswordResetToken
+	assert.NoError(t, db.Where("user_id = ?", user.ID).First(&storedToken).Error)
+	assert.NotEqual(t, token, storedToken.TokenHash)
+	assert.NotNil(t, storedToken.UsedAt)
+
+	// The token cannot be used twice
+	w = testutils.CallHandler(authHandler.ResetPassword, testutils.HandlerRequest{Method: "POST", Path: "/api/auth/password/reset", Body: handlers.ResetPasswordRequest{Token: token, NewPassword: "another-password"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAuthHandler_ForgotPassword_UnknownEmail(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db, logger := testutils.SetupTestDB(t)
+	cfg := testutils.SetupTestConfig(t)
+	mailer := mail.NewMemoryMailer()
+	authHandler := handlers.NewAuthHandler(auth.NewJWTService(cfg, logger), db, logger,
+		handlers.WithPasswordReset(mailer, "https://app.example.com/reset", time.Hour, 0))
+	testutils.CreateTestUser(t, db, "known", "known@example.com", "password")
+
+	// Test
+	unknown := testutils.CallHandler(authHandler.ForgotPassword, testutils.HandlerRequest{Method: "POST", Path: "/api/auth/password/forgot", Body: handlers.ForgotPasswordRequest{Email: "nobody@example.com"}})
+	known := testutils.CallHandler(authHandler.ForgotPassword, testutils.HandlerRequest{Method: "POST", Path: "/api/auth/password/forgot", Body: handlers.ForgotPasswordRequest{Email: "known@example.com"}})
+
+	// Assert: the responses are identical, and only the known user gets mail
+	assert.Equal(t, known.Code, unknown.Code)
+	assert.Equal(t, known.Body.String(), unknown.Body.String())
+	assert.Eventually(t, func() bool { return len(mailer.Messages()) == 1 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, "known@example.com", mailer.Messages()[0].To)
+}
+
+func TestAuthHandler_ForgotPassword_Throttled(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db, logger := testutils.SetupTestDB(t)
+	cfg := testutils.SetupTestConfig(t)
+	mailer := mail.NewMemoryMailer()
+	authHandler := handlers.NewAuthHandler(auth.NewJWTService(cfg, logger), db, logger,
+		handlers.WithPasswordReset(mailer, "https://app.example.com/reset", time.Hour, time.Minute))
+	user := testutils.CreateTestUser(t, db, "impatient", "impatient@example.com", "old-password")
+	token := requestReset(t, authHandler, mailer, "impatient@example.com")
+
+	// Test
+	w := testutils.CallHandler(authHandler.ForgotPassword, testutils.HandlerRequest{Method: "POST", Path: "/api/auth/password/forgot", Body: handlers.ForgotPasswordRequest{Email: "impatient@example.com"}})
+
+	// Assert: the response does not change, but no second email is sent
+	// and the first link keeps working
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Never(t, func() bool { return len(mailer.Messages()) > 1 }, 100*time.Millisecond, 5*time.Millisecond)
+
+	var count int64
+	assert.NoError(t, db.Model(&models.PasswordResetToken{}).Where("user_id = ?", user.ID).Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+
+	w = testutils.CallHandler(authHandler.ResetPassword, testutils.HandlerRequest{Method: "POST", Path: "/api/auth/password/reset", Body: handlers.ResetPasswordRequest{Token: token, NewPassword: "new-password"}})
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
+
+func TestAuthHandler_ResetPassword_Rejected(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db, logger := testutils.SetupTestDB(t)
+	cfg := testutils.SetupTestConfig(t)
+	mailer := mail.NewMemoryMailer()
+	authHandler := handlers.NewAuthHandler(auth.NewJWTService(cfg, logger), db, logger,
+		handlers.WithPasswordReset(mailer, "https://app.example.com/reset", time.Hour, 0))
+	user := testutils.CreateTestUser(t, db, "resetter", "resetter@example.com", "old-password")
+
+	superseded := requestReset(t, authHandler, mailer, "resetter@example.com")
+	expired := requestReset(t, authHandler, mailer, "resetter@example.com")
+	assert.NoError(t, db.Model(&models.PasswordResetToken{}).
+		Where("user_id = ? AND used_at IS NULL", user.ID).
+		Update("expires_at", time.Now().Add(-time.Minute)).Error)
+	token := requestReset(t, authHandler, mailer, "resetter@example.com")
+
+	tests := []struct {
+		name           string
+		token          string
+		password       string
+		expectedStatus int
+		expectedCode   string
+	}{
+		{
+			name:           "Unknown token",
+			token:          "not-a-token",
+			password:       "new-password",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   problem.CodeInvalidToken,
+		},
+		{
+			name:           "Superseded token",
+			token:          superseded,
+			password:       "new-password",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   problem.CodeInvalidToken,
+		},
+		{
+			name:           "Expired token",
+			token:          expired,
+			password:       "new-password",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   problem.CodeInvalidToken,
+		},
+		{
+			name:           "Too short",
+			token:          token,
+			password:       "short",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   problem.CodeValidationFailed,
+		},
+		{
+			name:           "Same as username",
+			token:          token,
+			password:       "RESETTER",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   problem.CodeValidationFailed,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			w := testutils.CallHandler(authHandler.ResetPassword, testutils.HandlerRequest{Method: "POST", Path: "/api/auth/password/reset", Body: handlers.ResetPasswordRequest{Token: tc.token, NewPassword: tc.password}})
+			assert.Equal(t, tc.expectedStatus, w.Code)
+			var response map[string]interface{}
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Equal(t, tc.expectedCode, response["error_code"])
+		})
+	}
+}
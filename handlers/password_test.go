@@ -0,0 +1,122 @@
+package handlers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"example.com/ginhello/auth"
+	"example.com/ginhello/handlers"
+	"example.com/ginhello/models"
+	"example.com/ginhello/password"
+	"example.com/ginhello/problem"
+	"example.com/ginhello/testutils"
+)
+
+func TestAuthHandler_ChangePassword(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db, logger := testutils.SetupTestDB(t)
+	cfg := testutils.SetupTestConfig(t)
+	jwtService := auth.NewJWTService(cfg, logger)
+	authHandler := handlers.NewAuthHandler(jwtService, db, logger)
+	user := testutils.CreateTestUser(t, db, "changer", "changer@example.com", "old-password")
+	self := &auth.Principal{UserID: user.ID, Username: user.Username}
+	oldTokens, err := jwtService.GenerateTokenPair(&user)
+	assert.NoError(t, err)
+
+	// Test
+	w := testutils.CallHandler(authHandler.ChangePassword, testutils.HandlerRequest{Method: "POST", Path: "/api/users/me/password", Body: handlers.ChangePasswordRequest{CurrentPassword: "old-password", NewPassword: "new-password"}, Principal: self})
+
+	// Assert
+	assert.Equal(t, http.StatusOK, w.Code)
+	var tokens auth.TokenPair
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &tokens))
+	assert.NotEmpty(t, tokens.AccessToken)
+
+	var stored models.User
+	assert.NoError(t, db.First(&stored, user.ID).Error)
+	assert.NoError(t, password.DefaultHasher().Verify(stored.Password, "new-password"))
+	assert.Equal(t, user.TokenVersion+1, stored.TokenVersion)
+
+	// The new tokens carry the new version, so only they can be refreshed
+	claims, err := jwtService.ValidateAccessToken(tokens.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, stored.TokenVersion, claims.TokenVersion)
+
+	refresh := func(refreshToken string) int {
+		body, _ := json.Marshal(handlers.RefreshRequest{RefreshToken: refreshToken})
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/api/auth/refresh", bytes.NewBuffer(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		authHandler.RefreshToken(c)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusUnauthorized, refresh(oldTokens.RefreshToken))
+	assert.Equal(t, http.StatusOK, refresh(tokens.RefreshToken))
+}
+
+func TestAuthHandler_ChangePassword_Rejected(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db, logger := testutils.SetupTestDB(t)
+	cfg := testutils.SetupTestConfig(t)
+	authHandler := handlers.NewAuthHandler(auth.NewJWTService(cfg, logger), db, logger)
+	user := testutils.CreateTestUser(t, db, "changeling", "changeling@example.com", "old-password")
+	self := &auth.Principal{UserID: user.ID, Username: user.Username}
+
+	tests := []struct {
+		name           string
+		current        string
+		next           string
+		expectedStatus int
+		expectedCode   string
+	}{
+		{
+			name:           "Wrong current password",
+			current:        "wrong-password",
+			next:           "new-password",
+			expectedStatus: http.StatusForbidden,
+			expectedCode:   problem.CodeInvalidCredentials,
+		},
+		{
+			name:           "Too short",
+			current:        "old-password",
+			next:           "short",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   problem.CodeValidationFailed,
+		},
+		{
+			name:           "Same as current",
+			current:        "old-password",
+			next:           "old-password",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   problem.CodeValidationFailed,
+		},
+		{
+			name:           "Same as username",
+			current:        "old-password",
+			next:           "CHANGELING",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   problem.CodeValidationFailed,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			w := testutils.CallHandler(authHandler.ChangePassword, testutils.HandlerRequest{Method: "POST", Path: "/api/users/me/password", Body: handlers.ChangePasswordRequest{CurrentPassword: tc.current, NewPassword: tc.next}, Principal: self})
+			assert.Equal(t, tc.expectedStatus, w.Code)
+			if tc.expectedCode != "" {
+				var response map[string]interface{}
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+				assert.Equal(t, tc.expectedCode, response["error_code"])
+			}
+		})
+	}
+}
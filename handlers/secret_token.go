@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// secretTokenBytes is the number of random bytes in an emailed token
+const secretTokenBytes = 32
+
+// errTokenUsed is returned when an emailed token is used concurrently
+var errTokenUsed = errors.New("token already used")
+
+// newSecretToken returns a random, URL-safe token for password reset and
+// email verification links
+func newSecretToken() (string, error) {
+	b := make([]byte, secretTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashSecretToken returns the hash under which a secret token is stored
+func hashSecretToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// tokenLink appends token to base as the "token" query parameter
+func tokenLink(base, token string) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	query.Set("token", token)
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// humanDuration formats d for people, e.g. "1 hour" or "30 minutes"
+func humanDuration(d time.Duration) string {
+	n, unit := int(d/time.Minute), "minute"
+	if d >= time.Hour && d%time.Hour == 0 {
+		n, unit = int(d/time.Hour), "hour"
+	}
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
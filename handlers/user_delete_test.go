@@ -0,0 +1,108 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"example.com/ginhello/auth"
+	"example.com/ginhello/handlers"
+	"example.com/ginhello/models"
+	"example.com/ginhello/testutils"
+)
+
+func TestDeleteUser(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db, logger := testutils.SetupTestDB(t)
+	userHandler := handlers.NewUserHandler(db, logger)
+	user := testutils.CreateTestUser(t, db, "deleteme", "deleteme@example.com", "pw")
+	other := testutils.CreateTestUser(t, db, "bystander", "bystander@example.com", "pw")
+	self := &auth.Principal{UserID: user.ID, Username: user.Username}
+	path := fmt.Sprintf("/api/users/%d", user.ID)
+
+	// Users may not delete others
+	w := testutils.CallHandler(userHandler.DeleteUser, testutils.HandlerRequest{Method: "DELETE", Path: path, Params: testutils.IDParam(user.ID), Principal: &auth.Principal{UserID: other.ID}})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// Users may delete themselves, which revokes their tokens
+	w = testutils.CallHandler(userHandler.DeleteUser, testutils.HandlerRequest{Method: "DELETE", Path: path, Params: testutils.IDParam(user.ID), Principal: self})
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	var stored models.User
+	assert.NoError(t, db.Unscoped().First(&stored, user.ID).Error)
+	assert.True(t, stored.DeletedAt.Valid)
+	assert.Equal(t, user.TokenVersion+1, stored.TokenVersion)
+
+	// Deleted users are gone from the API
+	w = testutils.CallHandler(userHandler.GetUserByID, testutils.HandlerRequest{Method: "GET", Path: path, Params: testutils.IDParam(user.ID)})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = testutils.CallHandler(userHandler.DeleteUser, testutils.HandlerRequest{Method: "DELETE", Path: path, Params: testutils.IDParam(user.ID), Principal: self})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestDeleteUser_Admin(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db, logger := testutils.SetupTestDB(t)
+	userHandler := handlers.NewUserHandler(db, logger)
+	user := testutils.CreateTestUser(t, db, "deleteme", "deleteme@example.com", "pw")
+	admin := &auth.Principal{UserID: 999, Permissions: []string{auth.PermissionAdmin}}
+
+	// Test
+	w := testutils.CallHandler(userHandler.DeleteUser, testutils.HandlerRequest{Method: "DELETE", Path: fmt.Sprintf("/api/users/%d", user.ID), Params: testutils.IDParam(user.ID), Principal: admin})
+
+	// Assert
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	// Admins can still list the deleted user
+	w = testutils.CallHandler(userHandler.GetUsers, testutils.HandlerRequest{Method: "GET", Path: "/api/users?include_deleted=true", Principal: admin})
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response handlers.UserListResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	if assert.Len(t, response.Data, 1) {
+		assert.Equal(t, user.ID, response.Data[0].ID)
+		assert.NotNil(t, response.Data[0].DeletedAt)
+	}
+
+	// Regular users may not
+	w = testutils.CallHandler(userHandler.GetUsers, testutils.HandlerRequest{Method: "GET", Path: "/api/users?include_deleted=true", Principal: &auth.Principal{UserID: user.ID}})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestRestoreUser(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db, logger := testutils.SetupTestDB(t)
+	userHandler := handlers.NewUserHandler(db, logger)
+	user := testutils.CreateTestUser(t, db, "restoreme", "restoreme@example.com", "pw")
+	taken := testutils.CreateTestUser(t, db, "taken", "taken@example.com", "pw")
+	admin := &auth.Principal{UserID: 999, Permissions: []string{auth.PermissionAdmin}}
+	path := fmt.Sprintf("/api/users/%d/restore", user.ID)
+
+	// Only deleted users can be restored
+	w := testutils.CallHandler(userHandler.RestoreUser, testutils.HandlerRequest{Method: "POST", Path: path, Params: testutils.IDParam(user.ID), Principal: admin})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	assert.NoError(t, db.Delete(&user).Error)
+	w = testutils.CallHandler(userHandler.RestoreUser, testutils.HandlerRequest{Method: "POST", Path: path, Params: testutils.IDParam(user.ID), Principal: admin})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, w.Header().Get("ETag"))
+	var response models.PublicUser
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, user.ID, response.ID)
+	assert.Nil(t, response.DeletedAt)
+
+	w = testutils.CallHandler(userHandler.GetUserByID, testutils.HandlerRequest{Method: "GET", Path: fmt.Sprintf("/api/users/%d", user.ID), Params: testutils.IDParam(user.ID)})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// A user whose name was registered again cannot be restored
+	assert.NoError(t, db.Delete(&taken).Error)
+	testutils.CreateTestUser(t, db, "taken", "taken-again@example.com", "pw")
+	w = testutils.CallHandler(userHandler.RestoreUser, testutils.HandlerRequest{Method: "POST", Path: fmt.Sprintf("/api/users/%d/restore", taken.ID), Params: testutils.IDParam(taken.ID), Principal: admin})
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"example.com/ginhello/auth"
+	"example.com/ginhello/models"
+	"example.com/ginhello/normalize"
+	"example.com/ginhello/problem"
+)
+
+const (
+	defaultUserListLimit = 20
+	maxUserListLimit     = 100
+)
+
+// User statuses that can be listed
+const (
+	UserStatusActive  = "active"
+	UserStatusDeleted = "deleted"
+	UserStatusAll     = "all"
+)
+
+// userSortColumns maps the sort fields clients may use to their columns
+var userSortColumns = map[string]string{
+	"id":         "id",
+	"username":   "username",
+	"email":      "email",
+	"created_at": "created_at",
+}
+
+// Pagination describes the page returned by a list endpoint
+type Pagination struct {
+	Limit      int    `json:"limit"`
+	Offset     int    `json:"offset"`
+	Total      int64  `json:"total"`                 // Matching items across all pages
+	NextCursor string `json:"next_cursor,omitempty"` // Empty on the last page
+}
+
+// UserListResponse is a page of users
+type UserListResponse struct {
+	Data       []models.PublicUser `json:"data"`
+	Pagination Pagination          `json:"pagination"`
+}
+
+// userListQuery holds the parsed query parameters of GetUsers
+type userListQuery struct {
+	limit          int
+	offset         int
+	cursor         *userCursor
+	sortField      string
+	descending     bool
+	usernamePrefix string
+	emailDomain    string
+	createdAfter   time.Time
+	createdBefore  time.Time
+	status         string
+}
+
+// userCursor marks the position after the last user of a page. It is sent to
+// clients base64 encoded and must be treated as opaque.
+type userCursor struct {
+	Sort  string `json:"s"` // Sort the cursor was created for, e.g. "-created_at"
+	Value string `json:"v"` // Sort field value of the last user
+	ID    uint   `json:"i"` // ID of the last user, breaking ties
+}
+
+// parseUserListQuery validates the pagination, sort and filter parameters
+func parseUserListQuery(c *gin.Context) (*userListQuery, *problem.Problem) {
+	q := &userListQuery{
+		sortField: "id",
+		status:    c.DefaultQuery("status", UserStatusActive),
+	}
+	invalid := func(detail string) *problem.Problem {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidRequest, detail)
+	}
+
+	var err error
+	if q.limit, err = strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultUserListLimit))); err != nil || q.limit < 1 || q.limit > maxUserListLimit {
+		return nil, invalid("limit must be between 1 and " + strconv.Itoa(maxUserListLimit))
+	}
+	if q.offset, err = strconv.Atoi(c.DefaultQuery("offset", "0")); err != nil || q.offset < 0 {
+		return nil, invalid("offset must not be negative")
+	}
+
+	if sort := c.Query("sort"); sort != "" {
+		q.descending = strings.HasPrefix(sort, "-")
+		q.sortField = strings.TrimPrefix(sort, "-")
+		if _, ok := userSortColumns[q.sortField]; !ok {
+			return nil, invalid("sort must be one of id, username, email, created_at, optionally prefixed with -")
+		}
+	}
+
+	if raw := c.Query("cursor"); raw != "" {
+		if q.offset != 0 {
+			return nil, invalid("cursor and offset cannot be combined")
+		}
+		if q.cursor, err = decodeUserCursor(raw); err != nil || q.cursor.Sort != q.sortKey() {
+			return nil, invalid("Invalid cursor")
+		}
+	}
+
+	// include_deleted=true is shorthand for status=all
+	if raw := c.Query("include_deleted"); raw != "" {
+		includeDeleted, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, invalid("include_deleted must be true or false")
+		}
+		if includeDeleted && c.Query("status") == "" {
+			q.status = UserStatusAll
+		}
+	}
+
+	q.usernamePrefix = normalize.Username(c.Query("username_prefix"))
+	q.emailDomain = strings.ToLower(strings.TrimPrefix(c.Query("email_domain"), "@"))
+	if q.createdAfter, err = parseTimeQuery(c, "created_after"); err != nil {
+		return nil, invalid("Invalid created_after, expected an RFC 3339 timestamp")
+	}
+	if q.createdBefore, err = parseTimeQuery(c, "created_before"); err != nil {
+		return nil, invalid("Invalid created_before, expected an RFC 3339 timestamp")
+	}
+
+	switch q.status {
+	case UserStatusActive:
+	case UserStatusDeleted, UserStatusAll:
+		// Deleted accounts are only visible to administrators
+		if principal, ok := auth.PrincipalFrom(c); !ok || !principal.HasPermission(auth.PermissionAdmin) {
+			return nil, problem.New(http.StatusForbidden, problem.CodeForbidden, "Only administrators may list deleted users")
+		}
+	default:
+		return nil, invalid("status must be one of active, deleted, all")
+	}
+
+	return q, nil
+}
+
+// sortKey returns the sort in the form of the sort query parameter
+func (q *userListQuery) sortKey() string {
+	if q.descending {
+		return "-" + q.sortField
+	}
+	return q.sortField
+}
+
+// filter applies the status and filter parameters to db
+func (q *userListQuery) filter(db *gorm.DB) *gorm.DB {
+	switch q.status {
+	case UserStatusDeleted:
+		db = db.Unscoped().Where("deleted_at IS NOT NULL")
+	case UserStatusAll:
+		db = db.Unscoped()
+	}
+	if q.usernamePrefix != "" {
+		db = db.Where(`username LIKE ? ESCAPE '\'`, escapeLike(q.usernamePrefix)+"%")
+	}
+	if q.emailDomain != "" {
+		db = db.Where(`LOWER(email) LIKE ? ESCAPE '\'`, "%@"+escapeLike(q.emailDomain))
+	}
+	if !q.createdAfter.IsZero() {
+		db = db.Where("created_at >= ?", q.createdAfter)
+	}
+	if !q.createdBefore.IsZero() {
+		db = db.Where("created_at < ?", q.createdBefore)
+	}
+	return db
+}
+
+// page applies the sort and the cursor or offset to db. The ID breaks ties so
+// that the order is stable and cursors never skip or repeat users.
+func (q *userListQuery) page(db *gorm.DB) (*gorm.DB, error) {
+	column := userSortColumns[q.sortField]
+	direction, comparison := "ASC", ">"
+	if q.descending {
+		direction, comparison = "DESC", "<"
+	}
+
+	if q.cursor != nil {
+		if column == "id" {
+			db = db.Where("id "+comparison+" ?", q.cursor.ID)
+		} else {
+			value, err := q.cursorValue()
+			if err != nil {
+				return nil, err
+			}
+			db = db.Where(
+				"("+column+" "+comparison+" ?) OR ("+column+" = ? AND id "+comparison+" ?)",
+				value, value, q.cursor.ID,
+			)
+		}
+	}
+
+	if column != "id" {
+		db = db.Order(column + " " + direction)
+	}
+	return db.Order("id " + direction).Offset(q.offset), nil
+}
+
+// cursorValue returns the cursor's sort value as the column's type
+func (q *userListQuery) cursorValue() (interface{}, error) {
+	if q.sortField == "created_at" {
+		return time.Parse(time.RFC3339Nano, q.cursor.Value)
+	}
+	return q.cursor.Value, nil
+}
+
+// nextCursor returns the cursor pointing after user
+func (q *userListQuery) nextCursor(user *models.User) string {
+	cursor := userCursor{Sort: q.sortKey(), ID: user.ID}
+	switch q.sortField {
+	case "username":
+		cursor.Value = user.Username
+	case "email":
+		cursor.Value = user.Email
+	case "created_at":
+		cursor.Value = user.CreatedAt.Format(time.RFC3339Nano)
+	}
+	encoded, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+// decodeUserCursor parses a cursor created by nextCursor
+func decodeUserCursor(raw string) (*userCursor, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, err
+	}
+	var cursor userCursor
+	if err := json.Unmarshal(decoded, &cursor); err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
+
+// setLinkHeader sets an RFC 8288 Link header with the first and, if there is
+// one, the next page of the current request
+func setLinkHeader(c *gin.Context, nextCursor string) {
+	link := func(rel string, change func(url.Values)) string {
+		query := c.Request.URL.Query()
+		query.Del("cursor")
+		query.Del("offset")
+		change(query)
+		u := url.URL{Path: c.Request.URL.Path, RawQuery: query.Encode()}
+		return "<" + u.String() + `>; rel="` + rel + `"`
+	}
+
+	links := []string{link("first", func(url.Values) {})}
+	if nextCursor != "" {
+		links = append(links, link("next", func(query url.Values) {
+			query.Set("cursor", nextCursor)
+		}))
+	}
+	c.Header("Link", strings.Join(links, ", "))
+}
+
+// escapeLike escapes the LIKE wildcards in s, for use with ESCAPE '\'
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,191 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+
+	"example.com/ginhello/auth"
+	"example.com/ginhello/handlers"
+	"example.com/ginhello/models"
+	"example.com/ginhello/testutils"
+)
+
+// listUsers calls GetUsers with the given query, optionally as an admin
+func listUsers(t *testing.T, h *handlers.UserHandler, query string, admin bool) (*httptest.ResponseRecorder, handlers.UserListResponse) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/api/users?"+query, nil)
+	if admin {
+		c.Set(auth.PrincipalContextKey, &auth.Principal{UserID: 1, Permissions: []string{auth.PermissionAdmin}})
+	}
+
+	h.GetUsers(c)
+
+	var response handlers.UserListResponse
+	if w.Code == http.StatusOK {
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	}
+	return w, response
+}
+
+func usernames(users []models.PublicUser) []string {
+	names := []string{}
+	for _, u := range users {
+		names = append(names, u.Username)
+	}
+	return names
+}
+
+// seedUsers creates users with distinct creation times, oldest first
+func seedUsers(t *testing.T, db *gorm.DB) []models.User {
+	t.Helper()
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	specs := []struct{ username, email string }{
+		{"carol", "carol@example.com"},
+		{"alice", "alice@example.org"},
+		{"bob", "bob@Example.com"},
+		{"al_ex", "alex@example.net"},
+		{"dave", "dave@example.com"},
+	}
+	users := make([]models.User, len(specs))
+	for i, spec := range specs {
+		users[i] = models.User{Username: spec.username, Email: spec.email, Password: "x"}
+		users[i].CreatedAt = base.Add(time.Duration(i) * time.Hour)
+		assert.NoError(t, db.Create(&users[i]).Error)
+	}
+	return users
+}
+
+func TestGetUsers_Pagination(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db, logger := testutils.SetupTestDB(t)
+	userHandler := handlers.NewUserHandler(db, logger)
+	seedUsers(t, db)
+
+	// Offset pagination
+	w, page := listUsers(t, userHandler, "limit=2&offset=2", false)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"bob", "al_ex"}, usernames(page.Data))
+	assert.Equal(t, handlers.Pagination{Limit: 2, Offset: 2, Total: 5, NextCursor: page.Pagination.NextCursor}, page.Pagination)
+	assert.NotEmpty(t, page.Pagination.NextCursor)
+
+	// Following cursors visits every user once, for every sort
+	tests := []struct {
+		sort     string
+		expected []string
+	}{
+		{"", []string{"carol", "alice", "bob", "al_ex", "dave"}},
+		{"-id", []string{"dave", "al_ex", "bob", "alice", "carol"}},
+		{"username", []string{"al_ex", "alice", "bob", "carol", "dave"}},
+		{"-created_at", []string{"dave", "al_ex", "bob", "alice", "carol"}},
+		{"email", []string{"al_ex", "alice", "bob", "carol", "dave"}},
+	}
+
+	for _, tc := range tests {
+		t.Run("Cursor sort "+tc.sort, func(t *testing.T) {
+			seen := []string{}
+			query := url.Values{"limit": {"2"}, "sort": {tc.sort}}
+			for pages := 0; pages < 5; pages++ {
+				w, page := listUsers(t, userHandler, query.Encode(), false)
+				if !assert.Equal(t, http.StatusOK, w.Code) {
+					return
+				}
+				assert.Equal(t, int64(5), page.Pagination.Total)
+				seen = append(seen, usernames(page.Data)...)
+				if page.Pagination.NextCursor == "" {
+					assert.NotContains(t, w.Header().Get("Link"), `rel="next"`)
+					break
+				}
+				assert.Contains(t, w.Header().Get("Link"), `rel="next"`)
+				query.Set("cursor", page.Pagination.NextCursor)
+			}
+			assert.Equal(t, tc.expected, seen)
+		})
+	}
+
+	// The next link carries the query with the cursor
+	w, page = listUsers(t, userHandler, "limit=1&username_prefix=a", false)
+	assert.Equal(t, fmt.Sprintf(`</api/users?limit=1&username_prefix=a>; rel="first", </api/users?cursor=%s&limit=1&username_prefix=a>; rel="next"`, page.Pagination.NextCursor), w.Header().Get("Link"))
+}
+
+func TestGetUsers_Filters(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db, logger := testutils.SetupTestDB(t)
+	userHandler := handlers.NewUserHandler(db, logger)
+	users := seedUsers(t, db)
+	assert.NoError(t, db.Delete(&users[4]).Error) // dave
+
+	tests := []struct {
+		name     string
+		query    string
+		admin    bool
+		expected []string
+	}{
+		{"Username prefix", "username_prefix=al", false, []string{"alice", "al_ex"}},
+		{"Prefix wildcards are literal", "username_prefix=al_", false, []string{"al_ex"}},
+		{"Email domain", "email_domain=example.com", false, []string{"carol", "bob"}},
+		{"Email domain with @", "email_domain=@EXAMPLE.org", false, []string{"alice"}},
+		{"Created range", "created_after=2024-01-01T01:00:00Z&created_before=2024-01-01T03:00:00Z", false, []string{"alice", "bob"}},
+		{"Deleted", "status=deleted", true, []string{"dave"}},
+		{"All", "status=all", true, []string{"carol", "alice", "bob", "al_ex", "dave"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			w, page := listUsers(t, userHandler, tc.query, tc.admin)
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tc.expected, usernames(page.Data))
+			assert.Equal(t, int64(len(tc.expected)), page.Pagination.Total)
+		})
+	}
+
+	// Deleted users show when they were deleted
+	_, page := listUsers(t, userHandler, "status=deleted", true)
+	if assert.Len(t, page.Data, 1) {
+		assert.NotNil(t, page.Data[0].DeletedAt)
+	}
+}
+
+func TestGetUsers_InvalidQuery(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db, logger := testutils.SetupTestDB(t)
+	userHandler := handlers.NewUserHandler(db, logger)
+	seedUsers(t, db)
+	_, page := listUsers(t, userHandler, "limit=1&sort=username", false)
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+	}{
+		{"Limit too large", "limit=101", http.StatusBadRequest},
+		{"Negative offset", "offset=-1", http.StatusBadRequest},
+		{"Unknown sort field", "sort=password", http.StatusBadRequest},
+		{"Garbage cursor", "cursor=not-a-cursor", http.StatusBadRequest},
+		{"Cursor for another sort", "sort=email&cursor=" + page.Pagination.NextCursor, http.StatusBadRequest},
+		{"Cursor with offset", "sort=username&offset=1&cursor=" + page.Pagination.NextCursor, http.StatusBadRequest},
+		{"Invalid date", "created_after=yesterday", http.StatusBadRequest},
+		{"Unknown status", "status=banned", http.StatusBadRequest},
+		{"Deleted users need admin", "status=deleted", http.StatusForbidden},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			w, _ := listUsers(t, userHandler, tc.query, false)
+			assert.Equal(t, tc.expectedStatus, w.Code)
+		})
+	}
+}
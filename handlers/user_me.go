@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"example.com/ginhello/auth"
+	"example.com/ginhello/logging"
+	"example.com/ginhello/models"
+	"example.com/ginhello/problem"
+)
+
+// GetMe returns the authenticated user's own account
+func (h *UserHandler) GetMe(c *gin.Context) {
+	logger := logging.FromContext(c, h.logger)
+	id, ok := selfID(c)
+	if !ok {
+		return
+	}
+
+	var user models.User
+	result := h.db.WithContext(c.Request.Context()).First(&user, id)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			problem.Error(c, http.StatusNotFound, problem.CodeNotFound, "User not found")
+		} else {
+			logger.Error("Database error fetching current user", zap.Uint("user_id", id), zap.Error(result.Error))
+			problem.Error(c, http.StatusInternalServerError, problem.CodeInternal, "Database error")
+		}
+		return
+	}
+
+	c.Header("ETag", userETag(&user))
+	c.JSON(http.StatusOK, toSelfUser(&user))
+}
+
+// UpdateMe applies a JSON merge patch to the authenticated user's account.
+// It works like UpdateUser, including the If-Match requirement.
+func (h *UserHandler) UpdateMe(c *gin.Context) {
+	id, ok := selfID(c)
+	if !ok {
+		return
+	}
+
+	user, ok := h.updateUser(c, id)
+	if !ok {
+		return
+	}
+	c.Header("ETag", userETag(user))
+	c.JSON(http.StatusOK, toSelfUser(user))
+}
+
+// DeleteMe soft deletes the authenticated user's account and revokes their
+// tokens, like DeleteUser
+func (h *UserHandler) DeleteMe(c *gin.Context) {
+	id, ok := selfID(c)
+	if !ok {
+		return
+	}
+	h.deleteUser(c, id)
+}
+
+// selfID returns the authenticated user's ID, rendering a problem if the
+// request is not authenticated
+func selfID(c *gin.Context) (uint, bool) {
+	principal, ok := auth.PrincipalFrom(c)
+	if !ok {
+		problem.Error(c, http.StatusUnauthorized, problem.CodeAuthenticationRequired, "Authentication required")
+		return 0, false
+	}
+	return principal.UserID, true
+}
+
+// toSelfUser converts a user to the representation shown to the user themselves
+func toSelfUser(user *models.User) models.SelfUser {
+	self := models.SelfUser{
+		PublicUser:    toPublicUser(user),
+		EmailVerified: user.EmailVerifiedAt != nil,
+		MFAEnabled:    user.MFAEnabled,
+	}
+	if user.LastLoginAt != nil {
+		lastLoginAt := formatTime(*user.LastLoginAt)
+		self.LastLoginAt = &lastLoginAt
+	}
+	return self
+}
@@ -0,0 +1,106 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"example.com/ginhello/auth"
+	"example.com/ginhello/handlers"
+	"example.com/ginhello/models"
+	"example.com/ginhello/testutils"
+)
+
+func TestGetMe(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db, logger := testutils.SetupTestDB(t)
+	userHandler := handlers.NewUserHandler(db, logger)
+	user := testutils.CreateTestUser(t, db, "myself", "myself@example.com", "pw")
+	self := &auth.Principal{UserID: user.ID, Username: user.Username}
+
+	// Test
+	w := testutils.CallHandler(userHandler.GetMe, testutils.HandlerRequest{Method: "GET", Path: "/api/users/me", Principal: self})
+
+	// Assert
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, w.Header().Get("ETag"))
+	var response map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "myself", response["username"])
+	assert.Equal(t, false, response["email_verified"])
+	assert.Equal(t, false, response["mfa_enabled"])
+	assert.Contains(t, response, "last_login_at")
+	assert.Nil(t, response["last_login_at"])
+
+	// Account state is shown once set
+	now := time.Now()
+	assert.NoError(t, db.Model(&user).UpdateColumns(map[string]interface{}{
+		"email_verified_at": now,
+		"mfa_enabled":       true,
+		"last_login_at":     now,
+	}).Error)
+	w = testutils.CallHandler(userHandler.GetMe, testutils.HandlerRequest{Method: "GET", Path: "/api/users/me", Principal: self})
+	var selfView models.SelfUser
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &selfView))
+	assert.True(t, selfView.EmailVerified)
+	assert.True(t, selfView.MFAEnabled)
+	assert.NotNil(t, selfView.LastLoginAt)
+
+	// The public representation does not include it
+	w = testutils.CallHandler(userHandler.GetUserByID, testutils.HandlerRequest{Method: "GET", Path: "/api/users/me", Params: testutils.IDParam(user.ID)})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "mfa_enabled")
+
+	// Unauthenticated requests are rejected
+	w = testutils.CallHandler(userHandler.GetMe, testutils.HandlerRequest{Method: "GET", Path: "/api/users/me"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestUpdateMe(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db, logger := testutils.SetupTestDB(t)
+	userHandler := handlers.NewUserHandler(db, logger)
+	user := testutils.CreateTestUser(t, db, "myself", "myself@example.com", "pw")
+	self := &auth.Principal{UserID: user.ID, Username: user.Username}
+
+	// The ETag is required, as for /api/users/:id
+	w := testutils.CallHandler(userHandler.UpdateMe, testutils.HandlerRequest{Method: "PATCH", Path: "/api/users/me", Body: `{"username":"renamed"}`, Header: map[string]string{"Content-Type": "application/merge-patch+json"}, Principal: self})
+	assert.Equal(t, http.StatusPreconditionRequired, w.Code)
+
+	etag := testutils.CallHandler(userHandler.GetMe, testutils.HandlerRequest{Method: "GET", Path: "/api/users/me", Principal: self}).Header().Get("ETag")
+	w = testutils.CallHandler(userHandler.UpdateMe, testutils.HandlerRequest{Method: "PATCH", Path: "/api/users/me", Body: `{"username":"renamed"}`, Header: map[string]string{"Content-Type": "application/merge-patch+json", "If-Match": etag}, Principal: self})
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response models.SelfUser
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, user.ID, response.ID)
+	assert.Equal(t, "renamed", response.Username)
+	assert.False(t, response.EmailVerified)
+	assert.NotEqual(t, etag, w.Header().Get("ETag"))
+}
+
+func TestDeleteMe(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db, logger := testutils.SetupTestDB(t)
+	userHandler := handlers.NewUserHandler(db, logger)
+	user := testutils.CreateTestUser(t, db, "myself", "myself@example.com", "pw")
+	self := &auth.Principal{UserID: user.ID, Username: user.Username}
+
+	// Test
+	w := testutils.CallHandler(userHandler.DeleteMe, testutils.HandlerRequest{Method: "DELETE", Path: "/api/users/me", Principal: self})
+
+	// Assert
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	var stored models.User
+	assert.NoError(t, db.Unscoped().First(&stored, user.ID).Error)
+	assert.True(t, stored.DeletedAt.Valid)
+
+	w = testutils.CallHandler(userHandler.GetMe, testutils.HandlerRequest{Method: "GET", Path: "/api/users/me", Principal: self})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
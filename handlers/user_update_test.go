@@ -0,0 +1,139 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"example.com/ginhello/auth"
+	"example.com/ginhello/handlers"
+	"example.com/ginhello/models"
+	"example.com/ginhello/problem"
+	"example.com/ginhello/testutils"
+)
+
+// getETag fetches a user and returns its ETag
+func getETag(t *testing.T, h *handlers.UserHandler, id uint) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", fmt.Sprintf("/api/users/%d", id), nil)
+	c.Params = gin.Params{{Key: "id", Value: fmt.Sprint(id)}}
+	h.GetUserByID(c)
+	assert.Equal(t, http.StatusOK, w.Code)
+	return w.Header().Get("ETag")
+}
+
+func TestUpdateUser(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db, logger := testutils.SetupTestDB(t)
+	userHandler := handlers.NewUserHandler(db, logger)
+	user := testutils.CreateTestUser(t, db, "patchme", "patchme@example.com", "pw")
+	other := testutils.CreateTestUser(t, db, "other", "other@example.com", "pw")
+	self := &auth.Principal{UserID: user.ID, Username: user.Username}
+
+	etag := getETag(t, userHandler, user.ID)
+	assert.NotEmpty(t, etag)
+
+	// A partial update changes only the given field
+	w := testutils.CallHandler(userHandler.UpdateUser, testutils.HandlerRequest{Method: "PATCH", Path: fmt.Sprintf("/api/users/%d", user.ID), Body: `{"email":"new@example.com"}`, Header: map[string]string{"Content-Type": "application/merge-patch+json", "If-Match": etag}, Params: testutils.IDParam(user.ID), Principal: self})
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response models.PublicUser
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "patchme", response.Username)
+	assert.Equal(t, "new@example.com", response.Email)
+
+	// The new ETag matches what a later read returns, the old one is stale
+	newETag := w.Header().Get("ETag")
+	assert.NotEqual(t, etag, newETag)
+	assert.Equal(t, newETag, getETag(t, userHandler, user.ID))
+
+	w = testutils.CallHandler(userHandler.UpdateUser, testutils.HandlerRequest{Method: "PATCH", Path: fmt.Sprintf("/api/users/%d", user.ID), Body: `{"username":"lost-update"}`, Header: map[string]string{"Content-Type": "application/merge-patch+json", "If-Match": etag}, Params: testutils.IDParam(user.ID), Principal: self})
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+	var stored models.User
+	assert.NoError(t, db.First(&stored, user.ID).Error)
+	assert.Equal(t, "patchme", stored.Username)
+	assert.Equal(t, "new@example.com", stored.Email)
+
+	// Taking another user's name is a conflict, in any case
+	w = testutils.CallHandler(userHandler.UpdateUser, testutils.HandlerRequest{Method: "PATCH", Path: fmt.Sprintf("/api/users/%d", user.ID), Body: `{"username":"other"}`, Header: map[string]string{"Content-Type": "application/merge-patch+json", "If-Match": newETag}, Params: testutils.IDParam(user.ID), Principal: self})
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = testutils.CallHandler(userHandler.UpdateUser, testutils.HandlerRequest{Method: "PATCH", Path: fmt.Sprintf("/api/users/%d", user.ID), Body: `{"username":"OTHER"}`, Header: map[string]string{"Content-Type": "application/merge-patch+json", "If-Match": newETag}, Params: testutils.IDParam(user.ID), Principal: self})
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// Names that normalize to the current ones change nothing
+	w = testutils.CallHandler(userHandler.UpdateUser, testutils.HandlerRequest{Method: "PATCH", Path: fmt.Sprintf("/api/users/%d", user.ID), Body: `{"username":"PatchMe","email":"New@Example.com"}`, Header: map[string]string{"Content-Type": "application/merge-patch+json", "If-Match": newETag}, Params: testutils.IDParam(user.ID), Principal: self})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, newETag, w.Header().Get("ETag"))
+
+	// Admins may update anyone, and "*" matches any version
+	admin := &auth.Principal{UserID: 999, Permissions: []string{auth.PermissionAdmin}}
+	w = testutils.CallHandler(userHandler.UpdateUser, testutils.HandlerRequest{Method: "PATCH", Path: fmt.Sprintf("/api/users/%d", other.ID), Body: `{"username":"renamed"}`, Header: map[string]string{"Content-Type": "application/merge-patch+json", "If-Match": "*"}, Params: testutils.IDParam(other.ID), Principal: admin})
+	assert.Equal(t, http.StatusOK, w.Code)
+	var renamed models.User
+	assert.NoError(t, db.First(&renamed, other.ID).Error)
+	assert.Equal(t, "renamed", renamed.Username)
+}
+
+func TestUpdateUser_Errors(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db, logger := testutils.SetupTestDB(t)
+	userHandler := handlers.NewUserHandler(db, logger)
+	user := testutils.CreateTestUser(t, db, "patchme", "patchme@example.com", "pw")
+	other := testutils.CreateTestUser(t, db, "other", "other@example.com", "pw")
+	self := &auth.Principal{UserID: user.ID}
+	etag := getETag(t, userHandler, user.ID)
+
+	tests := []struct {
+		name           string
+		principal      *auth.Principal
+		id             uint
+		body           string
+		ifMatch        string
+		expectedStatus int
+		expectedCode   string
+	}{
+		{"Other user", self, other.ID, `{"username":"x"}`, "*", http.StatusForbidden, problem.CodeForbidden},
+		{"Unauthenticated", nil, user.ID, `{"username":"x"}`, "*", http.StatusForbidden, problem.CodeForbidden},
+		{"Missing If-Match", self, user.ID, `{"username":"x"}`, "", http.StatusPreconditionRequired, problem.CodePreconditionRequired},
+		{"Wrong If-Match", self, user.ID, `{"username":"x"}`, `"1-1"`, http.StatusPreconditionFailed, problem.CodePreconditionFailed},
+		{"Unknown field", self, user.ID, `{"password":"x"}`, etag, http.StatusBadRequest, problem.CodeValidationFailed},
+		{"Null field", self, user.ID, `{"email":null}`, etag, http.StatusBadRequest, problem.CodeValidationFailed},
+		{"Wrong type", self, user.ID, `{"username":42}`, etag, http.StatusBadRequest, problem.CodeValidationFailed},
+		{"Invalid email", self, user.ID, `{"email":"nope"}`, etag, http.StatusBadRequest, problem.CodeValidationFailed},
+		{"Empty username", self, user.ID, `{"username":""}`, etag, http.StatusBadRequest, problem.CodeValidationFailed},
+		{"Not an object", self, user.ID, `["username"]`, etag, http.StatusBadRequest, problem.CodeMalformedBody},
+		{"Empty body", self, user.ID, ``, etag, http.StatusBadRequest, problem.CodeMalformedBody},
+		{"Unknown user", &auth.Principal{UserID: 999, Permissions: []string{auth.PermissionAdmin}}, 999, `{}`, "*", http.StatusNotFound, problem.CodeNotFound},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			w := testutils.CallHandler(userHandler.UpdateUser, testutils.HandlerRequest{Method: "PATCH", Path: fmt.Sprintf("/api/users/%d", tc.id), Body: tc.body, Header: map[string]string{"Content-Type": "application/merge-patch+json", "If-Match": tc.ifMatch}, Params: testutils.IDParam(tc.id), Principal: tc.principal})
+			assert.Equal(t, tc.expectedStatus, w.Code)
+
+			var response map[string]interface{}
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Equal(t, tc.expectedCode, response["error_code"])
+		})
+	}
+
+	// Other media types are refused
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("PATCH", "/", strings.NewReader(`username=x`))
+	c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	c.Params = gin.Params{{Key: "id", Value: fmt.Sprint(user.ID)}}
+	c.Set(auth.PrincipalContextKey, self)
+	userHandler.UpdateUser(c)
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+}
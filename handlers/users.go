@@ -1,92 +1,187 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
+	"io"
+	"maps"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"go.uber.org/zap"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 
+	"example.com/ginhello/audit"
+	"example.com/ginhello/auth"
+	"example.com/ginhello/logging"
 	"example.com/ginhello/models"
+	"example.com/ginhello/normalize"
+	"example.com/ginhello/password"
+	"example.com/ginhello/problem"
 )
 
 // UserHandler handles user-related requests
 type UserHandler struct {
 	db     *gorm.DB
 	logger *zap.Logger
+	audit  *audit.Recorder
+
+	// Sends verification emails to new and changed addresses when set
+	verification *EmailVerification
+
+	passwordPolicy *password.Policy
+	passwordHasher *password.Hasher
+}
+
+// UserHandlerOption configures optional UserHandler behaviour
+type UserHandlerOption func(*UserHandler)
+
+// WithUserAuditRecorder records user account changes in the audit log
+func WithUserAuditRecorder(recorder *audit.Recorder) UserHandlerOption {
+	return func(h *UserHandler) {
+		h.audit = recorder
+	}
+}
+
+// WithUserEmailVerification sends a verification email when a user is
+// created or changes their email address
+func WithUserEmailVerification(v *EmailVerification) UserHandlerOption {
+	return func(h *UserHandler) {
+		h.verification = v
+	}
+}
+
+// WithUserPasswordPolicy sets the rules for the passwords of new users.
+// Without it password.DefaultPolicy applies.
+func WithUserPasswordPolicy(policy *password.Policy) UserHandlerOption {
+	return func(h *UserHandler) {
+		h.passwordPolicy = policy
+	}
+}
+
+// WithUserPasswordHasher sets how new passwords are hashed. Without it
+// password.DefaultHasher is used.
+func WithUserPasswordHasher(hasher *password.Hasher) UserHandlerOption {
+	return func(h *UserHandler) {
+		h.passwordHasher = hasher
+	}
 }
 
 // NewUserHandler creates a new UserHandler
-func NewUserHandler(db *gorm.DB, logger *zap.Logger) *UserHandler {
-	return &UserHandler{
+func NewUserHandler(db *gorm.DB, logger *zap.Logger, opts ...UserHandlerOption) *UserHandler {
+	h := &UserHandler{
 		db:     db,
 		logger: logger,
 	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	if h.passwordPolicy == nil {
+		h.passwordPolicy = password.DefaultPolicy()
+	}
+	if h.passwordHasher == nil {
+		h.passwordHasher = password.DefaultHasher()
+	}
+	return h
 }
 
-// GetUsers returns all users
+// GetUsers returns a page of users. Clients page either with limit and
+// offset or by following next_cursor, which stays stable while users are
+// added or removed. See parseUserListQuery for the supported parameters.
 func (h *UserHandler) GetUsers(c *gin.Context) {
-	h.logger.Info("Fetching all users")
+	logger := logging.FromContext(c, h.logger)
+	ctx := c.Request.Context()
+
+	query, p := parseUserListQuery(c)
+	if p != nil {
+		problem.Render(c, p)
+		return
+	}
+	logger.Info("Fetching users", zap.Int("limit", query.limit), zap.String("sort", query.sortKey()))
 
+	// Count all matching users, regardless of the page
+	var total int64
+	if err := query.filter(h.db.WithContext(ctx).Model(&models.User{})).Count(&total).Error; err != nil {
+		logger.Error("Database error counting users", zap.Error(err))
+		problem.Error(c, http.StatusInternalServerError, problem.CodeInternal, "Database error")
+		return
+	}
+
+	paged, err := query.page(query.filter(h.db.WithContext(ctx)))
+	if err != nil {
+		problem.Error(c, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid cursor")
+		return
+	}
+
+	// Fetch one extra user to learn whether there is a next page
 	var users []models.User
-	result := h.db.Find(&users)
-	if result.Error != nil {
-		h.logger.Error("Database error fetching users", zap.Error(result.Error))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+	if err := paged.Limit(query.limit + 1).Find(&users).Error; err != nil {
+		logger.Error("Database error fetching users", zap.Error(err))
+		problem.Error(c, http.StatusInternalServerError, problem.CodeInternal, "Database error")
 		return
 	}
 
+	var nextCursor string
+	if len(users) > query.limit {
+		users = users[:query.limit]
+		nextCursor = query.nextCursor(&users[len(users)-1])
+	}
+
 	// Convert to public representation
 	publicUsers := make([]models.PublicUser, len(users))
-	for i, user := range users {
-		publicUsers[i] = models.PublicUser{
-			ID:        user.ID,
-			Username:  user.Username,
-			Email:     user.Email,
-			CreatedAt: user.CreatedAt.Format("2006-01-02T15:04:05Z"),
-		}
+	for i := range users {
+		publicUsers[i] = toPublicUser(&users[i])
 	}
 
-	c.JSON(http.StatusOK, publicUsers)
+	setLinkHeader(c, nextCursor)
+	c.JSON(http.StatusOK, UserListResponse{
+		Data: publicUsers,
+		Pagination: Pagination{
+			Limit:      query.limit,
+			Offset:     query.offset,
+			Total:      total,
+			NextCursor: nextCursor,
+		},
+	})
 }
 
 // GetUserByID returns a user by ID
 func (h *UserHandler) GetUserByID(c *gin.Context) {
+	logger := logging.FromContext(c, h.logger)
+	ctx := c.Request.Context()
 	idStr := c.Param("id")
-	h.logger.Info("Fetching user by ID", zap.String("id", idStr))
+	logger.Info("Fetching user by ID", zap.String("id", idStr))
 
 	// Validate that ID is numeric before querying DB
 	id, err := strconv.ParseUint(idStr, 10, 32)
 	if err != nil {
-		h.logger.Warn("Invalid user ID format", zap.String("id", idStr), zap.Error(err))
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		logger.Warn("Invalid user ID format", zap.String("id", idStr), zap.Error(err))
+		problem.Error(c, http.StatusNotFound, problem.CodeNotFound, "User not found")
 		return
 	}
 
 	var user models.User
-	result := h.db.First(&user, uint(id))
+	result := h.db.WithContext(ctx).First(&user, uint(id))
 	if result.Error != nil {
 		if result.Error == gorm.ErrRecordNotFound {
-			h.logger.Warn("User not found in DB", zap.Uint64("id", id))
-			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			logger.Warn("User not found in DB", zap.Uint64("id", id))
+			problem.Error(c, http.StatusNotFound, problem.CodeNotFound, "User not found")
 		} else {
-			h.logger.Error("Database error fetching user by ID", zap.Uint64("id", id), zap.Error(result.Error))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			logger.Error("Database error fetching user by ID", zap.Uint64("id", id), zap.Error(result.Error))
+			problem.Error(c, http.StatusInternalServerError, problem.CodeInternal, "Database error")
 		}
 		return
 	}
 
 	// Convert to public representation
-	publicUser := models.PublicUser{
-		ID:        user.ID,
-		Username:  user.Username,
-		Email:     user.Email,
-		CreatedAt: user.CreatedAt.Format("2006-01-02T15:04:05Z"),
-	}
+	publicUser := toPublicUser(&user)
 
+	c.Header("ETag", userETag(&user))
 	c.JSON(http.StatusOK, publicUser)
 }
 
@@ -99,18 +194,36 @@ type CreateUserRequest struct {
 
 // CreateUser creates a new user
 func (h *UserHandler) CreateUser(c *gin.Context) {
+	logger := logging.FromContext(c, h.logger)
+	ctx := c.Request.Context()
 	var req CreateUserRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		h.logger.Error("Invalid user creation request", zap.Error(err))
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		logger.Error("Invalid user creation request", zap.Error(err))
+		problem.BindError(c, err)
 		return
 	}
 
-	// Hash the password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	// Validate again since normalizing can empty a username
+	req.Username, req.Email = normalize.Username(req.Username), normalize.Email(req.Email)
+	if err := binding.Validator.ValidateStruct(&req); err != nil {
+		logger.Warn("Invalid user creation request after normalization", zap.Error(err))
+		problem.BindError(c, err)
+		return
+	}
+
+	user := password.User{Username: req.Username, Email: req.Email}
+	if fieldErrors := checkPassword(c, h.logger, h.passwordPolicy, "password", req.Password, user); len(fieldErrors) > 0 {
+		h.auditCreateFailure(c, "policy")
+		problem.Render(c, problem.Validation(fieldErrors...))
+		return
+	}
+
+	// Hash the password before touching the database so that the response
+	// time does not depend on whether the username or email is taken
+	hashedPassword, err := h.passwordHasher.Hash(req.Password)
 	if err != nil {
-		h.logger.Error("Failed to hash password", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process request"})
+		logger.Error("Failed to hash password", zap.Error(err))
+		problem.Error(c, http.StatusInternalServerError, problem.CodeInternal, "Failed to process request")
 		return
 	}
 
@@ -118,32 +231,361 @@ func (h *UserHandler) CreateUser(c *gin.Context) {
 	newUser := models.User{
 		Username: req.Username,
 		Email:    req.Email,
-		Password: string(hashedPassword),
+		Password: hashedPassword,
 	}
 
 	// Save to database
-	result := h.db.Create(&newUser)
+	result := h.db.WithContext(ctx).Create(&newUser)
 	if result.Error != nil {
-		// Check for unique constraint violation (adjust error message check for broader compatibility)
-		errMsg := strings.ToLower(result.Error.Error())
-		if strings.Contains(errMsg, "unique constraint") || strings.Contains(errMsg, "duplicate key value") {
-			h.logger.Warn("Attempted to create user with existing username or email", zap.String("username", req.Username), zap.String("email", req.Email))
-			c.JSON(http.StatusConflict, gin.H{"error": "Username or email already exists"})
+		if isUniqueViolation(result.Error) {
+			// Deliberately not saying which of the two is taken. A conflict
+			// still tells that one of them is; answering 202 and mailing the
+			// owner instead would break clients that sign in with the user
+			// returned here, so registration is rate limited per IP instead.
+			logger.Warn("Attempted to create user with existing username or email", zap.String("username", req.Username), zap.String("email", req.Email))
+			h.auditCreateFailure(c, "conflict")
+			problem.Error(c, http.StatusConflict, problem.CodeConflict, "Username or email already exists")
 		} else {
-			h.logger.Error("Failed to create user in database", zap.Error(result.Error))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+			logger.Error("Failed to create user in database", zap.Error(result.Error))
+			h.auditCreateFailure(c, "error")
+			problem.Error(c, http.StatusInternalServerError, problem.CodeInternal, "Failed to create user")
 		}
 		return
 	}
 
-	h.logger.Info("Created new user", zap.String("username", newUser.Username), zap.Uint("user_id", newUser.ID))
+	h.audit.Record(ctx, withTarget(newAuditEvent(c, audit.ActionUserCreate, audit.OutcomeSuccess), newUser.ID))
+	logger.Info("Created new user", zap.String("username", newUser.Username), zap.Uint("user_id", newUser.ID))
+	if h.verification != nil {
+		h.verification.sendInBackground(c, h.db, logger, newUser)
+	}
 
 	// Convert to public representation
+	publicUser := toPublicUser(&newUser)
+	c.JSON(http.StatusCreated, publicUser)
+}
+
+// auditCreateFailure records a rejected user creation
+func (h *UserHandler) auditCreateFailure(c *gin.Context, reason string) {
+	event := newAuditEvent(c, audit.ActionUserCreate, audit.OutcomeFailure)
+	event.Reason = reason
+	h.audit.Record(c.Request.Context(), event)
+}
+
+// UpdateUserRequest holds the fields a merge patch may change
+type UpdateUserRequest struct {
+	Username *string `json:"username" binding:"omitempty,min=1"`
+	Email    *string `json:"email" binding:"omitempty,email"`
+}
+
+// UpdateUser applies a JSON merge patch (RFC 7396) to a user. Users may only
+// update themselves unless they are administrators. The request must send the
+// user's current ETag in If-Match so that concurrent updates are not lost.
+func (h *UserHandler) UpdateUser(c *gin.Context) {
+	logger := logging.FromContext(c, h.logger)
+	idStr := c.Param("id")
+
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		logger.Warn("Invalid user ID format", zap.String("id", idStr), zap.Error(err))
+		problem.Error(c, http.StatusNotFound, problem.CodeNotFound, "User not found")
+		return
+	}
+	if !canModifyUser(c, uint(id)) {
+		problem.Error(c, http.StatusForbidden, problem.CodeForbidden, "You may only update your own account")
+		return
+	}
+
+	user, ok := h.updateUser(c, uint(id))
+	if !ok {
+		return
+	}
+	c.Header("ETag", userETag(user))
+	c.JSON(http.StatusOK, toPublicUser(user))
+}
+
+// updateUser applies the merge patch in the request body to the user with the
+// given ID and returns the updated user. It renders a problem and returns
+// false if the patch cannot be applied.
+func (h *UserHandler) updateUser(c *gin.Context, id uint) (*models.User, bool) {
+	logger := logging.FromContext(c, h.logger)
+	ctx := c.Request.Context()
+
+	switch c.ContentType() {
+	case mimeMergePatch, gin.MIMEJSON:
+	default:
+		problem.Error(c, http.StatusUnsupportedMediaType, problem.CodeUnsupportedMediaType,
+			"Content-Type must be "+mimeMergePatch)
+		return nil, false
+	}
+	req, p := parseUserPatch(c)
+	if p != nil {
+		problem.Render(c, p)
+		return nil, false
+	}
+
+	var user models.User
+	result := h.db.WithContext(ctx).First(&user, id)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			problem.Error(c, http.StatusNotFound, problem.CodeNotFound, "User not found")
+		} else {
+			logger.Error("Database error fetching user to update", zap.Uint("id", id), zap.Error(result.Error))
+			problem.Error(c, http.StatusInternalServerError, problem.CodeInternal, "Database error")
+		}
+		return nil, false
+	}
+	if !checkIfMatch(c, userETag(&user)) {
+		return nil, false
+	}
+
+	updates := map[string]interface{}{}
+	if req.Username != nil && *req.Username != user.Username {
+		updates["username"] = *req.Username
+	}
+	if req.Email != nil && *req.Email != user.Email {
+		// A new address has to be verified again
+		updates["email"] = *req.Email
+		updates["email_verified_at"] = nil
+	}
+	if len(updates) > 0 {
+		// Only update the version the client has seen; a concurrent update
+		// between the read above and this write leaves no rows to update
+		updates["updated_at"] = versionTimestamp()
+		result = h.db.WithContext(ctx).Model(&user).Where("updated_at = ?", user.UpdatedAt).Updates(updates)
+		if result.Error != nil {
+			if isUniqueViolation(result.Error) {
+				logger.Warn("Attempted to update user to existing username or email", zap.Uint("user_id", user.ID))
+				h.auditUpdateFailure(c, user.ID, "conflict")
+				problem.Error(c, http.StatusConflict, problem.CodeConflict, "Username or email already exists")
+			} else {
+				logger.Error("Failed to update user in database", zap.Uint("user_id", user.ID), zap.Error(result.Error))
+				h.auditUpdateFailure(c, user.ID, "error")
+				problem.Error(c, http.StatusInternalServerError, problem.CodeInternal, "Failed to update user")
+			}
+			return nil, false
+		}
+		if result.RowsAffected == 0 {
+			problem.Error(c, http.StatusPreconditionFailed, problem.CodePreconditionFailed,
+				"The user has been modified; fetch it again and retry")
+			return nil, false
+		}
+
+		h.audit.Record(ctx, withTarget(newAuditEvent(c, audit.ActionUserUpdate, audit.OutcomeSuccess), user.ID))
+		logger.Info("Updated user", zap.Uint("user_id", user.ID))
+		if _, changed := updates["email"]; changed && h.verification != nil {
+			h.verification.sendInBackground(c, h.db, logger, user)
+		}
+	}
+	return &user, true
+}
+
+// auditUpdateFailure records a rejected user update
+func (h *UserHandler) auditUpdateFailure(c *gin.Context, userID uint, reason string) {
+	event := withTarget(newAuditEvent(c, audit.ActionUserUpdate, audit.OutcomeFailure), userID)
+	event.Reason = reason
+	h.audit.Record(c.Request.Context(), event)
+}
+
+// DeleteUser soft deletes a user and revokes all tokens issued to them. Users
+// may delete themselves; administrators may delete anyone. Deleted users can
+// be restored until they are purged.
+func (h *UserHandler) DeleteUser(c *gin.Context) {
+	logger := logging.FromContext(c, h.logger)
+	idStr := c.Param("id")
+
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		logger.Warn("Invalid user ID format", zap.String("id", idStr), zap.Error(err))
+		problem.Error(c, http.StatusNotFound, problem.CodeNotFound, "User not found")
+		return
+	}
+	if !canModifyUser(c, uint(id)) {
+		problem.Error(c, http.StatusForbidden, problem.CodeForbidden, "You may only delete your own account")
+		return
+	}
+
+	h.deleteUser(c, uint(id))
+}
+
+// deleteUser soft deletes the user with the given ID and revokes their tokens
+func (h *UserHandler) deleteUser(c *gin.Context, id uint) {
+	logger := logging.FromContext(c, h.logger)
+	ctx := c.Request.Context()
+
+	err := h.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.First(&user, id).Error; err != nil {
+			return err
+		}
+		// Bumping the version revokes the user's tokens even if the account
+		// is restored later
+		if err := tx.Model(&user).UpdateColumn("token_version", gorm.Expr("token_version + 1")).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&user).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			problem.Error(c, http.StatusNotFound, problem.CodeNotFound, "User not found")
+		} else {
+			logger.Error("Failed to delete user", zap.Uint("id", id), zap.Error(err))
+			problem.Error(c, http.StatusInternalServerError, problem.CodeInternal, "Failed to delete user")
+		}
+		return
+	}
+
+	h.audit.Record(ctx, withTarget(newAuditEvent(c, audit.ActionUserDelete, audit.OutcomeSuccess), id))
+	logger.Info("Deleted user", zap.Uint("user_id", id))
+	c.Status(http.StatusNoContent)
+}
+
+// RestoreUser undoes the soft deletion of a user (admin only). Tokens issued
+// before the deletion stay revoked.
+func (h *UserHandler) RestoreUser(c *gin.Context) {
+	logger := logging.FromContext(c, h.logger)
+	ctx := c.Request.Context()
+	idStr := c.Param("id")
+
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		logger.Warn("Invalid user ID format", zap.String("id", idStr), zap.Error(err))
+		problem.Error(c, http.StatusNotFound, problem.CodeNotFound, "Deleted user not found")
+		return
+	}
+
+	var user models.User
+	result := h.db.WithContext(ctx).Unscoped().Where("deleted_at IS NOT NULL").First(&user, uint(id))
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			problem.Error(c, http.StatusNotFound, problem.CodeNotFound, "Deleted user not found")
+		} else {
+			logger.Error("Database error fetching user to restore", zap.Uint64("id", id), zap.Error(result.Error))
+			problem.Error(c, http.StatusInternalServerError, problem.CodeInternal, "Database error")
+		}
+		return
+	}
+
+	updatedAt := versionTimestamp()
+	result = h.db.WithContext(ctx).Unscoped().Model(&user).Updates(map[string]interface{}{
+		"deleted_at": nil,
+		"updated_at": updatedAt,
+	})
+	if result.Error != nil {
+		// The name may have been registered again in the meantime
+		if isUniqueViolation(result.Error) {
+			h.auditRestoreFailure(c, user.ID, "conflict")
+			problem.Error(c, http.StatusConflict, problem.CodeConflict, "Username or email has been taken by another user")
+		} else {
+			logger.Error("Failed to restore user", zap.Uint("user_id", user.ID), zap.Error(result.Error))
+			h.auditRestoreFailure(c, user.ID, "error")
+			problem.Error(c, http.StatusInternalServerError, problem.CodeInternal, "Failed to restore user")
+		}
+		return
+	}
+	user.DeletedAt = gorm.DeletedAt{}
+	user.UpdatedAt = updatedAt
+
+	h.audit.Record(ctx, withTarget(newAuditEvent(c, audit.ActionUserRestore, audit.OutcomeSuccess), user.ID))
+	logger.Info("Restored user", zap.Uint("user_id", user.ID))
+	c.Header("ETag", userETag(&user))
+	c.JSON(http.StatusOK, toPublicUser(&user))
+}
+
+// auditRestoreFailure records a failed user restore
+func (h *UserHandler) auditRestoreFailure(c *gin.Context, userID uint, reason string) {
+	event := withTarget(newAuditEvent(c, audit.ActionUserRestore, audit.OutcomeFailure), userID)
+	event.Reason = reason
+	h.audit.Record(c.Request.Context(), event)
+}
+
+// mimeMergePatch is the media type of JSON merge patches
+const mimeMergePatch = "application/merge-patch+json"
+
+// parseUserPatch reads a merge patch from the request body. Members other
+// than username and email are rejected rather than ignored, and so are
+// nulls, which would mean removing a required field.
+func parseUserPatch(c *gin.Context) (*UpdateUserRequest, *problem.Problem) {
+	var patch map[string]json.RawMessage
+	if err := json.NewDecoder(c.Request.Body).Decode(&patch); err != nil || patch == nil {
+		if errors.Is(err, io.EOF) {
+			return nil, problem.FromBindError(err)
+		}
+		return nil, problem.New(http.StatusBadRequest, problem.CodeMalformedBody, "Request body must be a JSON object")
+	}
+
+	var req UpdateUserRequest
+	var fieldErrors []problem.FieldError
+	for _, field := range slices.Sorted(maps.Keys(patch)) {
+		raw := patch[field]
+		var target **string
+		switch field {
+		case "username":
+			target = &req.Username
+		case "email":
+			target = &req.Email
+		default:
+			fieldErrors = append(fieldErrors, problem.FieldError{Field: field, Code: "unknown", Message: "cannot be updated"})
+			continue
+		}
+
+		if string(raw) == "null" {
+			fieldErrors = append(fieldErrors, problem.FieldError{Field: field, Code: "required", Message: "cannot be removed"})
+			continue
+		}
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			fieldErrors = append(fieldErrors, problem.FieldError{Field: field, Code: "type", Message: "must be of type string"})
+			continue
+		}
+		if field == "username" {
+			value = normalize.Username(value)
+		} else {
+			value = normalize.Email(value)
+		}
+		*target = &value
+	}
+	if len(fieldErrors) > 0 {
+		return nil, problem.Validation(fieldErrors...)
+	}
+
+	if err := binding.Validator.ValidateStruct(&req); err != nil {
+		return nil, problem.FromBindError(err)
+	}
+	return &req, nil
+}
+
+// canModifyUser reports whether the caller may modify the user with the given ID
+func canModifyUser(c *gin.Context, userID uint) bool {
+	principal, ok := auth.PrincipalFrom(c)
+	if !ok {
+		return false
+	}
+	return principal.UserID == userID || principal.HasPermission(auth.PermissionAdmin)
+}
+
+// isUniqueViolation reports whether err is a unique constraint violation
+// (the message check covers both SQLite and Postgres)
+func isUniqueViolation(err error) bool {
+	errMsg := strings.ToLower(err.Error())
+	return strings.Contains(errMsg, "unique constraint") || strings.Contains(errMsg, "duplicate key value")
+}
+
+// toPublicUser converts a user to its public representation
+func toPublicUser(user *models.User) models.PublicUser {
 	publicUser := models.PublicUser{
-		ID:        newUser.ID,
-		Username:  newUser.Username,
-		Email:     newUser.Email,
-		CreatedAt: newUser.CreatedAt.Format("2006-01-02T15:04:05Z"),
+		ID:        user.ID,
+		Username:  user.Username,
+		Email:     user.Email,
+		CreatedAt: formatTime(user.CreatedAt),
 	}
-	c.JSON(http.StatusCreated, publicUser)
+	if user.DeletedAt.Valid {
+		deletedAt := formatTime(user.DeletedAt.Time)
+		publicUser.DeletedAt = &deletedAt
+	}
+	return publicUser
+}
+
+// formatTime formats a timestamp the way user representations show them
+func formatTime(t time.Time) string {
+	return t.Format("2006-01-02T15:04:05Z")
 }
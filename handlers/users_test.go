@@ -1,10 +1,12 @@
 package handlers_test
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"strings"
 	"testing"
 
@@ -13,6 +15,8 @@ import (
 
 	"example.com/ginhello/handlers"
 	"example.com/ginhello/models"
+	"example.com/ginhello/password"
+	"example.com/ginhello/problem"
 	"example.com/ginhello/testutils"
 )
 
@@ -38,14 +42,16 @@ func TestGetUsers(t *testing.T) {
 	// Assert
 	assert.Equal(t, http.StatusOK, w.Code)
 
-	var response []models.PublicUser
+	var response handlers.UserListResponse
 	err := json.NewDecoder(w.Body).Decode(&response)
 	assert.NoError(t, err)
-	assert.Len(t, response, 2)
+	assert.Len(t, response.Data, 2)
+	assert.Equal(t, int64(2), response.Pagination.Total)
+	assert.Empty(t, response.Pagination.NextCursor)
 
 	// Check usernames (order might not be guaranteed)
 	foundUsernames := map[string]bool{}
-	for _, u := range response {
+	for _, u := range response.Data {
 		foundUsernames[u.Username] = true
 	}
 	assert.True(t, foundUsernames[u1.Username])
@@ -187,6 +193,47 @@ func TestCreateUser(t *testing.T) {
 			expectedStatus: http.StatusConflict,
 			expectedError:  true,
 		},
+		{
+			name: "Username is normalized",
+			requestBody: `{
+				"username": " MixedCase ",
+				"email": "Mixed@Example.com",
+				"password": "password"
+			}`,
+			expectedStatus:   http.StatusCreated,
+			expectedError:    false,
+			expectedUsername: "mixedcase",
+		},
+		{
+			name: "Duplicate username in other case",
+			requestBody: `{
+				"username": "ExistingUser",
+				"email": "case@example.com",
+				"password": "password"
+			}`,
+			expectedStatus: http.StatusConflict,
+			expectedError:  true,
+		},
+		{
+			name: "Duplicate email in other case",
+			requestBody: `{
+				"username": "caseuser",
+				"email": "EXISTING@example.com",
+				"password": "password"
+			}`,
+			expectedStatus: http.StatusConflict,
+			expectedError:  true,
+		},
+		{
+			name: "Blank username",
+			requestBody: `{
+				"username": "   ",
+				"email": "blank@example.com",
+				"password": "password"
+			}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  true,
+		},
 	}
 
 	for _, tc := range tests {
@@ -220,3 +267,97 @@ func TestCreateUser(t *testing.T) {
 		})
 	}
 }
+
+func TestCreateUser_ValidationProblem(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db, logger := testutils.SetupTestDB(t)
+	userHandler := handlers.NewUserHandler(db, logger)
+
+	body := `{"username":"someone","email":"not-an-email"}`
+	req := httptest.NewRequest("POST", "/api/users", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", problem.ContentType)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+
+	userHandler.CreateUser(c)
+
+	// Assert
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, problem.ContentType, w.Header().Get("Content-Type"))
+
+	var response problem.Problem
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, problem.CodeValidationFailed, response.Code)
+	assert.Equal(t, []problem.FieldError{
+		{Field: "email", Code: "email", Message: "must be a valid email address"},
+		{Field: "password", Code: "required", Message: "is required"},
+	}, response.Errors)
+	assert.NotContains(t, w.Body.String(), "Key: ")
+}
+
+// breachList is a password.BreachChecker knowing a fixed set of passwords
+type breachList []string
+
+func (b breachList) Breached(_ context.Context, pw string) (bool, error) {
+	return slices.Contains(b, pw), nil
+}
+
+func TestCreateUser_PasswordPolicy(t *testing.T) {
+	policy := password.DefaultPolicy()
+	policy.Breaches = breachList{"Summer2024!"}
+
+	tests := []struct {
+		name     string
+		password string
+		codes    []string
+	}{
+		{name: "Too short", password: "abc", codes: []string{password.CodeMinLength, password.CodeWeak}},
+		{name: "Contains username", password: "xQ7-policyuser-9z", codes: []string{password.CodeUsername}},
+		{name: "Breached", password: "Summer2024!", codes: []string{password.CodeBreached}},
+		{name: "Acceptable", password: "correct-horse-battery"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Setup
+			gin.SetMode(gin.TestMode)
+			db, logger := testutils.SetupTestDB(t)
+			userHandler := handlers.NewUserHandler(db, logger, handlers.WithUserPasswordPolicy(policy))
+
+			body, _ := json.Marshal(handlers.CreateUserRequest{
+				Username: "policyuser", Email: "someone@example.com", Password: tt.password,
+			})
+			req := httptest.NewRequest("POST", "/api/users", strings.NewReader(string(body)))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Accept", problem.ContentType)
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = req
+
+			// Test
+			userHandler.CreateUser(c)
+
+			// Assert
+			if tt.codes == nil {
+				assert.Equal(t, http.StatusCreated, w.Code)
+				return
+			}
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			var response problem.Problem
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Equal(t, problem.CodeValidationFailed, response.Code)
+			var codes []string
+			for _, fieldErr := range response.Errors {
+				assert.Equal(t, "password", fieldErr.Field)
+				codes = append(codes, fieldErr.Code)
+			}
+			assert.Equal(t, tt.codes, codes)
+			var count int64
+			db.Model(&models.User{}).Count(&count)
+			assert.Zero(t, count)
+		})
+	}
+}
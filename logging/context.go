@@ -0,0 +1,32 @@
+package logging
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+// LoggerContextKey is the gin context key under which the request logger is
+// stored. *gin.Context resolves string keys from its own key store, so
+// FromContext works on it without importing gin here.
+const LoggerContextKey = "logger"
+
+type loggerKey struct{}
+
+// WithLogger returns a copy of ctx carrying the logger
+func WithLogger(ctx context.Context, logger *zap.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the request logger stored in ctx, or fallback when the
+// context carries none. It accepts both a *gin.Context and a plain
+// context.Context derived from the request.
+func FromContext(ctx context.Context, fallback *zap.Logger) *zap.Logger {
+	if logger, ok := ctx.Value(LoggerContextKey).(*zap.Logger); ok && logger != nil {
+		return logger
+	}
+	if logger, ok := ctx.Value(loggerKey{}).(*zap.Logger); ok && logger != nil {
+		return logger
+	}
+	return fallback
+}
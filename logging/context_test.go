@@ -0,0 +1,21 @@
+package logging
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestFromContext(t *testing.T) {
+	fallback := zap.NewNop()
+	logger := zap.NewExample()
+
+	// Empty context returns the fallback
+	assert.Same(t, fallback, FromContext(context.Background(), fallback))
+
+	// Context carrying a logger returns it
+	ctx := WithLogger(context.Background(), logger)
+	assert.Same(t, logger, FromContext(ctx, fallback))
+}
@@ -0,0 +1,156 @@
+package logging
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces sensitive values in logs
+const Redacted = "[REDACTED]"
+
+// DefaultRedactedKeys are log field keys and query parameters whose values are
+// never logged
+var DefaultRedactedKeys = []string{
+	"password", "new_password", "current_password",
+	"token", "access_token", "refresh_token", "id_token", "reset_token",
+	"code", "secret", "client_secret", "api_key", "authorization", "cookie",
+}
+
+// DefaultRedactedHeaders are request headers whose values are never logged
+var DefaultRedactedHeaders = []string{
+	"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key",
+}
+
+// ipFieldKeys are log field keys holding client IPs
+var ipFieldKeys = map[string]bool{"ip": true, "client_ip": true}
+
+// emailPattern matches email addresses, keeping the first character of the
+// local part and the domain in submatches
+var emailPattern = regexp.MustCompile(`([A-Za-z0-9])[A-Za-z0-9._%+-]*(@[A-Za-z0-9.-]+\.[A-Za-z]{2,})`)
+
+// RedactionPolicy controls what the Redactor removes from logs
+type RedactionPolicy struct {
+	Keys        []string // Field keys and query parameters to redact, case-insensitive
+	Headers     []string // Header names to redact
+	MaskEmails  bool     // Mask email addresses in all string values
+	AnonymizeIP bool     // Zero the host part of client IPs
+}
+
+// DefaultRedactionPolicy redacts the default keys and headers and masks emails
+func DefaultRedactionPolicy() RedactionPolicy {
+	return RedactionPolicy{
+		Keys:       DefaultRedactedKeys,
+		Headers:    DefaultRedactedHeaders,
+		MaskEmails: true,
+	}
+}
+
+// Redactor removes sensitive data from values before they are logged
+type Redactor struct {
+	keys        map[string]bool
+	headers     map[string]bool
+	maskEmails  bool
+	anonymizeIP bool
+}
+
+// NewRedactor creates a redactor enforcing policy
+func NewRedactor(policy RedactionPolicy) *Redactor {
+	r := &Redactor{
+		keys:        make(map[string]bool, len(policy.Keys)),
+		headers:     make(map[string]bool, len(policy.Headers)),
+		maskEmails:  policy.MaskEmails,
+		anonymizeIP: policy.AnonymizeIP,
+	}
+	for _, key := range policy.Keys {
+		r.keys[strings.ToLower(key)] = true
+	}
+	for _, header := range policy.Headers {
+		r.headers[http.CanonicalHeaderKey(header)] = true
+	}
+	return r
+}
+
+// Query redacts the values of sensitive parameters in a raw query string and
+// masks emails in the others. Parameter order and encoding are preserved.
+func (r *Redactor) Query(rawQuery string) string {
+	if rawQuery == "" {
+		return rawQuery
+	}
+	params := strings.Split(rawQuery, "&")
+	for i, param := range params {
+		rawKey, rawValue, hasValue := strings.Cut(param, "=")
+		key, err := url.QueryUnescape(rawKey)
+		if err != nil {
+			key = rawKey
+		}
+
+		switch {
+		case r.keys[strings.ToLower(key)]:
+			params[i] = rawKey + "=" + Redacted
+		case hasValue && r.maskEmails:
+			value, err := url.QueryUnescape(rawValue)
+			if err != nil {
+				continue
+			}
+			if masked := MaskEmails(value); masked != value {
+				params[i] = rawKey + "=" + url.QueryEscape(masked)
+			}
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// Headers returns a copy of h with sensitive header values redacted
+func (r *Redactor) Headers(h http.Header) http.Header {
+	redacted := make(http.Header, len(h))
+	for name, values := range h {
+		if r.headers[http.CanonicalHeaderKey(name)] {
+			redacted[name] = []string{Redacted}
+			continue
+		}
+		redacted[name] = values
+	}
+	return redacted
+}
+
+// String applies the policy to a string value logged under key
+func (r *Redactor) String(key, value string) string {
+	lowerKey := strings.ToLower(key)
+	switch {
+	case r.keys[lowerKey]:
+		return Redacted
+	case lowerKey == "query":
+		return r.Query(value)
+	case ipFieldKeys[lowerKey] && r.anonymizeIP:
+		return AnonymizeIP(value)
+	case r.maskEmails:
+		return MaskEmails(value)
+	}
+	return value
+}
+
+// MaskEmails replaces every email address in s with its first character and
+// domain, e.g. "jane@example.com" becomes "j***@example.com"
+func MaskEmails(s string) string {
+	if !strings.Contains(s, "@") {
+		return s
+	}
+	return emailPattern.ReplaceAllString(s, "$1***$2")
+}
+
+// AnonymizeIP zeroes the host part of an IP address: the last octet of IPv4
+// addresses and everything after the /48 prefix of IPv6 addresses. Values
+// that are not IP addresses are returned unchanged.
+func AnonymizeIP(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return parsed.Mask(net.CIDRMask(48, 128)).String()
+}
@@ -0,0 +1,100 @@
+package logging
+
+import (
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// RequestHeaders returns a field logging request headers. Headers in
+// DefaultRedactedHeaders are always redacted; a logger wrapped by a Redactor
+// applies its own header list instead.
+func RequestHeaders(h http.Header) zap.Field {
+	return zap.Object("headers", headerMarshaler{NewRedactor(DefaultRedactionPolicy()).Headers(h), h})
+}
+
+// headerMarshaler logs redacted headers while keeping the originals so that
+// a Redactor can apply its own policy
+type headerMarshaler struct {
+	redacted http.Header
+	original http.Header
+}
+
+// MarshalLogObject implements zapcore.ObjectMarshaler
+func (m headerMarshaler) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	for name, values := range m.redacted {
+		if len(values) == 1 {
+			enc.AddString(name, values[0])
+			continue
+		}
+		if err := enc.AddReflected(name, values); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WrapCore returns a zap option applying the redactor to every entry written
+// by the logger and its children
+func (r *Redactor) WrapCore() zap.Option {
+	return zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return &redactingCore{Core: core, redactor: r}
+	})
+}
+
+// redactingCore redacts fields and messages before passing them on
+type redactingCore struct {
+	zapcore.Core
+	redactor *Redactor
+}
+
+// With implements zapcore.Core
+func (c *redactingCore) With(fields []zapcore.Field) zapcore.Core {
+	return &redactingCore{Core: c.Core.With(c.redactor.fields(fields)), redactor: c.redactor}
+}
+
+// Check implements zapcore.Core. It must add this core rather than the
+// wrapped one so that Write goes through redaction.
+func (c *redactingCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+// Write implements zapcore.Core
+func (c *redactingCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	if c.redactor.maskEmails {
+		entry.Message = MaskEmails(entry.Message)
+	}
+	return c.Core.Write(entry, c.redactor.fields(fields))
+}
+
+// fields returns a redacted copy of fields
+func (r *Redactor) fields(fields []zapcore.Field) []zapcore.Field {
+	redacted := make([]zapcore.Field, len(fields))
+	for i, field := range fields {
+		redacted[i] = r.field(field)
+	}
+	return redacted
+}
+
+// field redacts a single field
+func (r *Redactor) field(field zapcore.Field) zapcore.Field {
+	if r.keys[strings.ToLower(field.Key)] {
+		return zap.String(field.Key, Redacted)
+	}
+
+	switch field.Type {
+	case zapcore.StringType:
+		field.String = r.String(field.Key, field.String)
+	case zapcore.ObjectMarshalerType:
+		if headers, ok := field.Interface.(headerMarshaler); ok {
+			headers.redacted = r.Headers(headers.original)
+			field.Interface = headers
+		}
+	}
+	return field
+}
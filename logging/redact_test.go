@@ -0,0 +1,116 @@
+package logging
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRedactor_Query(t *testing.T) {
+	r := NewRedactor(DefaultRedactionPolicy())
+
+	tests := []struct {
+		name     string
+		query    string
+		expected string
+	}{
+		{"Empty", "", ""},
+		{"Nothing sensitive", "page=2&sort=name", "page=2&sort=name"},
+		{"Token", "access_token=abc.def.ghi&page=2", "access_token=[REDACTED]&page=2"},
+		{"Case-insensitive key", "Code=123456", "Code=[REDACTED]"},
+		{"Escaped key", "refresh%5Ftoken=abc", "refresh%5Ftoken=[REDACTED]"},
+		{"Email value", "q=jane.doe%40example.com&x=1", "q=j%2A%2A%2A%40example.com&x=1"},
+		{"Key without value", "token&page=1", "token=[REDACTED]&page=1"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, r.Query(tc.query))
+		})
+	}
+}
+
+func TestRedactor_Headers(t *testing.T) {
+	r := NewRedactor(RedactionPolicy{Headers: []string{"authorization", "X-Custom-Secret"}})
+	headers := http.Header{
+		"Authorization":   {"Bearer abc"},
+		"X-Custom-Secret": {"s3cret"},
+		"Accept":          {"application/json"},
+	}
+
+	redacted := r.Headers(headers)
+
+	assert.Equal(t, http.Header{
+		"Authorization":   {Redacted},
+		"X-Custom-Secret": {Redacted},
+		"Accept":          {"application/json"},
+	}, redacted)
+	assert.Equal(t, "Bearer abc", headers.Get("Authorization"), "the original must not be modified")
+}
+
+func TestMaskEmails(t *testing.T) {
+	assert.Equal(t, "no email here", MaskEmails("no email here"))
+	assert.Equal(t, "j***@example.com", MaskEmails("jane.doe@example.com"))
+	assert.Equal(t, "from a***@x.org to b***@y.co.uk", MaskEmails("from alice@x.org to b@y.co.uk"))
+	assert.Equal(t, "@handle", MaskEmails("@handle"))
+}
+
+func TestAnonymizeIP(t *testing.T) {
+	assert.Equal(t, "192.0.2.0", AnonymizeIP("192.0.2.123"))
+	assert.Equal(t, "2001:db8:abcd::", AnonymizeIP("2001:db8:abcd:12:34::1"))
+	assert.Equal(t, "not-an-ip", AnonymizeIP("not-an-ip"))
+}
+
+func TestRedactor_WrapCore(t *testing.T) {
+	// Setup
+	core, logs := observer.New(zap.InfoLevel)
+	r := NewRedactor(RedactionPolicy{
+		Keys:        DefaultRedactedKeys,
+		Headers:     []string{"Authorization", "X-Custom-Secret"},
+		MaskEmails:  true,
+		AnonymizeIP: true,
+	})
+	logger := zap.New(core, r.WrapCore()).With(zap.String("email", "jane@example.com"))
+
+	// Test
+	logger.Info("Created user jane@example.com",
+		zap.String("password", "hunter2"),
+		zap.Int("code", 123456),
+		zap.String("query", "token=abc&page=1"),
+		zap.String("ip", "192.0.2.123"),
+		zap.String("username", "bob"),
+		RequestHeaders(http.Header{"Authorization": {"Bearer abc"}, "X-Custom-Secret": {"s3cret"}, "Accept": {"*/*"}}),
+	)
+
+	// Assert
+	entries := logs.All()
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "Created user j***@example.com", entries[0].Message)
+		fields := entries[0].ContextMap()
+		assert.Equal(t, "j***@example.com", fields["email"])
+		assert.Equal(t, Redacted, fields["password"])
+		assert.Equal(t, Redacted, fields["code"])
+		assert.Equal(t, "token=[REDACTED]&page=1", fields["query"])
+		assert.Equal(t, "192.0.2.0", fields["ip"])
+		assert.Equal(t, "bob", fields["username"])
+		assert.Equal(t, map[string]interface{}{
+			"Authorization":   Redacted,
+			"X-Custom-Secret": Redacted,
+			"Accept":          "*/*",
+		}, fields["headers"])
+	}
+}
+
+func TestRequestHeaders_Unwrapped(t *testing.T) {
+	// Without a redacting core the default headers are still redacted
+	core, logs := observer.New(zap.InfoLevel)
+	zap.New(core).Info("panic", RequestHeaders(http.Header{"Authorization": {"Bearer abc"}, "X-Custom-Secret": {"s3cret"}}))
+
+	assert.Equal(t, map[string]interface{}{
+		"Authorization":   Redacted,
+		"X-Custom-Secret": "s3cret",
+	}, logs.All()[0].ContextMap()["headers"])
+}
@@ -0,0 +1,42 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// FileMailer writes each message to its own .eml file in a directory instead
+// of sending it, for local development
+type FileMailer struct {
+	dir  string
+	from string
+	seq  atomic.Uint64
+	now  func() time.Time
+}
+
+// NewFileMailer creates a mailer writing to dir, creating it if needed
+func NewFileMailer(dir, from string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileMailer{dir: dir, from: from, now: time.Now}, nil
+}
+
+// Send implements Mailer
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	now := m.now()
+	data, err := msg.Bytes(m.from, now)
+	if err != nil {
+		return err
+	}
+	// The sequence number keeps names unique within the same nanosecond
+	name := fmt.Sprintf("%s-%d.eml", now.UTC().Format("20060102T150405.000000000"), m.seq.Add(1))
+	return os.WriteFile(filepath.Join(m.dir, name), data, 0o600)
+}
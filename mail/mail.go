@@ -0,0 +1,88 @@
+// Package mail sends the emails the service needs, such as password reset
+// links, through a pluggable Mailer.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"strings"
+	"time"
+
+	"example.com/ginhello/config"
+)
+
+// Mail drivers selectable with MAIL_DRIVER
+const (
+	DriverSMTP   = "smtp"
+	DriverFile   = "file"
+	DriverMemory = "memory"
+)
+
+// ErrInvalidHeader is returned for messages whose headers contain line breaks
+var ErrInvalidHeader = errors.New("mail header contains a line break")
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends email
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// FromConfig creates the mailer selected by cfg.MailDriver. It returns a nil
+// Mailer if no driver is set, leaving mail disabled.
+func FromConfig(cfg *config.Config) (Mailer, error) {
+	switch cfg.MailDriver {
+	case "":
+		return nil, nil
+	case DriverSMTP:
+		return NewSMTPMailer(SMTPConfig{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.MailFrom,
+		})
+	case DriverFile:
+		return NewFileMailer(cfg.MailDir, cfg.MailFrom)
+	case DriverMemory:
+		return NewMemoryMailer(), nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", cfg.MailDriver)
+	}
+}
+
+// Bytes formats msg as an RFC 5322 message sent from from
+func (msg Message) Bytes(from string, date time.Time) ([]byte, error) {
+	for _, header := range []string{from, msg.To, msg.Subject} {
+		if strings.ContainsAny(header, "\r\n") {
+			return nil, ErrInvalidHeader
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	w := quotedprintable.NewWriter(&buf)
+	if _, err := w.Write([]byte(strings.ReplaceAll(msg.Body, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,98 @@
+package mail
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"example.com/ginhello/config"
+)
+
+func TestMessage_Bytes(t *testing.T) {
+	// Setup
+	msg := Message{To: "user@example.com", Subject: "Héllo", Body: "line one\nline two"}
+	date := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	// Test
+	data, err := msg.Bytes("App <noreply@example.com>", date)
+
+	// Assert
+	assert.NoError(t, err)
+	text := string(data)
+	assert.Contains(t, text, "From: App <noreply@example.com>\r\n")
+	assert.Contains(t, text, "To: user@example.com\r\n")
+	assert.Contains(t, text, "Subject: =?utf-8?q?H=C3=A9llo?=\r\n")
+	assert.Contains(t, text, "Date: Fri, 02 Jan 2026 03:04:05 +0000\r\n")
+	assert.True(t, strings.HasSuffix(text, "\r\n\r\nline one\r\nline two"))
+}
+
+func TestMessage_Bytes_HeaderInjection(t *testing.T) {
+	msg := Message{To: "user@example.com\r\nBcc: victim@example.com", Subject: "Hi"}
+	_, err := msg.Bytes("noreply@example.com", time.Now())
+	assert.ErrorIs(t, err, ErrInvalidHeader)
+}
+
+func TestRender_PasswordReset(t *testing.T) {
+	// Test
+	msg, err := Render(TemplatePasswordReset, "user@example.com", PasswordResetData{
+		Username:  "alice",
+		ResetURL:  "https://app.example.com/reset?token=abc",
+		ExpiresIn: "1 hour",
+	})
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, "user@example.com", msg.To)
+	assert.Equal(t, "Reset your password", msg.Subject)
+	assert.Contains(t, msg.Body, "Hello alice,")
+	assert.Contains(t, msg.Body, "https://app.example.com/reset?token=abc")
+	assert.Contains(t, msg.Body, "expires in 1 hour")
+}
+
+func TestRender_EmailVerification(t *testing.T) {
+	msg, err := Render(TemplateEmailVerification, "user@example.com", EmailVerificationData{
+		Username:  "alice",
+		VerifyURL: "https://app.example.com/verify?token=abc",
+		ExpiresIn: "24 hours",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "Verify your email address", msg.Subject)
+	assert.Contains(t, msg.Body, "https://app.example.com/verify?token=abc")
+}
+
+func TestFileMailer_Send(t *testing.T) {
+	// Setup
+	dir := t.TempDir()
+	mailer, err := NewFileMailer(dir, "noreply@example.com")
+	assert.NoError(t, err)
+
+	// Test
+	for range 2 {
+		assert.NoError(t, mailer.Send(context.Background(), Message{To: "user@example.com", Subject: "Hi", Body: "Hello"}))
+	}
+
+	// Assert
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
+
+func TestFromConfig(t *testing.T) {
+	mailer, err := FromConfig(&config.Config{})
+	assert.NoError(t, err)
+	assert.Nil(t, mailer, "mail is disabled without a driver")
+
+	mailer, err = FromConfig(&config.Config{MailDriver: DriverMemory})
+	assert.NoError(t, err)
+	assert.IsType(t, &MemoryMailer{}, mailer)
+
+	_, err = FromConfig(&config.Config{MailDriver: DriverSMTP})
+	assert.Error(t, err, "SMTP requires a host")
+
+	_, err = FromConfig(&config.Config{MailDriver: "pigeon"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,69 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+// SMTPConfig holds the settings of an SMTP relay
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string // Empty to send without authentication
+	Password string
+	From     string
+}
+
+// SMTPMailer sends email through an SMTP relay. The connection is upgraded
+// with STARTTLS when the server offers it.
+type SMTPMailer struct {
+	addr   string
+	auth   smtp.Auth
+	from   string // From header
+	sender string // Envelope sender
+	now    func() time.Time
+}
+
+// NewSMTPMailer creates a mailer sending through the relay described by cfg
+func NewSMTPMailer(cfg SMTPConfig) (*SMTPMailer, error) {
+	if cfg.Host == "" {
+		return nil, errors.New("SMTP host is required")
+	}
+	from, err := mail.ParseAddress(cfg.From)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &SMTPMailer{
+		addr:   net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)),
+		from:   cfg.From,
+		sender: from.Address,
+		now:    time.Now,
+	}
+	if cfg.Username != "" {
+		m.auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+	}
+	return m, nil
+}
+
+// Send implements Mailer. net/smtp does not support contexts, so ctx is only
+// checked before connecting.
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return err
+	}
+	data, err := msg.Bytes(m.from, m.now())
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(m.addr, m.auth, m.sender, []string{to.Address}, data)
+}
@@ -0,0 +1,53 @@
+package mail
+
+import (
+	"bytes"
+	"embed"
+	"strings"
+	"text/template"
+)
+
+//go:embed templates/*.tmpl
+var templateFS embed.FS
+
+// Template names
+const (
+	TemplatePasswordReset     = "password_reset"
+	TemplateEmailVerification = "email_verification"
+)
+
+// PasswordResetData is the data of the password reset template
+type PasswordResetData struct {
+	Username  string
+	ResetURL  string
+	ExpiresIn string
+}
+
+// EmailVerificationData is the data of the email verification template
+type EmailVerificationData struct {
+	Username  string
+	VerifyURL string
+	ExpiresIn string
+}
+
+// Render renders the named template into a message to to. Each template
+// defines a "subject" and a "body".
+func Render(name, to string, data any) (Message, error) {
+	tmpl, err := template.ParseFS(templateFS, "templates/"+name+".tmpl")
+	if err != nil {
+		return Message{}, err
+	}
+
+	var subject, body bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return Message{}, err
+	}
+	if err := tmpl.ExecuteTemplate(&body, "body", data); err != nil {
+		return Message{}, err
+	}
+	return Message{
+		To:      to,
+		Subject: strings.TrimSpace(subject.String()),
+		Body:    body.String(),
+	}, nil
+}
@@ -1,11 +1,19 @@
 package main
 
 import (
+	"context"
+	"slices"
+
 	"go.uber.org/zap"
 
+	"example.com/ginhello/audit"
 	"example.com/ginhello/config"
 	"example.com/ginhello/database"
+	"example.com/ginhello/logging"
+	"example.com/ginhello/mail"
+	"example.com/ginhello/password"
 	"example.com/ginhello/router"
+	"example.com/ginhello/tracing"
 )
 
 func main() {
@@ -19,15 +27,87 @@ func main() {
 		logger.Fatal("Failed to load configuration", zap.Error(err))
 	}
 
+	// Redact sensitive data from everything logged from here on
+	logger = logger.WithOptions(logging.NewRedactor(logging.RedactionPolicy{
+		Keys:        slices.Concat(logging.DefaultRedactedKeys, cfg.LogRedactKeys),
+		Headers:     slices.Concat(logging.DefaultRedactedHeaders, cfg.LogRedactHeaders),
+		MaskEmails:  cfg.LogMaskEmails,
+		AnonymizeIP: cfg.LogAnonymizeIP,
+	}).WrapCore())
+
+	// Set up tracing (a no-op unless TRACING_ENABLED is set)
+	tracerProvider, shutdownTracing, err := tracing.Setup(context.Background(), cfg, logger)
+	if err != nil {
+		logger.Fatal("Failed to set up tracing", zap.Error(err))
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			logger.Error("Failed to shut down tracing", zap.Error(err))
+		}
+	}()
+
 	// Connect to database
 	db, err := database.Connect(cfg, logger)
 	if err != nil {
 		// Error is already logged in Connect
 		return
 	}
+	if err := db.Use(tracing.GormPlugin(tracerProvider)); err != nil {
+		logger.Fatal("Failed to register database tracing", zap.Error(err))
+	}
+
+	// Purge soft deleted users once their retention period has passed
+	if cfg.UserRetention > 0 && cfg.UserPurgeInterval > 0 {
+		purgeCtx, stopPurge := context.WithCancel(context.Background())
+		defer stopPurge()
+		go database.RunUserPurge(purgeCtx, db, logger, cfg.UserRetention, cfg.UserPurgeInterval)
+	}
+
+	// Set up the audit log, optionally mirrored to a file
+	var auditOpts []audit.Option
+	if cfg.AuditLogFile != "" {
+		sink, err := audit.NewFileSink(cfg.AuditLogFile)
+		if err != nil {
+			logger.Fatal("Failed to open audit log file", zap.String("path", cfg.AuditLogFile), zap.Error(err))
+		}
+		defer sink.Close()
+		auditOpts = append(auditOpts, audit.WithSink(sink))
+	}
+	auditRecorder := audit.NewRecorder(db, logger, auditOpts...)
+
+	// Set up outgoing mail for password reset links
+	mailer, err := mail.FromConfig(cfg)
+	if err != nil {
+		logger.Fatal("Failed to set up mail", zap.String("driver", cfg.MailDriver), zap.Error(err))
+	}
+	if mailer == nil {
+		logger.Info("Mail is disabled; set MAIL_DRIVER to enable password reset and email verification")
+	}
+
+	// Set up the password policy, optionally checking a breached password file
+	passwordPolicy := password.PolicyFromConfig(cfg)
+	if cfg.PasswordBreachFile != "" {
+		breaches, err := password.OpenBreachFile(cfg.PasswordBreachFile)
+		if err != nil {
+			logger.Fatal("Failed to open breached password file", zap.String("path", cfg.PasswordBreachFile), zap.Error(err))
+		}
+		defer breaches.Close()
+		passwordPolicy.Breaches = password.RangeChecker{Source: breaches}
+	}
+
+	passwordHasher, err := password.HasherFromConfig(cfg)
+	if err != nil {
+		logger.Fatal("Invalid password hash configuration", zap.Error(err))
+	}
 
 	// Inject DB connection into router setup
-	r := router.SetupRouter(cfg, db, logger)
+	r := router.SetupRouter(cfg, db, logger,
+		router.WithTracerProvider(tracerProvider),
+		router.WithAuditRecorder(auditRecorder),
+		router.WithMailer(mailer),
+		router.WithPasswordPolicy(passwordPolicy),
+		router.WithPasswordHasher(passwordHasher),
+	)
 
 	// Start server
 	logger.Info("Starting server on :8080")
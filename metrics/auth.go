@@ -0,0 +1,125 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Login failure reasons
+const (
+	LoginReasonInvalidRequest = "invalid_request"
+	LoginReasonUnknownUser    = "unknown_user"
+	LoginReasonWrongPassword  = "wrong_password"
+	LoginReasonThrottled      = "throttled"
+	LoginReasonUnverified     = "email_unverified"
+	LoginReasonError          = "error"
+)
+
+// Token validation failure causes
+const (
+	CauseExpired      = "expired"
+	CauseBadSignature = "bad_signature"
+	CauseMalformed    = "malformed"
+	CauseWrongType    = "wrong_type"
+	CauseRevoked      = "revoked"
+	CauseInvalid      = "invalid" // Any other validation failure, e.g. a bad issuer
+)
+
+// Outcomes of an attempt
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// AuthMetrics records authentication domain metrics. All methods are safe to
+// call on a nil *AuthMetrics, which records nothing.
+type AuthMetrics struct {
+	logins             *prometheus.CounterVec
+	tokensIssued       *prometheus.CounterVec
+	refreshes          *prometheus.CounterVec
+	validationFailures *prometheus.CounterVec
+	activeSessions     prometheus.Gauge
+}
+
+// NewAuthMetrics creates authentication metrics and registers them with reg
+func NewAuthMetrics(reg prometheus.Registerer) *AuthMetrics {
+	m := &AuthMetrics{
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "auth",
+			Name:      "logins_total",
+			Help:      "Login attempts by result and failure reason.",
+		}, []string{"result", "reason"}),
+		tokensIssued: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "auth",
+			Name:      "tokens_issued_total",
+			Help:      "Tokens issued by token type.",
+		}, []string{"type"}),
+		refreshes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "auth",
+			Name:      "refresh_attempts_total",
+			Help:      "Token refresh attempts by result.",
+		}, []string{"result"}),
+		validationFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "auth",
+			Name:      "token_validation_failures_total",
+			Help:      "Token validation failures by cause.",
+		}, []string{"cause"}),
+		activeSessions: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Subsystem: "auth",
+			Name:      "active_sessions",
+			Help:      "Sessions with an unexpired refresh token issued by this instance.",
+		}),
+	}
+	reg.MustRegister(m.logins, m.tokensIssued, m.refreshes, m.validationFailures, m.activeSessions)
+	return m
+}
+
+// LoginSucceeded records a successful login
+func (m *AuthMetrics) LoginSucceeded() {
+	if m == nil {
+		return
+	}
+	m.logins.WithLabelValues(ResultSuccess, "").Inc()
+}
+
+// LoginFailed records a failed login with one of the LoginReason constants
+func (m *AuthMetrics) LoginFailed(reason string) {
+	if m == nil {
+		return
+	}
+	m.logins.WithLabelValues(ResultFailure, reason).Inc()
+}
+
+// TokenIssued records an issued token of the given type
+func (m *AuthMetrics) TokenIssued(tokenType string) {
+	if m == nil {
+		return
+	}
+	m.tokensIssued.WithLabelValues(tokenType).Inc()
+}
+
+// RefreshAttempted records a token refresh attempt with ResultSuccess or ResultFailure
+func (m *AuthMetrics) RefreshAttempted(result string) {
+	if m == nil {
+		return
+	}
+	m.refreshes.WithLabelValues(result).Inc()
+}
+
+// TokenValidationFailed records a rejected token with one of the Cause constants
+func (m *AuthMetrics) TokenValidationFailed(cause string) {
+	if m == nil {
+		return
+	}
+	m.validationFailures.WithLabelValues(cause).Inc()
+}
+
+// SetActiveSessions sets the number of active sessions
+func (m *AuthMetrics) SetActiveSessions(n int) {
+	if m == nil {
+		return
+	}
+	m.activeSessions.Set(float64(n))
+}
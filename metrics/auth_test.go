@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthMetrics(t *testing.T) {
+	// Setup
+	m := NewAuthMetrics(prometheus.NewRegistry())
+
+	// Test
+	m.LoginSucceeded()
+	m.LoginFailed(LoginReasonWrongPassword)
+	m.LoginFailed(LoginReasonWrongPassword)
+	m.TokenIssued("access")
+	m.RefreshAttempted(ResultFailure)
+	m.TokenValidationFailed(CauseExpired)
+	m.SetActiveSessions(3)
+
+	// Assert
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.logins.WithLabelValues(ResultSuccess, "")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.logins.WithLabelValues(ResultFailure, LoginReasonWrongPassword)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.tokensIssued.WithLabelValues("access")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.refreshes.WithLabelValues(ResultFailure)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.validationFailures.WithLabelValues(CauseExpired)))
+	assert.Equal(t, 3.0, testutil.ToFloat64(m.activeSessions))
+}
+
+func TestAuthMetrics_Nil(t *testing.T) {
+	var m *AuthMetrics
+
+	// A nil recorder must be usable without checks at call sites
+	assert.NotPanics(t, func() {
+		m.LoginSucceeded()
+		m.LoginFailed(LoginReasonUnknownUser)
+		m.TokenIssued("refresh")
+		m.RefreshAttempted(ResultSuccess)
+		m.TokenValidationFailed(CauseMalformed)
+		m.SetActiveSessions(1)
+	})
+}
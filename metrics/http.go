@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Namespace prefixes all application metric names
+const Namespace = "ginhello"
+
+// UnmatchedRoute labels requests that did not match any route, so that
+// scanners probing random paths cannot blow up label cardinality
+const UnmatchedRoute = "unmatched"
+
+// HTTPMetrics records HTTP request metrics
+type HTTPMetrics struct {
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	inFlight prometheus.Gauge
+	panics   *prometheus.CounterVec
+}
+
+// NewHTTPMetrics creates HTTP metrics and registers them with reg
+func NewHTTPMetrics(reg prometheus.Registerer) *HTTPMetrics {
+	m := &HTTPMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "Total number of HTTP requests by route template, method and status class.",
+		}, []string{"route", "method", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "HTTP request latency by route template, method and status class.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Subsystem: "http",
+			Name:      "requests_in_flight",
+			Help:      "Number of HTTP requests currently being served.",
+		}),
+		panics: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "http",
+			Name:      "panics_total",
+			Help:      "Total number of panics recovered while serving HTTP requests, by route template.",
+		}, []string{"route"}),
+	}
+	reg.MustRegister(m.requests, m.duration, m.inFlight, m.panics)
+	return m
+}
+
+// Start marks a request as in flight. The returned function must be called
+// once the request has been served.
+func (m *HTTPMetrics) Start() func() {
+	m.inFlight.Inc()
+	return m.inFlight.Dec
+}
+
+// Observe records a served request
+func (m *HTTPMetrics) Observe(route, method string, status int, latency time.Duration) {
+	if route == "" {
+		route = UnmatchedRoute
+	}
+	labels := prometheus.Labels{
+		"route":  route,
+		"method": normalizeMethod(method),
+		"status": StatusClass(status),
+	}
+	m.requests.With(labels).Inc()
+	m.duration.With(labels).Observe(latency.Seconds())
+}
+
+// Panicked records a panic recovered while serving route
+func (m *HTTPMetrics) Panicked(route string) {
+	if route == "" {
+		route = UnmatchedRoute
+	}
+	m.panics.WithLabelValues(route).Inc()
+}
+
+// StatusClass returns the class of an HTTP status code, e.g. "2xx"
+func StatusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "unknown"
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
+
+// normalizeMethod maps non-standard methods to a single label value
+func normalizeMethod(method string) string {
+	switch method {
+	case "GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS", "CONNECT", "TRACE":
+		return method
+	default:
+		return "OTHER"
+	}
+}
@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPMetrics_Observe(t *testing.T) {
+	// Setup
+	reg := prometheus.NewRegistry()
+	m := NewHTTPMetrics(reg)
+
+	// Test
+	m.Observe("/api/users/:id", "GET", 200, 10*time.Millisecond)
+	m.Observe("/api/users/:id", "GET", 204, 10*time.Millisecond)
+	m.Observe("", "PROPFIND", 404, time.Millisecond)
+
+	// Assert
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.requests.WithLabelValues("/api/users/:id", "GET", "2xx")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.requests.WithLabelValues(UnmatchedRoute, "OTHER", "4xx")))
+	assert.Equal(t, 2, testutil.CollectAndCount(m.duration))
+}
+
+func TestHTTPMetrics_InFlight(t *testing.T) {
+	m := NewHTTPMetrics(prometheus.NewRegistry())
+
+	done := m.Start()
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.inFlight))
+
+	done()
+	assert.Equal(t, 0.0, testutil.ToFloat64(m.inFlight))
+}
+
+func TestHTTPMetrics_Panicked(t *testing.T) {
+	m := NewHTTPMetrics(prometheus.NewRegistry())
+
+	m.Panicked("/api/users/:id")
+	m.Panicked("")
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.panics.WithLabelValues("/api/users/:id")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.panics.WithLabelValues(UnmatchedRoute)))
+}
+
+func TestStatusClass(t *testing.T) {
+	assert.Equal(t, "2xx", StatusClass(200))
+	assert.Equal(t, "4xx", StatusClass(429))
+	assert.Equal(t, "5xx", StatusClass(503))
+	assert.Equal(t, "unknown", StatusClass(0))
+}
@@ -1,54 +1,175 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
-	"strings"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 
 	"example.com/ginhello/auth"
+	"example.com/ginhello/logging"
+	"example.com/ginhello/metrics"
+	"example.com/ginhello/problem"
 )
 
-// JWTAuthMiddleware creates a gin middleware for JWT authentication
-func JWTAuthMiddleware(jwtService *auth.JWTService, logger *zap.Logger) gin.HandlerFunc {
+// JWTAuthMiddleware creates a gin middleware for JWT authentication.
+// Extractors are tried in order until one yields a token; when none are given
+// the token is read from the "Authorization: Bearer <token>" header.
+func JWTAuthMiddleware(jwtService *auth.JWTService, logger *zap.Logger, extractors ...TokenExtractor) gin.HandlerFunc {
+	return jwtAuth(jwtService, logger, false, extractors)
+}
+
+// OptionalJWTAuth creates a gin middleware for endpoints that serve both
+// anonymous and authenticated callers. A request without a token passes
+// through unauthenticated, while a present but invalid token is still rejected.
+// Handlers use IsAuthenticated to tell the two apart.
+func OptionalJWTAuth(jwtService *auth.JWTService, logger *zap.Logger, extractors ...TokenExtractor) gin.HandlerFunc {
+	return jwtAuth(jwtService, logger, true, extractors)
+}
+
+// IsAuthenticated reports whether the request carried a valid token
+func IsAuthenticated(c *gin.Context) bool {
+	_, ok := auth.PrincipalFrom(c)
+	return ok
+}
+
+// RequirePermission creates a gin middleware that only lets through principals
+// holding the given permission. It must run after JWTAuthMiddleware.
+func RequirePermission(permission string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Get the Authorization header
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authorization header is required"})
+		realm := c.GetString(realmContextKey)
+		principal, ok := auth.PrincipalFrom(c)
+		if !ok {
+			abortWithBearerError(c, http.StatusUnauthorized, bearerChallenge{
+				Realm:       realm,
+				Description: "Authentication required",
+			})
+			return
+		}
+		if !principal.HasPermission(permission) {
+			abortWithBearerError(c, http.StatusForbidden, bearerChallenge{
+				Realm:       realm,
+				Error:       BearerErrorInsufficientScope,
+				Description: "Insufficient permissions",
+				Scope:       permission,
+			})
 			return
 		}
+		c.Next()
+	}
+}
+
+// ScopeEmailVerified is the scope named in the challenge when a request is
+// refused because the user's email address has not been verified
+const ScopeEmailVerified = "email_verified"
 
-		// Check if the format is Bearer <token>
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authorization header format must be Bearer <token>"})
+// RequireVerifiedEmail creates a gin middleware that only lets through
+// principals whose email address was verified when their token was issued.
+// It must run after JWTAuthMiddleware.
+func RequireVerifiedEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		realm := c.GetString(realmContextKey)
+		principal, ok := auth.PrincipalFrom(c)
+		if !ok {
+			abortWithBearerError(c, http.StatusUnauthorized, bearerChallenge{
+				Realm:       realm,
+				Description: "Authentication required",
+			})
+			return
+		}
+		if !principal.EmailVerified {
+			abortWithBearerError(c, http.StatusForbidden, bearerChallenge{
+				Realm:       realm,
+				Error:       BearerErrorInsufficientScope,
+				Description: "Email address has not been verified",
+				Scope:       ScopeEmailVerified,
+			})
 			return
 		}
+		c.Next()
+	}
+}
+
+func jwtAuth(jwtService *auth.JWTService, logger *zap.Logger, optional bool, extractors []TokenExtractor) gin.HandlerFunc {
+	missingTokenMessage := "Authentication token is required"
+	if len(extractors) == 0 {
+		extractors = DefaultExtractors()
+		missingTokenMessage = "Authorization header is required"
+	}
 
-		// Validate the token
-		tokenString := parts[1]
-		claims, err := jwtService.ValidateToken(tokenString)
+	// The issuer names the protection space in WWW-Authenticate challenges
+	realm := jwtService.Issuer()
+
+	return func(c *gin.Context) {
+		c.Set(realmContextKey, realm)
+
+		// Get the token from the configured locations
+		tokenString, err := extractToken(c, extractors)
 		if err != nil {
-			if err == auth.ErrExpiredToken {
-				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Token has expired"})
+			if errors.Is(err, ErrTokenNotFound) {
+				if optional {
+					c.Next()
+					return
+				}
+				// A request without credentials gets a challenge without an
+				// error code (RFC 6750 section 3.1)
+				abortWithBearerError(c, http.StatusUnauthorized, bearerChallenge{
+					Realm:       realm,
+					Description: missingTokenMessage,
+				})
 				return
 			}
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			jwtService.Metrics().TokenValidationFailed(metrics.CauseMalformed)
+			abortWithBearerError(c, http.StatusUnauthorized, bearerChallenge{
+				Realm:       realm,
+				Error:       BearerErrorInvalidRequest,
+				Description: "Authorization header format must be Bearer <token>",
+			})
 			return
 		}
 
-		// Set user info in context for later use in handlers
+		// Validate the token, which must not be a refresh token
+		claims, err := jwtService.ValidateAccessTokenContext(c.Request.Context(), tokenString)
+		if err != nil && !auth.IsTokenRejected(err) {
+			// Still fail closed, but without an invalid_token challenge that
+			// would make clients throw away a token that may well be valid
+			logging.FromContext(c, logger).Error("Failed to validate token", zap.Error(err))
+			problem.Error(c, http.StatusServiceUnavailable, problem.CodeUnavailable, "Token could not be checked, try again later")
+			return
+		}
+		if err != nil {
+			description := "Invalid token"
+			switch err {
+			case auth.ErrExpiredToken:
+				description = "Token has expired"
+			case auth.ErrRevokedToken:
+				description = "Token has been revoked"
+			}
+			abortWithBearerError(c, http.StatusUnauthorized, bearerChallenge{
+				Realm:       realm,
+				Error:       BearerErrorInvalidToken,
+				Description: description,
+			})
+			return
+		}
+
+		// Store the principal in both the gin and request contexts so that
+		// code below the handler can read the caller identity
+		principal := auth.NewPrincipal(claims)
+		c.Set(auth.PrincipalContextKey, principal)
+		c.Request = c.Request.WithContext(auth.WithPrincipal(c.Request.Context(), principal))
+
+		// Raw keys are kept for handlers that have not moved to PrincipalFrom
 		c.Set("user_id", claims.UserID)
 		c.Set("username", claims.Username)
 		c.Set("token_id", claims.TokenID)
 
-		// Add info to logger
-		logger.With(
+		// Add user info to the request logger
+		setRequestLogger(c, logging.FromContext(c, logger).With(
 			zap.Uint("user_id", claims.UserID),
 			zap.String("username", claims.Username),
-		)
+		))
 
 		c.Next()
 	}
@@ -1,7 +1,9 @@
 package middleware
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -14,6 +16,7 @@ import (
 	"example.com/ginhello/auth"
 	"example.com/ginhello/config"
 	"example.com/ginhello/models"
+	"example.com/ginhello/problem"
 )
 
 func TestJWTAuthMiddleware(t *testing.T) {
@@ -37,12 +40,18 @@ func TestJWTAuthMiddleware(t *testing.T) {
 	user.ID = 123 // Manually set ID for testing
 	tokenPair, _ := jwtService.GenerateTokenPair(user)
 
+	expiredCfg := *cfg
+	expiredCfg.JWTAccessExpiry = -time.Minute
+	expiredPair, _ := auth.NewJWTService(&expiredCfg, logger).GenerateTokenPair(user)
+
 	// Test cases
 	tests := []struct {
-		name           string
-		authHeader     string
-		expectedStatus int
-		expectedError  string
+		name              string
+		authHeader        string
+		expectedStatus    int
+		expectedError     string
+		expectedErrorCode string
+		expectedChallenge string
 	}{
 		{
 			name:           "Valid token",
@@ -51,22 +60,44 @@ func TestJWTAuthMiddleware(t *testing.T) {
 			expectedError:  "",
 		},
 		{
-			name:           "Missing authorization header",
-			authHeader:     "",
-			expectedStatus: http.StatusUnauthorized,
-			expectedError:  "Authorization header is required",
+			name:              "Missing authorization header",
+			authHeader:        "",
+			expectedStatus:    http.StatusUnauthorized,
+			expectedError:     "Authorization header is required",
+			expectedErrorCode: problem.CodeAuthenticationRequired,
+			expectedChallenge: `Bearer realm="test_issuer", error_description="Authorization header is required"`,
 		},
 		{
-			name:           "Invalid authorization format",
-			authHeader:     "InvalidFormat " + tokenPair.AccessToken,
-			expectedStatus: http.StatusUnauthorized,
-			expectedError:  "Authorization header format must be Bearer <token>",
+			name:              "Invalid authorization format",
+			authHeader:        "InvalidFormat " + tokenPair.AccessToken,
+			expectedStatus:    http.StatusUnauthorized,
+			expectedError:     "Authorization header format must be Bearer <token>",
+			expectedErrorCode: BearerErrorInvalidRequest,
+			expectedChallenge: `Bearer realm="test_issuer", error="invalid_request", error_description="Authorization header format must be Bearer <token>"`,
 		},
 		{
-			name:           "Invalid token",
-			authHeader:     "Bearer invalid.token.string",
-			expectedStatus: http.StatusUnauthorized,
-			expectedError:  "Invalid token",
+			name:              "Invalid token",
+			authHeader:        "Bearer invalid.token.string",
+			expectedStatus:    http.StatusUnauthorized,
+			expectedError:     "Invalid token",
+			expectedErrorCode: BearerErrorInvalidToken,
+			expectedChallenge: `Bearer realm="test_issuer", error="invalid_token", error_description="Invalid token"`,
+		},
+		{
+			name:              "Expired token",
+			authHeader:        "Bearer " + expiredPair.AccessToken,
+			expectedStatus:    http.StatusUnauthorized,
+			expectedError:     "Token has expired",
+			expectedErrorCode: BearerErrorInvalidToken,
+			expectedChallenge: `Bearer realm="test_issuer", error="invalid_token", error_description="Token has expired"`,
+		},
+		{
+			name:              "Refresh token used as access token",
+			authHeader:        "Bearer " + tokenPair.RefreshToken,
+			expectedStatus:    http.StatusUnauthorized,
+			expectedError:     "Invalid token",
+			expectedErrorCode: BearerErrorInvalidToken,
+			expectedChallenge: `Bearer realm="test_issuer", error="invalid_token", error_description="Invalid token"`,
 		},
 	}
 
@@ -108,9 +139,12 @@ func TestJWTAuthMiddleware(t *testing.T) {
 				err := json.NewDecoder(w.Body).Decode(&response)
 				assert.NoError(t, err)
 				assert.Equal(t, tc.expectedError, response["error"])
+				assert.Equal(t, tc.expectedErrorCode, response["error_code"])
+				assert.Equal(t, tc.expectedChallenge, w.Header().Get("WWW-Authenticate"))
 				assert.False(t, handlerCalled)
 			} else {
 				assert.True(t, handlerCalled)
+				assert.Empty(t, w.Header().Get("WWW-Authenticate"))
 
 				// Check that user info was set in context
 				userID, exists := c.Get("user_id")
@@ -123,7 +157,304 @@ func TestJWTAuthMiddleware(t *testing.T) {
 
 				_, exists = c.Get("token_id")
 				assert.True(t, exists)
+
+				// Check that the principal is available from both contexts
+				principal, ok := auth.PrincipalFrom(c)
+				assert.True(t, ok)
+				assert.Equal(t, user.ID, principal.UserID)
+				assert.Equal(t, user.Username, principal.Username)
+
+				principal, ok = auth.PrincipalFrom(c.Request.Context())
+				assert.True(t, ok)
+				assert.Equal(t, user.ID, principal.UserID)
+			}
+		})
+	}
+}
+
+// failingRevocationChecker simulates a revocation checker whose database is
+// unavailable
+type failingRevocationChecker struct{}
+
+func (failingRevocationChecker) Revoked(context.Context, *auth.TokenClaims) (bool, error) {
+	return false, errors.New("database unavailable")
+}
+
+func TestJWTAuthMiddleware_RevocationCheckFails(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	logger := zap.NewNop()
+	cfg := &config.Config{
+		JWTSecret:        "test_secret",
+		JWTAccessExpiry:  15 * time.Minute,
+		JWTRefreshExpiry: 24 * time.Hour,
+		JWTIssuer:        "test_issuer",
+	}
+	jwtService := auth.NewJWTService(cfg, logger, auth.WithRevocationChecker(failingRevocationChecker{}))
+	user := &models.User{Username: "testuser"}
+	user.ID = 123
+	tokenPair, _ := jwtService.GenerateTokenPair(user)
+
+	r := gin.New()
+	r.GET("/test", JWTAuthMiddleware(jwtService, logger), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	// Test
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("Authorization", "Bearer "+tokenPair.AccessToken)
+	req.Header.Set("Accept", problem.ContentType)
+	r.ServeHTTP(w, req)
+
+	// Assert: the request is refused, but the token is not called invalid
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Empty(t, w.Header().Get("WWW-Authenticate"))
+	var body problem.Problem
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, problem.CodeUnavailable, body.Code)
+}
+
+func TestJWTAuthMiddleware_CustomExtractors(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	logger := zap.NewNop()
+	cfg := &config.Config{
+		JWTSecret:        "test_secret",
+		JWTAccessExpiry:  15 * time.Minute,
+		JWTRefreshExpiry: 24 * time.Hour,
+		JWTIssuer:        "test_issuer",
+	}
+	jwtService := auth.NewJWTService(cfg, logger)
+
+	user := &models.User{Username: "testuser"}
+	user.ID = 123
+	tokenPair, _ := jwtService.GenerateTokenPair(user)
+
+	r := gin.New()
+	r.Use(JWTAuthMiddleware(jwtService, logger, FromAuthHeader("Bearer"), FromQuery("access_token")))
+	r.GET("/download", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	tests := []struct {
+		name           string
+		path           string
+		authHeader     string
+		expectedStatus int
+		expectedError  string
+	}{
+		{
+			name:           "Token in query parameter",
+			path:           "/download?access_token=" + tokenPair.AccessToken,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Token in header",
+			path:           "/download",
+			authHeader:     "Bearer " + tokenPair.AccessToken,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "No token anywhere",
+			path:           "/download",
+			expectedStatus: http.StatusUnauthorized,
+			expectedError:  "Authentication token is required",
+		},
+		{
+			name:           "Invalid token in query parameter",
+			path:           "/download?access_token=invalid.token.string",
+			expectedStatus: http.StatusUnauthorized,
+			expectedError:  "Invalid token",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", tc.path, nil)
+			if tc.authHeader != "" {
+				req.Header.Set("Authorization", tc.authHeader)
+			}
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedStatus, w.Code)
+			if tc.expectedError != "" {
+				var response map[string]string
+				err := json.NewDecoder(w.Body).Decode(&response)
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedError, response["error"])
+			}
+		})
+	}
+}
+
+func TestOptionalJWTAuth(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	logger := zap.NewNop()
+	cfg := &config.Config{
+		JWTSecret:        "test_secret",
+		JWTAccessExpiry:  15 * time.Minute,
+		JWTRefreshExpiry: 24 * time.Hour,
+		JWTIssuer:        "test_issuer",
+	}
+	jwtService := auth.NewJWTService(cfg, logger)
+
+	user := &models.User{Username: "testuser"}
+	user.ID = 123
+	tokenPair, _ := jwtService.GenerateTokenPair(user)
+
+	r := gin.New()
+	r.Use(OptionalJWTAuth(jwtService, logger))
+	r.GET("/profiles", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"authenticated": IsAuthenticated(c)})
+	})
+
+	tests := []struct {
+		name                  string
+		authHeader            string
+		expectedStatus        int
+		expectedAuthenticated bool
+	}{
+		{
+			name:                  "Anonymous request",
+			authHeader:            "",
+			expectedStatus:        http.StatusOK,
+			expectedAuthenticated: false,
+		},
+		{
+			name:                  "Valid token",
+			authHeader:            "Bearer " + tokenPair.AccessToken,
+			expectedStatus:        http.StatusOK,
+			expectedAuthenticated: true,
+		},
+		{
+			name:           "Invalid token",
+			authHeader:     "Bearer invalid.token.string",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Malformed header",
+			authHeader:     "Token " + tokenPair.AccessToken,
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/profiles", nil)
+			if tc.authHeader != "" {
+				req.Header.Set("Authorization", tc.authHeader)
+			}
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedStatus, w.Code)
+			if tc.expectedStatus == http.StatusOK {
+				var response map[string]bool
+				err := json.NewDecoder(w.Body).Decode(&response)
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedAuthenticated, response["authenticated"])
 			}
 		})
 	}
 }
+
+func TestRequirePermission(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name              string
+		principal         *auth.Principal
+		expectedStatus    int
+		expectedChallenge string
+	}{
+		{
+			name:              "No principal",
+			principal:         nil,
+			expectedStatus:    http.StatusUnauthorized,
+			expectedChallenge: `Bearer realm="ginhello", error_description="Authentication required"`,
+		},
+		{
+			name:              "Missing permission",
+			principal:         &auth.Principal{UserID: 1},
+			expectedStatus:    http.StatusForbidden,
+			expectedChallenge: `Bearer realm="ginhello", error="insufficient_scope", error_description="Insufficient permissions", scope="admin"`,
+		},
+		{
+			name:           "Has permission",
+			principal:      &auth.Principal{UserID: 1, Permissions: []string{auth.PermissionAdmin}},
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("POST", "/admin", nil)
+			c.Set(realmContextKey, "ginhello")
+			if tc.principal != nil {
+				c.Set(auth.PrincipalContextKey, tc.principal)
+			}
+
+			RequirePermission(auth.PermissionAdmin)(c)
+			if !c.IsAborted() {
+				c.Status(http.StatusOK)
+			}
+
+			assert.Equal(t, tc.expectedStatus, w.Code)
+			assert.Equal(t, tc.expectedChallenge, w.Header().Get("WWW-Authenticate"))
+		})
+	}
+}
+
+func TestRequireVerifiedEmail(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name              string
+		principal         *auth.Principal
+		expectedStatus    int
+		expectedChallenge string
+	}{
+		{
+			name:              "No principal",
+			principal:         nil,
+			expectedStatus:    http.StatusUnauthorized,
+			expectedChallenge: `Bearer realm="ginhello", error_description="Authentication required"`,
+		},
+		{
+			name:              "Unverified",
+			principal:         &auth.Principal{UserID: 1},
+			expectedStatus:    http.StatusForbidden,
+			expectedChallenge: `Bearer realm="ginhello", error="insufficient_scope", error_description="Email address has not been verified", scope="email_verified"`,
+		},
+		{
+			name:           "Verified",
+			principal:      &auth.Principal{UserID: 1, EmailVerified: true},
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("GET", "/users", nil)
+			c.Set(realmContextKey, "ginhello")
+			if tc.principal != nil {
+				c.Set(auth.PrincipalContextKey, tc.principal)
+			}
+
+			RequireVerifiedEmail()(c)
+			if !c.IsAborted() {
+				c.Status(http.StatusOK)
+			}
+
+			assert.Equal(t, tc.expectedStatus, w.Code)
+			assert.Equal(t, tc.expectedChallenge, w.Header().Get("WWW-Authenticate"))
+		})
+	}
+}
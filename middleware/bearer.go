@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"example.com/ginhello/problem"
+)
+
+// Bearer token error codes defined by RFC 6750 section 3.1. They are sent in
+// the WWW-Authenticate header and double as the problem error codes.
+const (
+	BearerErrorInvalidRequest    = problem.CodeInvalidRequest
+	BearerErrorInvalidToken      = problem.CodeInvalidToken
+	BearerErrorInsufficientScope = problem.CodeInsufficientScope
+)
+
+// realmContextKey stores the realm of the authenticating middleware so that
+// RequirePermission can send the same challenge
+const realmContextKey = "auth.realm"
+
+// bearerChallenge is the value of a Bearer WWW-Authenticate header
+type bearerChallenge struct {
+	Realm       string
+	Error       string // Empty when the request carried no credentials at all
+	Description string
+	Scope       string
+}
+
+// String formats the challenge as an auth-param list
+func (b bearerChallenge) String() string {
+	var params []string
+	add := func(name, value string) {
+		if value != "" {
+			params = append(params, name+"="+quoteAuthParam(value))
+		}
+	}
+	add("realm", b.Realm)
+	add("error", b.Error)
+	add("error_description", b.Description)
+	add("scope", b.Scope)
+
+	if len(params) == 0 {
+		return "Bearer"
+	}
+	return "Bearer " + strings.Join(params, ", ")
+}
+
+// quoteAuthParam returns value as a quoted string. RFC 6750 does not allow
+// quotes or backslashes in its attributes, so they are dropped.
+func quoteAuthParam(value string) string {
+	value = strings.NewReplacer(`"`, "", `\`, "").Replace(value)
+	return `"` + value + `"`
+}
+
+// abortWithBearerError aborts the request with a Bearer challenge. The
+// description doubles as the problem detail.
+func abortWithBearerError(c *gin.Context, status int, challenge bearerChallenge) {
+	c.Header("WWW-Authenticate", challenge.String())
+
+	// The challenge has no error code when credentials are missing altogether
+	code := challenge.Error
+	if code == "" {
+		code = problem.CodeAuthenticationRequired
+	}
+	problem.Error(c, status, code, challenge.Description)
+}
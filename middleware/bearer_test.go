@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBearerChallenge_String(t *testing.T) {
+	tests := []struct {
+		name      string
+		challenge bearerChallenge
+		expected  string
+	}{
+		{"Empty", bearerChallenge{}, "Bearer"},
+		{"Realm only", bearerChallenge{Realm: "example"}, `Bearer realm="example"`},
+		{
+			"All attributes",
+			bearerChallenge{Realm: "example", Error: BearerErrorInsufficientScope, Description: "Need more", Scope: "admin"},
+			`Bearer realm="example", error="insufficient_scope", error_description="Need more", scope="admin"`,
+		},
+		{"Quotes are dropped", bearerChallenge{Description: `say "hi" \o/`}, `Bearer error_description="say hi o/"`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.challenge.String())
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// ErrTokenNotFound is returned by an extractor when the request carries no
+	// token in the location it inspects, so the next extractor can be tried.
+	ErrTokenNotFound = errors.New("token not found")
+	// ErrInvalidAuthHeader is returned when the Authorization header is present
+	// but does not use the expected scheme.
+	ErrInvalidAuthHeader = errors.New("authorization header format is invalid")
+)
+
+// TokenExtractor pulls a raw token out of a request
+type TokenExtractor func(c *gin.Context) (string, error)
+
+// FromAuthHeader extracts a token from the Authorization header using the given
+// scheme (e.g. "Bearer"). The scheme is matched case-insensitively.
+func FromAuthHeader(scheme string) TokenExtractor {
+	return func(c *gin.Context) (string, error) {
+		authHeader := c.GetHeader("Authorization")
+		if authHeader == "" {
+			return "", ErrTokenNotFound
+		}
+
+		parts := strings.Split(authHeader, " ")
+		if len(parts) != 2 || !strings.EqualFold(parts[0], scheme) || parts[1] == "" {
+			return "", ErrInvalidAuthHeader
+		}
+		return parts[1], nil
+	}
+}
+
+// FromHeader extracts a token from a custom request header holding the bare token
+func FromHeader(name string) TokenExtractor {
+	return func(c *gin.Context) (string, error) {
+		token := strings.TrimSpace(c.GetHeader(name))
+		if token == "" {
+			return "", ErrTokenNotFound
+		}
+		return token, nil
+	}
+}
+
+// FromQuery extracts a token from a URL query parameter
+func FromQuery(param string) TokenExtractor {
+	return func(c *gin.Context) (string, error) {
+		token := c.Query(param)
+		if token == "" {
+			return "", ErrTokenNotFound
+		}
+		return token, nil
+	}
+}
+
+// FromCookie extracts a token from a cookie
+func FromCookie(name string) TokenExtractor {
+	return func(c *gin.Context) (string, error) {
+		token, err := c.Cookie(name)
+		if err != nil || token == "" {
+			return "", ErrTokenNotFound
+		}
+		return token, nil
+	}
+}
+
+// FromWebSocketProtocol extracts a token from the Sec-WebSocket-Protocol header.
+// Browsers cannot set headers on WebSocket upgrades, so clients offer the token
+// as a subprotocol of the form "<prefix><token>" (e.g. "access_token.eyJ...").
+func FromWebSocketProtocol(prefix string) TokenExtractor {
+	return func(c *gin.Context) (string, error) {
+		for _, header := range c.Request.Header.Values("Sec-WebSocket-Protocol") {
+			for _, protocol := range strings.Split(header, ",") {
+				protocol = strings.TrimSpace(protocol)
+				if token, ok := strings.CutPrefix(protocol, prefix); ok && token != "" {
+					return token, nil
+				}
+			}
+		}
+		return "", ErrTokenNotFound
+	}
+}
+
+// DefaultExtractors returns the extractors used when none are configured
+func DefaultExtractors() []TokenExtractor {
+	return []TokenExtractor{FromAuthHeader("Bearer")}
+}
+
+// extractToken runs the extractors in order and returns the first token found
+func extractToken(c *gin.Context, extractors []TokenExtractor) (string, error) {
+	for _, extract := range extractors {
+		token, err := extract(c)
+		if errors.Is(err, ErrTokenNotFound) {
+			continue
+		}
+		return token, err
+	}
+	return "", ErrTokenNotFound
+}
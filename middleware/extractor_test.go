@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenExtractors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name          string
+		extractor     TokenExtractor
+		prepare       func(req *http.Request)
+		expectedToken string
+		expectedError error
+	}{
+		{
+			name:          "Auth header",
+			extractor:     FromAuthHeader("Bearer"),
+			prepare:       func(req *http.Request) { req.Header.Set("Authorization", "Bearer abc") },
+			expectedToken: "abc",
+		},
+		{
+			name:          "Auth header with lowercase scheme",
+			extractor:     FromAuthHeader("Bearer"),
+			prepare:       func(req *http.Request) { req.Header.Set("Authorization", "bearer abc") },
+			expectedToken: "abc",
+		},
+		{
+			name:          "Auth header with wrong scheme",
+			extractor:     FromAuthHeader("Bearer"),
+			prepare:       func(req *http.Request) { req.Header.Set("Authorization", "Basic abc") },
+			expectedError: ErrInvalidAuthHeader,
+		},
+		{
+			name:          "Auth header missing",
+			extractor:     FromAuthHeader("Bearer"),
+			prepare:       func(req *http.Request) {},
+			expectedError: ErrTokenNotFound,
+		},
+		{
+			name:          "Custom header",
+			extractor:     FromHeader("X-Access-Token"),
+			prepare:       func(req *http.Request) { req.Header.Set("X-Access-Token", "abc") },
+			expectedToken: "abc",
+		},
+		{
+			name:          "Query parameter",
+			extractor:     FromQuery("access_token"),
+			prepare:       func(req *http.Request) { req.URL.RawQuery = "access_token=abc" },
+			expectedToken: "abc",
+		},
+		{
+			name:      "Cookie",
+			extractor: FromCookie("access_token"),
+			prepare: func(req *http.Request) {
+				req.AddCookie(&http.Cookie{Name: "access_token", Value: "abc"})
+			},
+			expectedToken: "abc",
+		},
+		{
+			name:      "WebSocket subprotocol",
+			extractor: FromWebSocketProtocol("access_token."),
+			prepare: func(req *http.Request) {
+				req.Header.Set("Sec-WebSocket-Protocol", "chat.v1, access_token.abc")
+			},
+			expectedToken: "abc",
+		},
+		{
+			name:      "WebSocket subprotocol missing",
+			extractor: FromWebSocketProtocol("access_token."),
+			prepare: func(req *http.Request) {
+				req.Header.Set("Sec-WebSocket-Protocol", "chat.v1")
+			},
+			expectedError: ErrTokenNotFound,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			req := httptest.NewRequest("GET", "/test", nil)
+			tc.prepare(req)
+			c.Request = req
+
+			token, err := tc.extractor(c)
+
+			assert.Equal(t, tc.expectedError, err)
+			assert.Equal(t, tc.expectedToken, token)
+		})
+	}
+}
+
+func TestExtractToken_Order(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	extractors := []TokenExtractor{FromAuthHeader("Bearer"), FromQuery("access_token"), FromCookie("access_token")}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	req := httptest.NewRequest("GET", "/test?access_token=from-query", nil)
+	req.AddCookie(&http.Cookie{Name: "access_token", Value: "from-cookie"})
+	c.Request = req
+
+	// The first extractor that finds a token wins
+	token, err := extractToken(c, extractors)
+	assert.NoError(t, err)
+	assert.Equal(t, "from-query", token)
+
+	// Nothing found anywhere
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/test", nil)
+	_, err = extractToken(c, extractors)
+	assert.Equal(t, ErrTokenNotFound, err)
+}
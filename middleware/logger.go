@@ -5,17 +5,30 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
+
+	"example.com/ginhello/logging"
+	"example.com/ginhello/tracing"
 )
 
-// ZapLogger creates a gin middleware for logging HTTP requests using Zap
+// ZapLogger creates a gin middleware for logging HTTP requests using Zap.
+// Each request gets a child logger carrying its request ID and, when the
+// request is traced, its trace and span IDs. Handlers fetch it with
+// logging.FromContext.
 func ZapLogger(logger *zap.Logger) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		start := time.Now()
 		path := c.Request.URL.Path
 		query := c.Request.URL.RawQuery
 
-		// Store logger in context for handlers to use
-		c.Set("logger", logger)
+		// Store a request-scoped logger in context for handlers to use
+		requestLogger := logger
+		if requestID := RequestIDFrom(c); requestID != "" {
+			requestLogger = requestLogger.With(zap.String("request_id", requestID))
+		}
+		if traceFields := tracing.LogFields(c.Request.Context()); traceFields != nil {
+			requestLogger = requestLogger.With(traceFields...)
+		}
+		setRequestLogger(c, requestLogger)
 
 		// Process request
 		c.Next()
@@ -24,7 +37,8 @@ func ZapLogger(logger *zap.Logger) gin.HandlerFunc {
 		end := time.Now()
 		latency := end.Sub(start)
 
-		logger.Info("request",
+		// Later middleware may have enriched the logger (e.g. with the user)
+		logging.FromContext(c, requestLogger).Info("request",
 			zap.Int("status", c.Writer.Status()),
 			zap.String("method", c.Request.Method),
 			zap.String("path", path),
@@ -36,3 +50,9 @@ func ZapLogger(logger *zap.Logger) gin.HandlerFunc {
 		)
 	}
 }
+
+// setRequestLogger stores the request logger in both the gin and request contexts
+func setRequestLogger(c *gin.Context, logger *zap.Logger) {
+	c.Set(logging.LoggerContextKey, logger)
+	c.Request = c.Request.WithContext(logging.WithLogger(c.Request.Context(), logger))
+}
@@ -4,11 +4,17 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zaptest/observer"
+
+	"example.com/ginhello/auth"
+	"example.com/ginhello/config"
+	"example.com/ginhello/logging"
+	"example.com/ginhello/models"
 )
 
 func TestZapLogger(t *testing.T) {
@@ -91,3 +97,75 @@ func TestZapLogger(t *testing.T) {
 
 	assert.NotNil(t, handlerLog)
 }
+
+func TestZapLogger_RequestContext(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	core, logs := observer.New(zap.InfoLevel)
+	logger := zap.New(core)
+	cfg := &config.Config{
+		JWTSecret:        "test_secret",
+		JWTAccessExpiry:  15 * time.Minute,
+		JWTRefreshExpiry: 24 * time.Hour,
+		JWTIssuer:        "test_issuer",
+	}
+	jwtService := auth.NewJWTService(cfg, zap.NewNop())
+	user := &models.User{Username: "testuser"}
+	user.ID = 123
+	tokenPair, _ := jwtService.GenerateTokenPair(user)
+
+	r := gin.New()
+	r.Use(RequestID())
+	r.Use(ZapLogger(logger))
+	r.Use(JWTAuthMiddleware(jwtService, logger))
+	r.GET("/test", func(c *gin.Context) {
+		logging.FromContext(c.Request.Context(), zap.NewNop()).Info("Test log from handler")
+		c.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set(RequestIDHeader, "req-42")
+	req.Header.Set("Authorization", "Bearer "+tokenPair.AccessToken)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Both the handler log and the request log carry request and user identity
+	for _, message := range []string{"Test log from handler", "request"} {
+		entries := logs.FilterMessage(message).All()
+		if assert.Len(t, entries, 1, message) {
+			fields := entries[0].ContextMap()
+			assert.Equal(t, "req-42", fields["request_id"], message)
+			assert.Equal(t, uint64(123), fields["user_id"], message)
+			assert.Equal(t, "testuser", fields["username"], message)
+		}
+	}
+}
+
+func TestZapLogger_Redaction(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	core, logs := observer.New(zap.InfoLevel)
+	logger := zap.New(core, logging.NewRedactor(logging.DefaultRedactionPolicy()).WrapCore())
+
+	r := gin.New()
+	r.Use(ZapLogger(logger))
+	r.GET("/ws", func(c *gin.Context) {
+		logging.FromContext(c, nil).Info("handler", zap.String("email", "jane@example.com"))
+		c.Status(http.StatusOK)
+	})
+
+	// Test
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/ws?access_token=abc.def.ghi&room=1", nil))
+
+	// Assert
+	handlerLogs := logs.FilterMessage("handler").All()
+	if assert.Len(t, handlerLogs, 1) {
+		assert.Equal(t, "j***@example.com", handlerLogs[0].ContextMap()["email"])
+	}
+	requestLogs := logs.FilterMessage("request").All()
+	if assert.Len(t, requestLogs, 1) {
+		assert.Equal(t, "access_token=[REDACTED]&room=1", requestLogs[0].ContextMap()["query"])
+	}
+}
@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"example.com/ginhello/metrics"
+)
+
+// PrometheusMetrics creates a gin middleware recording request count, latency
+// and in-flight requests. Requests are labelled by route template
+// (c.FullPath()) rather than the raw path to keep label cardinality bounded.
+func PrometheusMetrics(m *metrics.HTTPMetrics) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		done := m.Start()
+		defer done()
+
+		c.Next()
+
+		m.Observe(c.FullPath(), c.Request.Method, c.Writer.Status(), time.Since(start))
+	}
+}
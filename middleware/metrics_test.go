@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"example.com/ginhello/metrics"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	reg := prometheus.NewRegistry()
+
+	r := gin.New()
+	r.Use(PrometheusMetrics(metrics.NewHTTPMetrics(reg)))
+	r.GET("/users/:id", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	// Requests for different IDs share the route template label
+	for _, path := range []string{"/users/1", "/users/2", "/does-not-exist"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	// Assert
+	expected := `
+# HELP ginhello_http_requests_total Total number of HTTP requests by route template, method and status class.
+# TYPE ginhello_http_requests_total counter
+ginhello_http_requests_total{method="GET",route="/users/:id",status="2xx"} 2
+ginhello_http_requests_total{method="GET",route="unmatched",status="4xx"} 1
+`
+	err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "ginhello_http_requests_total")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,192 @@
+package middleware
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"example.com/ginhello/auth"
+	"example.com/ginhello/logging"
+	"example.com/ginhello/problem"
+)
+
+// RateLimit describes a token bucket holding up to Requests tokens that
+// refills completely over Per, which must be positive. Name namespaces
+// buckets so that route groups sharing a store do not share quotas.
+type RateLimit struct {
+	Name     string
+	Requests int
+	Per      time.Duration
+}
+
+// RateLimitResult is the outcome of taking a token from a bucket
+type RateLimitResult struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration // Time until a token is available when not allowed
+	Reset      time.Duration // Time until the bucket is full again
+}
+
+// RateLimitStore keeps token buckets. Implementations backed by a shared
+// store (e.g. Redis) allow limits to be enforced across instances.
+type RateLimitStore interface {
+	Take(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error)
+}
+
+// RateLimitKeyFunc derives the bucket key for a request
+type RateLimitKeyFunc func(c *gin.Context) string
+
+// KeyByIP keys requests by client IP
+func KeyByIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// KeyByUser keys requests by authenticated user ID, falling back to the client
+// IP for anonymous requests. It must run after JWTAuthMiddleware.
+func KeyByUser(c *gin.Context) string {
+	if principal, ok := auth.PrincipalFrom(c); ok {
+		return "user:" + strconv.FormatUint(uint64(principal.UserID), 10)
+	}
+	return KeyByIP(c)
+}
+
+// KeyByClient keys requests by the OAuth client the access token was issued
+// to, falling back to the client IP for anonymous requests and first-party
+// tokens. The client is taken from the validated token rather than from
+// request parameters, which anyone could set, so it must run after
+// JWTAuthMiddleware.
+func KeyByClient(c *gin.Context) string {
+	if principal, ok := auth.PrincipalFrom(c); ok && principal.ClientID != "" {
+		return "client:" + principal.ClientID
+	}
+	return KeyByIP(c)
+}
+
+// RateLimiter creates a gin middleware enforcing limit per key. Requests over
+// the limit are rejected with 429 and a Retry-After header; all responses carry
+// RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers. If the
+// store fails the request is let through so an outage does not take the API down.
+func RateLimiter(store RateLimitStore, limit RateLimit, keyFunc RateLimitKeyFunc, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := limit.Name + ":" + keyFunc(c)
+		result, err := store.Take(c.Request.Context(), key, limit)
+		if err != nil {
+			logging.FromContext(c, logger).Error("Rate limit store error", zap.String("limit", limit.Name), zap.Error(err))
+			c.Next()
+			return
+		}
+
+		c.Header("RateLimit-Limit", strconv.Itoa(limit.Requests))
+		c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+
+		if !result.Allowed {
+			logging.FromContext(c, logger).Warn("Rate limit exceeded", zap.String("limit", limit.Name), zap.String("key", key))
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			problem.Error(c, http.StatusTooManyRequests, problem.CodeRateLimited, "Too many requests")
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// bucket is a token bucket refilled lazily on access
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// MemoryRateLimitStore is an in-process RateLimitStore. Buckets that have been
+// idle long enough to refill completely are evicted, since a fresh bucket
+// would be identical.
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	idleAfter map[string]time.Duration
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// sweepInterval bounds how often the store scans for idle buckets
+const sweepInterval = time.Minute
+
+// NewMemoryRateLimitStore creates an empty in-memory store
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		buckets:   make(map[string]*bucket),
+		idleAfter: make(map[string]time.Duration),
+		now:       time.Now,
+	}
+}
+
+// Take removes a token from the bucket for key
+func (s *MemoryRateLimitStore) Take(_ context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	capacity := float64(limit.Requests)
+	refillRate := capacity / limit.Per.Seconds() // Tokens per second
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, updated: now}
+		s.buckets[key] = b
+		s.idleAfter[key] = limit.Per
+	}
+
+	// Refill for the time elapsed since the last access
+	b.tokens = math.Min(capacity, b.tokens+now.Sub(b.updated).Seconds()*refillRate)
+	b.updated = now
+
+	result := RateLimitResult{}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = secondsToDuration((1 - b.tokens) / refillRate)
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = secondsToDuration((capacity - b.tokens) / refillRate)
+
+	return result, nil
+}
+
+// Len returns the number of buckets currently held
+func (s *MemoryRateLimitStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.buckets)
+}
+
+// sweep evicts buckets that have fully refilled. Callers must hold s.mu.
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = now
+
+	for key, b := range s.buckets {
+		if now.Sub(b.updated) >= s.idleAfter[key] {
+			delete(s.buckets, key)
+			delete(s.idleAfter, key)
+		}
+	}
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
@@ -0,0 +1,158 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"example.com/ginhello/auth"
+)
+
+func TestMemoryRateLimitStore_Take(t *testing.T) {
+	// Setup store with a controllable clock
+	now := time.Unix(1700000000, 0)
+	store := NewMemoryRateLimitStore()
+	store.now = func() time.Time { return now }
+	limit := RateLimit{Name: "test", Requests: 3, Per: 3 * time.Second}
+	ctx := context.Background()
+
+	// The bucket starts full
+	for i := 2; i >= 0; i-- {
+		result, err := store.Take(ctx, "key", limit)
+		assert.NoError(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, i, result.Remaining)
+	}
+
+	// The bucket is empty
+	result, err := store.Take(ctx, "key", limit)
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, time.Second, result.RetryAfter)
+	assert.Equal(t, 3*time.Second, result.Reset)
+
+	// Other keys have their own bucket
+	result, _ = store.Take(ctx, "other", limit)
+	assert.True(t, result.Allowed)
+
+	// One token is refilled per second
+	now = now.Add(time.Second)
+	result, _ = store.Take(ctx, "key", limit)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+}
+
+func TestMemoryRateLimitStore_Eviction(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	store := NewMemoryRateLimitStore()
+	store.now = func() time.Time { return now }
+	limit := RateLimit{Name: "test", Requests: 1, Per: time.Second}
+
+	_, _ = store.Take(context.Background(), "a", limit)
+	_, _ = store.Take(context.Background(), "b", limit)
+	assert.Equal(t, 2, store.Len())
+
+	// Buckets idle long enough to be full again are dropped on the next sweep
+	now = now.Add(2 * sweepInterval)
+	_, _ = store.Take(context.Background(), "c", limit)
+	assert.Equal(t, 1, store.Len())
+}
+
+type failingRateLimitStore struct{}
+
+func (failingRateLimitStore) Take(context.Context, string, RateLimit) (RateLimitResult, error) {
+	return RateLimitResult{}, errors.New("store unavailable")
+}
+
+func TestRateLimiter(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	limit := RateLimit{Name: "login", Requests: 2, Per: time.Minute}
+
+	r := gin.New()
+	r.Use(RateLimiter(NewMemoryRateLimitStore(), limit, KeyByIP, zap.NewNop()))
+	r.POST("/login", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	doRequest := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/login", nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// Requests within the limit pass and report the remaining quota
+	w := doRequest("10.0.0.1:1234")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+	assert.NotEmpty(t, w.Header().Get("RateLimit-Reset"))
+
+	w = doRequest("10.0.0.1:1234")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// The third request is rejected
+	w = doRequest("10.0.0.1:1234")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "30", w.Header().Get("Retry-After"))
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+
+	// Another client is unaffected
+	w = doRequest("10.0.0.2:1234")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestRateLimiter_StoreFailureAllowsRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	limit := RateLimit{Name: "api", Requests: 1, Per: time.Minute}
+
+	r := gin.New()
+	r.Use(RateLimiter(failingRateLimitStore{}, limit, KeyByIP, zap.NewNop()))
+	r.GET("/test", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/test", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestRateLimitKeyFuncs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	newContext := func(req *http.Request) *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		req.RemoteAddr = "10.0.0.1:1234"
+		c.Request = req
+		return c
+	}
+
+	// By IP
+	c := newContext(httptest.NewRequest("GET", "/test", nil))
+	assert.Equal(t, "ip:10.0.0.1", KeyByIP(c))
+
+	// By user falls back to IP when anonymous
+	assert.Equal(t, "ip:10.0.0.1", KeyByUser(c))
+	c.Set(auth.PrincipalContextKey, &auth.Principal{UserID: 42})
+	assert.Equal(t, "user:42", KeyByUser(c))
+
+	// By client, only as stated by a validated token
+	req := httptest.NewRequest("GET", "/test?client_id=spoofed", nil)
+	req.SetBasicAuth("spoofed", "secret")
+	c = newContext(req)
+	assert.Equal(t, "ip:10.0.0.1", KeyByClient(c))
+	c.Set(auth.PrincipalContextKey, &auth.Principal{UserID: 42})
+	assert.Equal(t, "ip:10.0.0.1", KeyByClient(c), "first-party tokens have no client")
+	c.Set(auth.PrincipalContextKey, &auth.Principal{UserID: 42, ClientID: "mobile-app"})
+	assert.Equal(t, "client:mobile-app", KeyByClient(c))
+}
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"syscall"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"example.com/ginhello/logging"
+	"example.com/ginhello/metrics"
+	"example.com/ginhello/problem"
+)
+
+// ZapRecovery creates a gin middleware that recovers from panics, logs them
+// with their stack trace through the request logger (which carries the
+// request ID and user), counts them and responds with a 500 problem.
+// Panics caused by the client going away are logged without a stack trace
+// and get no response, as there is nobody left to read it.
+func ZapRecovery(logger *zap.Logger, m *metrics.HTTPMetrics) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			requestLogger := logging.FromContext(c, logger)
+			fields := []zap.Field{
+				zap.Any("panic", recovered),
+				zap.String("method", c.Request.Method),
+				zap.String("path", c.Request.URL.Path),
+				logging.RequestHeaders(c.Request.Header),
+			}
+
+			if err, ok := recovered.(error); ok && isBrokenConnection(err) {
+				requestLogger.Warn("Client connection closed", fields...)
+				_ = c.Error(err)
+				c.Abort()
+				return
+			}
+
+			m.Panicked(c.FullPath())
+			requestLogger.Error("Recovered from panic", append(fields, zap.Stack("stack"))...)
+
+			// A handler that panicked halfway through its response cannot
+			// be given a new status
+			if c.Writer.Written() {
+				c.Abort()
+				return
+			}
+			problem.Error(c, http.StatusInternalServerError, problem.CodeInternal, "Internal server error")
+		}()
+
+		c.Next()
+	}
+}
+
+// isBrokenConnection reports whether err means that the client has closed
+// the connection
+func isBrokenConnection(err error) bool {
+	return errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET)
+}
@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"example.com/ginhello/metrics"
+	"example.com/ginhello/problem"
+)
+
+func TestZapRecovery(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	reg := prometheus.NewRegistry()
+	core, logs := observer.New(zap.InfoLevel)
+	logger := zap.New(core)
+
+	r := gin.New()
+	r.Use(RequestID())
+	r.Use(ZapLogger(logger))
+	r.Use(ZapRecovery(logger, metrics.NewHTTPMetrics(reg)))
+	r.GET("/panic", func(c *gin.Context) {
+		panic("boom")
+	})
+	r.GET("/partial", func(c *gin.Context) {
+		c.String(http.StatusOK, "partial")
+		panic("boom after writing")
+	})
+	r.GET("/broken-pipe", func(c *gin.Context) {
+		panic(&net.OpError{Op: "write", Net: "tcp", Err: os.NewSyscallError("write", syscall.EPIPE)})
+	})
+
+	t.Run("Panic", func(t *testing.T) {
+		logs.TakeAll()
+		req := httptest.NewRequest("GET", "/panic", nil)
+		req.Header.Set("Accept", problem.ContentType)
+		req.Header.Set(RequestIDHeader, "panic-request")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		var body problem.Problem
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		assert.Equal(t, problem.CodeInternal, body.Code)
+		assert.Equal(t, "panic-request", body.RequestID)
+		assert.NotContains(t, w.Body.String(), "boom")
+
+		entries := logs.FilterMessage("Recovered from panic").All()
+		if assert.Len(t, entries, 1) {
+			fields := entries[0].ContextMap()
+			assert.Equal(t, zapcore.ErrorLevel, entries[0].Level)
+			assert.Equal(t, "boom", fields["panic"])
+			assert.Equal(t, "panic-request", fields["request_id"])
+			assert.Contains(t, fields["stack"], "recovery_test.go")
+		}
+
+		// The request log sees the 500
+		requests := logs.FilterMessage("request").All()
+		if assert.Len(t, requests, 1) {
+			assert.Equal(t, int64(http.StatusInternalServerError), requests[0].ContextMap()["status"])
+		}
+	})
+
+	t.Run("Panic after writing", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/partial", nil))
+
+		// The status already sent cannot be changed
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "partial", w.Body.String())
+	})
+
+	t.Run("Broken pipe", func(t *testing.T) {
+		logs.TakeAll()
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/broken-pipe", nil))
+
+		assert.Empty(t, w.Body.String())
+		assert.Empty(t, logs.FilterMessage("Recovered from panic").All())
+		entries := logs.FilterMessage("Client connection closed").All()
+		if assert.Len(t, entries, 1) {
+			assert.Equal(t, zapcore.WarnLevel, entries[0].Level)
+			assert.NotContains(t, entries[0].ContextMap(), "stack")
+		}
+	})
+
+	// Only real panics are counted
+	expected := `
+# HELP ginhello_http_panics_total Total number of panics recovered while serving HTTP requests, by route template.
+# TYPE ginhello_http_panics_total counter
+ginhello_http_panics_total{route="/panic"} 1
+ginhello_http_panics_total{route="/partial"} 1
+`
+	err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "ginhello_http_panics_total")
+	assert.NoError(t, err)
+}
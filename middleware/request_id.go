@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// RequestIDHeader is the header used to receive and echo request IDs
+	RequestIDHeader = "X-Request-ID"
+	// RequestIDContextKey is the gin context key holding the request ID
+	RequestIDContextKey = "request_id"
+
+	maxRequestIDLength = 128
+)
+
+type requestIDKey struct{}
+
+// RequestID creates a gin middleware that assigns every request an ID.
+// A well-formed incoming X-Request-ID is honoured, otherwise a new one is
+// generated. The ID is echoed in the response and stored in the context.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = uuid.NewString()
+		}
+
+		c.Set(RequestIDContextKey, requestID)
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), requestIDKey{}, requestID))
+		c.Header(RequestIDHeader, requestID)
+
+		c.Next()
+	}
+}
+
+// RequestIDFrom returns the request ID stored in ctx, or an empty string.
+// It accepts both a *gin.Context and the request's context.Context.
+func RequestIDFrom(ctx context.Context) string {
+	if id, ok := ctx.Value(RequestIDContextKey).(string); ok {
+		return id
+	}
+	if id, ok := ctx.Value(requestIDKey{}).(string); ok {
+		return id
+	}
+	return ""
+}
+
+// validRequestID rejects empty, oversized or non-printable IDs so that client
+// input cannot inject arbitrary content into logs and response headers
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		if r < 0x21 || r > 0x7e {
+			return false
+		}
+	}
+	return true
+}
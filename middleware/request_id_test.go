@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestID(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.Use(RequestID())
+	r.GET("/test", func(c *gin.Context) {
+		// The ID must be readable from both contexts
+		assert.Equal(t, RequestIDFrom(c), RequestIDFrom(c.Request.Context()))
+		c.String(http.StatusOK, RequestIDFrom(c))
+	})
+
+	tests := []struct {
+		name            string
+		incomingID      string
+		expectGenerated bool
+	}{
+		{name: "Incoming ID is honoured", incomingID: "abc-123", expectGenerated: false},
+		{name: "Missing ID is generated", incomingID: "", expectGenerated: true},
+		{name: "Oversized ID is replaced", incomingID: strings.Repeat("a", 200), expectGenerated: true},
+		{name: "ID with spaces is replaced", incomingID: "abc 123", expectGenerated: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/test", nil)
+			if tc.incomingID != "" {
+				req.Header.Set(RequestIDHeader, tc.incomingID)
+			}
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			responseID := w.Header().Get(RequestIDHeader)
+			assert.NotEmpty(t, responseID)
+			assert.Equal(t, responseID, w.Body.String())
+			if tc.expectGenerated {
+				assert.NotEqual(t, tc.incomingID, responseID)
+			} else {
+				assert.Equal(t, tc.incomingID, responseID)
+			}
+		})
+	}
+}
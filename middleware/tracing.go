@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"example.com/ginhello/metrics"
+	"example.com/ginhello/tracing"
+)
+
+// Tracing creates a gin middleware that starts a server span for every
+// request. An incoming W3C traceparent header makes the span a child of the
+// caller's trace. The span is stored in the request context so that spans
+// started further down (JWT, database) become its children.
+func Tracing(tp trace.TracerProvider, propagator propagation.TextMapPropagator) gin.HandlerFunc {
+	tracer := tracing.Tracer(tp)
+
+	return func(c *gin.Context) {
+		ctx := propagator.Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+
+		// The route template is only known once routing is done, which it is
+		// for middleware registered with Use
+		route := c.FullPath()
+		if route == "" {
+			route = metrics.UnmatchedRoute
+		}
+
+		ctx, span := tracer.Start(ctx, c.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", c.Request.Method),
+				attribute.String("http.route", route),
+				attribute.String("url.path", c.Request.URL.Path),
+				attribute.String("client.address", c.ClientIP()),
+				attribute.String("user_agent.original", c.Request.UserAgent()),
+			),
+		)
+		defer span.End()
+
+		if requestID := RequestIDFrom(c); requestID != "" {
+			span.SetAttributes(attribute.String("http.request.id", requestID))
+		}
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	"example.com/ginhello/tracing"
+)
+
+func TestTracing(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	core, logs := observer.New(zap.InfoLevel)
+
+	r := gin.New()
+	r.Use(Tracing(tp, tracing.Propagator()))
+	r.Use(ZapLogger(zap.New(core)))
+	r.GET("/users/:id", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	r.GET("/fail", func(c *gin.Context) {
+		c.Status(http.StatusInternalServerError)
+	})
+
+	// A request continuing a caller's trace
+	req := httptest.NewRequest("GET", "/users/42", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	spans := exporter.GetSpans()
+	if assert.Len(t, spans, 1) {
+		span := spans[0]
+		assert.Equal(t, "GET /users/:id", span.Name)
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext.TraceID().String())
+		assert.Equal(t, "00f067aa0ba902b7", span.Parent.SpanID().String())
+		assert.Contains(t, span.Attributes, attribute.Int("http.response.status_code", http.StatusOK))
+		assert.Contains(t, span.Attributes, attribute.String("http.route", "/users/:id"))
+
+		// The request log carries the trace
+		entries := logs.FilterMessage("request").All()
+		if assert.Len(t, entries, 1) {
+			fields := entries[0].ContextMap()
+			assert.Equal(t, span.SpanContext.TraceID().String(), fields["trace_id"])
+			assert.Equal(t, span.SpanContext.SpanID().String(), fields["span_id"])
+		}
+	}
+
+	// Server errors mark the span as failed
+	exporter.Reset()
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/fail", nil))
+	spans = exporter.GetSpans()
+	if assert.Len(t, spans, 1) {
+		assert.Equal(t, codes.Error, spans[0].Status.Code)
+		assert.False(t, spans[0].Parent.IsValid())
+	}
+}
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// AuditEvent records a security relevant action. Events are append-only and
+// therefore have no UpdatedAt or DeletedAt.
+type AuditEvent struct {
+	ID            uint      `gorm:"primarykey" json:"id"`
+	CreatedAt     time.Time `gorm:"index" json:"created_at"`
+	ActorID       *uint     `gorm:"index" json:"actor_id,omitempty"` // Nil when the actor is not a known user
+	ActorUsername string    `gorm:"index" json:"actor_username,omitempty"`
+	Action        string    `gorm:"index;not null" json:"action"`
+	TargetType    string    `json:"target_type,omitempty"`
+	TargetID      string    `gorm:"index" json:"target_id,omitempty"`
+	IP            string    `json:"ip,omitempty"`
+	UserAgent     string    `json:"user_agent,omitempty"`
+	Outcome       string    `gorm:"index;not null" json:"outcome"`
+	Reason        string    `json:"reason,omitempty"` // Why a failed action failed
+	RequestID     string    `json:"request_id,omitempty"`
+}
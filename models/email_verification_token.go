@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// EmailVerificationToken is a single-use token proving that a user can read
+// mail sent to Email. Like password reset tokens, only a hash is stored.
+type EmailVerificationToken struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	UserID    uint       `gorm:"not null;index"`
+	Email     string     `gorm:"not null"` // The address the token was sent to
+	TokenHash string     `gorm:"not null;uniqueIndex"`
+	ExpiresAt time.Time  `gorm:"not null"`
+	UsedAt    *time.Time // Set once the token has been used or superseded
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// PasswordResetToken is a single-use token for resetting a user's password.
+// Only a SHA-256 hash of the token is stored, so a leaked table cannot be
+// used to reset passwords.
+type PasswordResetToken struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	UserID    uint       `gorm:"not null;index"`
+	TokenHash string     `gorm:"not null;uniqueIndex"`
+	ExpiresAt time.Time  `gorm:"not null"`
+	UsedAt    *time.Time // Set once the token has been used or superseded
+}
@@ -1,19 +1,44 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 // User represents a user entity for the database
 type User struct {
-	gorm.Model        // Adds ID, CreatedAt, UpdatedAt, DeletedAt
-	Username   string `gorm:"uniqueIndex;not null" json:"username"`
-	Email      string `gorm:"uniqueIndex;not null" json:"email"`
-	Password   string `gorm:"not null" json:"-"` // Password should not be exposed
+	gorm.Model // Adds ID, CreatedAt, UpdatedAt, DeletedAt
+	// Names are stored normalized, see package normalize. They are only
+	// unique among users that have not been deleted, so that they can be
+	// registered again once an account is deleted; the case-insensitive
+	// unique indexes are created by database.Migrate.
+	Username     string `gorm:"not null" json:"username"`
+	Email        string `gorm:"not null" json:"email"`
+	Password     string `gorm:"not null" json:"-"` // Password should not be exposed
+	IsAdmin      bool   `gorm:"not null;default:false" json:"-"`
+	TokenVersion uint   `gorm:"not null;default:0" json:"-"` // Incremented to revoke all issued tokens
+
+	// Account state only shown to the user themselves
+	EmailVerifiedAt *time.Time `json:"-"`
+	MFAEnabled      bool       `gorm:"not null;default:false" json:"-"`
+	LastLoginAt     *time.Time `json:"-"`
 }
 
 // PublicUser represents the user information safe to expose in APIs
 type PublicUser struct {
-	ID        uint   `json:"id"`
-	Username  string `json:"username"`
-	Email     string `json:"email"`
-	CreatedAt string `json:"created_at"`
+	ID        uint    `json:"id"`
+	Username  string  `json:"username"`
+	Email     string  `json:"email"`
+	CreatedAt string  `json:"created_at"`
+	DeletedAt *string `json:"deleted_at,omitempty"` // Only set for deleted users, which only admins can see
+}
+
+// SelfUser is the view of a user's own account, which includes account state
+// that PublicUser does not expose to others
+type SelfUser struct {
+	PublicUser
+	EmailVerified bool    `json:"email_verified"`
+	MFAEnabled    bool    `json:"mfa_enabled"`
+	LastLoginAt   *string `json:"last_login_at"` // Null until the first login
 }
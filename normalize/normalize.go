@@ -0,0 +1,47 @@
+// Package normalize brings usernames and email addresses into the canonical
+// form they are stored and looked up in, so that names differing only in case,
+// width or surrounding whitespace identify the same account.
+package normalize
+
+import (
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Username returns the canonical form of a username: trimmed, NFKC
+// normalized and case folded
+func Username(username string) string {
+	return fold(strings.TrimSpace(username))
+}
+
+// Email returns the canonical form of an email address: trimmed and NFKC
+// normalized, with the domain case folded and without a trailing dot. The
+// local part is case folded too, as mail servers treat it case-insensitively
+// in practice, unless it is quoted. Sub-addresses ("user+tag") and dots are
+// kept, since they can deliver to different mailboxes.
+func Email(email string) string {
+	email = norm.NFKC.String(strings.TrimSpace(email))
+	at := strings.LastIndexByte(email, '@')
+	if at < 0 {
+		return fold(email)
+	}
+
+	local, domain := email[:at], email[at+1:]
+	if !isQuoted(local) {
+		local = fold(local)
+	}
+	return local + "@" + strings.TrimSuffix(fold(domain), ".")
+}
+
+// fold applies NFKC and Unicode case folding. Folding can undo the
+// normalization, so it is applied again afterwards.
+func fold(s string) string {
+	return norm.NFKC.String(cases.Fold().String(norm.NFKC.String(s)))
+}
+
+// isQuoted reports whether an email local part is a quoted string
+func isQuoted(local string) bool {
+	return len(local) >= 2 && local[0] == '"' && local[len(local)-1] == '"'
+}
@@ -0,0 +1,55 @@
+package normalize
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsername(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{name: "Already normalized", input: "alice", expected: "alice"},
+		{name: "Upper case", input: "Alice", expected: "alice"},
+		{name: "Surrounding whitespace", input: "  alice\t", expected: "alice"},
+		{name: "Full width", input: "ａｌｉｃｅ", expected: "alice"},
+		{name: "Ligature", input: "ﬁona", expected: "fiona"},
+		{name: "Sharp s", input: "Straße", expected: "strasse"},
+		{name: "Greek final sigma", input: "ΟΔΥΣΣΕΥΣ", expected: "οδυσσευσ"},
+		{name: "Combining accent", input: "José", expected: "josé"},
+		{name: "Inner whitespace is kept", input: "alice smith", expected: "alice smith"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, Username(tt.input))
+			assert.Equal(t, tt.expected, Username(tt.expected), "normalization must be idempotent")
+		})
+	}
+}
+
+func TestEmail(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{name: "Already normalized", input: "alice@example.com", expected: "alice@example.com"},
+		{name: "Upper case", input: "Alice.Smith@Example.COM", expected: "alice.smith@example.com"},
+		{name: "Surrounding whitespace", input: " alice@example.com\n", expected: "alice@example.com"},
+		{name: "Sub-address is kept", input: "Alice+News@example.com", expected: "alice+news@example.com"},
+		{name: "Trailing dot in domain", input: "alice@example.com.", expected: "alice@example.com"},
+		{name: "Quoted local part is kept", input: `"Alice Smith"@Example.com`, expected: `"Alice Smith"@example.com`},
+		{name: "Full width", input: "ａｌｉｃｅ＠example.com", expected: "alice@example.com"},
+		{name: "No at sign", input: "Alice", expected: "alice"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, Email(tt.input))
+		})
+	}
+}
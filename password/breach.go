@@ -0,0 +1,160 @@
+package password
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// PrefixLength is the number of hex digits of a SHA-1 hash handed to a
+// RangeSource
+const PrefixLength = 5
+
+// maxLineBytes bounds the length of a line in a breach file: a 40 digit hash,
+// a colon and a count
+const maxLineBytes = 64
+
+// ErrLineTooLong is returned for breach files that are not in the expected format
+var ErrLineTooLong = errors.New("breach file line too long")
+
+// BreachChecker reports whether a password is known from data breaches
+type BreachChecker interface {
+	Breached(ctx context.Context, password string) (bool, error)
+}
+
+// RangeSource returns the SHA-1 hashes of breached passwords that start with
+// a prefix of PrefixLength upper-case hex digits, without the prefix. Only
+// the prefix leaves the checker, so a remote source, such as the Pwned
+// Passwords range API, cannot tell which password is being checked.
+type RangeSource interface {
+	Range(ctx context.Context, prefix string) ([]string, error)
+}
+
+// RangeChecker checks passwords against the hashes of a RangeSource
+type RangeChecker struct {
+	Source RangeSource
+}
+
+// Breached implements BreachChecker
+func (c RangeChecker) Breached(ctx context.Context, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	suffixes, err := c.Source.Range(ctx, hash[:PrefixLength])
+	if err != nil {
+		return false, err
+	}
+	return slices.Contains(suffixes, hash[PrefixLength:]), nil
+}
+
+// BreachFile is a RangeSource reading a local file of breached password
+// hashes, one "HASH:COUNT" line per hash and sorted by hash, as in the
+// ordered-by-hash Pwned Passwords downloads. The file is binary searched
+// rather than loaded, so it can be many gigabytes large.
+type BreachFile struct {
+	file *os.File
+	size int64
+}
+
+// OpenBreachFile opens the breach file at path
+func OpenBreachFile(path string) (*BreachFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &BreachFile{file: file, size: info.Size()}, nil
+}
+
+// Close closes the file
+func (b *BreachFile) Close() error {
+	return b.file.Close()
+}
+
+// Range implements RangeSource
+func (b *BreachFile) Range(ctx context.Context, prefix string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	prefix = strings.ToUpper(prefix)
+
+	// Find the first line whose hash sorts at or after the prefix
+	var searchErr error
+	off := int64(sort.Search(int(b.size), func(i int) bool {
+		line, _, err := b.lineAt(int64(i))
+		if err != nil {
+			searchErr = err
+			return true
+		}
+		return line == "" || hashOf(line) >= prefix
+	}))
+	if searchErr != nil {
+		return nil, searchErr
+	}
+
+	var suffixes []string
+	for {
+		line, next, err := b.lineAt(off)
+		if err != nil {
+			return nil, err
+		}
+		hash := hashOf(line)
+		if !strings.HasPrefix(hash, prefix) {
+			return suffixes, nil
+		}
+		suffixes = append(suffixes, hash[len(prefix):])
+		off = next
+	}
+}
+
+// lineAt returns the first line starting at or after off, without its line
+// break, and the offset of the line after it. The line is empty at the end
+// of the file.
+func (b *BreachFile) lineAt(off int64) (string, int64, error) {
+	// Reading from the byte before off tells whether off starts a line
+	start := max(off-1, 0)
+	buf := make([]byte, 2*maxLineBytes)
+	n, err := b.file.ReadAt(buf, start)
+	if err != nil && err != io.EOF {
+		return "", 0, err
+	}
+	buf = buf[:n]
+
+	var lineStart int
+	if off > 0 {
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 {
+			if start+int64(n) < b.size {
+				return "", 0, ErrLineTooLong
+			}
+			return "", b.size, nil
+		}
+		lineStart = i + 1
+	}
+	rest := buf[lineStart:]
+	lineEnd := bytes.IndexByte(rest, '\n')
+	if lineEnd < 0 {
+		if start+int64(n) < b.size {
+			return "", 0, ErrLineTooLong
+		}
+		lineEnd = len(rest) // The last line has no line break
+	}
+	next := start + int64(lineStart+lineEnd+1)
+	return strings.TrimRight(string(rest[:lineEnd]), "\r"), min(next, b.size), nil
+}
+
+// hashOf returns the upper-case hash of a breach file line
+func hashOf(line string) string {
+	hash, _, _ := strings.Cut(line, ":")
+	return strings.ToUpper(hash)
+}
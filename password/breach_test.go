@@ -0,0 +1,79 @@
+package password
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeBreachFile writes a sorted breach file of the given passwords plus
+// filler hashes, so that the binary search has to skip over many lines
+func writeBreachFile(t *testing.T, passwords ...string) string {
+	t.Helper()
+	var lines []string
+	for _, p := range passwords {
+		sum := sha1.Sum([]byte(p))
+		lines = append(lines, strings.ToUpper(hex.EncodeToString(sum[:]))+":42")
+	}
+	for i := range 500 {
+		sum := sha1.Sum([]byte(fmt.Sprintf("filler-%d", i)))
+		lines = append(lines, strings.ToUpper(hex.EncodeToString(sum[:]))+fmt.Sprintf(":%d", i))
+	}
+	slices.Sort(lines)
+
+	path := filepath.Join(t.TempDir(), "pwned.txt")
+	assert.NoError(t, os.WriteFile(path, []byte(strings.Join(lines, "\r\n")), 0o600))
+	return path
+}
+
+func TestBreachFile(t *testing.T) {
+	// Setup
+	path := writeBreachFile(t, "password", "123456", "letmein")
+	file, err := OpenBreachFile(path)
+	assert.NoError(t, err)
+	defer file.Close()
+	checker := RangeChecker{Source: file}
+
+	// Test
+	for _, p := range []string{"password", "123456", "letmein", "filler-0", "filler-499"} {
+		breached, err := checker.Breached(context.Background(), p)
+		assert.NoError(t, err)
+		assert.True(t, breached, p)
+	}
+	for _, p := range []string{"Tr0ub4dor&3x", "filler-500", ""} {
+		breached, err := checker.Breached(context.Background(), p)
+		assert.NoError(t, err)
+		assert.False(t, breached, p)
+	}
+}
+
+func TestBreachFile_Range(t *testing.T) {
+	// The SHA-1 of "password" is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8
+	file, err := OpenBreachFile(writeBreachFile(t, "password"))
+	assert.NoError(t, err)
+	defer file.Close()
+
+	suffixes, err := file.Range(context.Background(), "5baa6")
+	assert.NoError(t, err)
+	assert.Contains(t, suffixes, "1E4C9B93F3F0682250B6CF8331B7EE68FD8")
+}
+
+func TestBreachFile_Empty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "empty.txt")
+	assert.NoError(t, os.WriteFile(path, nil, 0o600))
+	file, err := OpenBreachFile(path)
+	assert.NoError(t, err)
+	defer file.Close()
+
+	breached, err := RangeChecker{Source: file}.Breached(context.Background(), "password")
+	assert.NoError(t, err)
+	assert.False(t, breached)
+}
@@ -0,0 +1,251 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+
+	"example.com/ginhello/config"
+)
+
+// Hash algorithms
+const (
+	AlgorithmArgon2id = config.PasswordHashArgon2id
+	AlgorithmBcrypt   = config.PasswordHashBcrypt
+)
+
+var (
+	// ErrMismatch is returned when a password does not match a hash
+	ErrMismatch = errors.New("password does not match hash")
+	// ErrUnsupportedHash is returned for hashes of unknown algorithms or in
+	// an unexpected format
+	ErrUnsupportedHash = errors.New("unsupported password hash")
+)
+
+// Argon2Params are the cost parameters of argon2id hashes
+type Argon2Params struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32 // Bytes
+	KeyLength   uint32 // Bytes
+}
+
+// Hasher hashes new passwords with one algorithm and verifies hashes made with
+// any supported one. Argon2id hashes are encoded as PHC strings, for example
+// "$argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>"; bcrypt hashes keep their own
+// "$2a$<cost>$..." format, so hashes stored before argon2id was introduced
+// still verify.
+type Hasher struct {
+	Algorithm  string
+	Argon2     Argon2Params
+	BcryptCost int
+}
+
+// DefaultHasher returns the hasher used when none is configured
+func DefaultHasher() *Hasher {
+	return &Hasher{
+		Algorithm: AlgorithmArgon2id,
+		Argon2: Argon2Params{
+			Memory:      config.DefaultArgon2Memory,
+			Iterations:  config.DefaultArgon2Iterations,
+			Parallelism: config.DefaultArgon2Parallelism,
+			SaltLength:  16,
+			KeyLength:   32,
+		},
+		BcryptCost: config.DefaultBcryptCost,
+	}
+}
+
+// HasherFromConfig creates the hasher configured in cfg. Zero values select
+// the defaults.
+func HasherFromConfig(cfg *config.Config) (*Hasher, error) {
+	h := DefaultHasher()
+	if cfg.PasswordHashAlgorithm != "" {
+		h.Algorithm = cfg.PasswordHashAlgorithm
+	}
+	if cfg.Argon2Memory != 0 {
+		h.Argon2.Memory = uint32(cfg.Argon2Memory)
+	}
+	if cfg.Argon2Iterations != 0 {
+		h.Argon2.Iterations = uint32(cfg.Argon2Iterations)
+	}
+	if cfg.Argon2Parallelism != 0 {
+		h.Argon2.Parallelism = uint8(cfg.Argon2Parallelism)
+	}
+	if cfg.BcryptCost != 0 {
+		h.BcryptCost = cfg.BcryptCost
+	}
+
+	switch {
+	case h.Algorithm != AlgorithmArgon2id && h.Algorithm != AlgorithmBcrypt:
+		return nil, fmt.Errorf("unknown password hash algorithm %q", h.Algorithm)
+	case cfg.Argon2Memory < 0 || cfg.Argon2Memory > 1<<22:
+		return nil, fmt.Errorf("argon2 memory must be at most 4 GiB, got %d KiB", cfg.Argon2Memory)
+	case cfg.Argon2Iterations < 0:
+		return nil, fmt.Errorf("argon2 iterations must be positive, got %d", cfg.Argon2Iterations)
+	case cfg.Argon2Parallelism < 0 || cfg.Argon2Parallelism > 255:
+		return nil, fmt.Errorf("argon2 parallelism must be between 1 and 255, got %d", cfg.Argon2Parallelism)
+	case h.Argon2.Memory < 8*uint32(h.Argon2.Parallelism):
+		return nil, fmt.Errorf("argon2 memory must be at least 8 KiB per thread, got %d KiB", h.Argon2.Memory)
+	case h.BcryptCost < bcrypt.MinCost || h.BcryptCost > bcrypt.MaxCost:
+		return nil, fmt.Errorf("bcrypt cost must be between %d and %d, got %d", bcrypt.MinCost, bcrypt.MaxCost, h.BcryptCost)
+	}
+	return h, nil
+}
+
+// Hash hashes password with the configured algorithm and parameters
+func (h *Hasher) Hash(password string) (string, error) {
+	if h.Algorithm == AlgorithmBcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.BcryptCost)
+		return string(hash), err
+	}
+
+	salt := make([]byte, h.Argon2.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	return encodeArgon2(h.Argon2, salt, argon2Key(h.Argon2, password, salt)), nil
+}
+
+// Verify checks password against a hash made by any supported algorithm,
+// returning ErrMismatch if it does not match
+func (h *Hasher) Verify(hash, password string) error {
+	if isBcrypt(hash) {
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return ErrMismatch
+		}
+		return err
+	}
+
+	params, salt, key, err := decodeArgon2(hash)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(key, argon2Key(params, password, salt)) != 1 {
+		return ErrMismatch
+	}
+	return nil
+}
+
+// NeedsRehash reports whether hash was made with another algorithm or other
+// parameters than new hashes are, so that it should be replaced the next time
+// the password is known
+func (h *Hasher) NeedsRehash(hash string) bool {
+	if isBcrypt(hash) {
+		cost, err := bcrypt.Cost([]byte(hash))
+		return h.Algorithm != AlgorithmBcrypt || err != nil || cost != h.BcryptCost
+	}
+
+	params, salt, _, err := decodeArgon2(hash)
+	if err != nil || h.Algorithm != AlgorithmArgon2id {
+		return true
+	}
+	params.SaltLength = uint32(len(salt))
+	return params != h.Argon2
+}
+
+// AlgorithmOf returns the algorithm hash was made with, or "" if it is not
+// supported
+func AlgorithmOf(hash string) string {
+	switch {
+	case isBcrypt(hash):
+		return AlgorithmBcrypt
+	case strings.HasPrefix(hash, "$"+AlgorithmArgon2id+"$"):
+		return AlgorithmArgon2id
+	}
+	return ""
+}
+
+// Params returns the leading part of hash that identifies its algorithm and
+// cost parameters, for example "$2a$10$" or "$argon2id$v=19$m=19456,t=2,p=1$",
+// or "" if the hash is not supported. Hashes with the same Params take the
+// same time to verify.
+func Params(hash string) string {
+	if isBcrypt(hash) {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return ""
+		}
+		return hash[:7]
+	}
+	if _, _, _, err := decodeArgon2(hash); err != nil {
+		return ""
+	}
+	return strings.Join(strings.SplitN(hash, "$", 5)[:4], "$") + "$"
+}
+
+// HasherFor returns a hasher making hashes with the algorithm and parameters
+// of hash
+func HasherFor(hash string) (*Hasher, error) {
+	h := DefaultHasher()
+	if isBcrypt(hash) {
+		cost, err := bcrypt.Cost([]byte(hash))
+		if err != nil {
+			return nil, ErrUnsupportedHash
+		}
+		h.Algorithm, h.BcryptCost = AlgorithmBcrypt, cost
+		return h, nil
+	}
+
+	params, salt, _, err := decodeArgon2(hash)
+	if err != nil {
+		return nil, err
+	}
+	params.SaltLength = uint32(len(salt))
+	h.Algorithm, h.Argon2 = AlgorithmArgon2id, params
+	return h, nil
+}
+
+// isBcrypt reports whether hash is in bcrypt's format
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+// argon2Key derives the argon2id key of password
+func argon2Key(params Argon2Params, password string, salt []byte) []byte {
+	return argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+}
+
+// encodeArgon2 encodes an argon2id key as a PHC string
+func encodeArgon2(params Argon2Params, salt, key []byte) string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version,
+		params.Memory, params.Iterations, params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+// decodeArgon2 parses an argon2id PHC string. The salt length of the
+// returned parameters is left zero.
+func decodeArgon2(hash string) (Argon2Params, []byte, []byte, error) {
+	var params Argon2Params
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != AlgorithmArgon2id {
+		return params, nil, nil, ErrUnsupportedHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, ErrUnsupportedHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil ||
+		params.Iterations == 0 || params.Parallelism == 0 {
+		return params, nil, nil, ErrUnsupportedHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrUnsupportedHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrUnsupportedHash
+	}
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
@@ -0,0 +1,166 @@
+package password
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+
+	"example.com/ginhello/config"
+)
+
+// cheapHasher returns a hasher with low costs to keep tests fast
+func cheapHasher(algorithm string) *Hasher {
+	h := DefaultHasher()
+	h.Algorithm = algorithm
+	h.Argon2.Memory = 64
+	h.Argon2.Iterations = 1
+	h.BcryptCost = bcrypt.MinCost
+	return h
+}
+
+func TestHasher_HashAndVerify(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		prefix    string
+	}{
+		{algorithm: AlgorithmArgon2id, prefix: "$argon2id$v=19$m=64,t=1,p=1$"},
+		{algorithm: AlgorithmBcrypt, prefix: "$2a$04$"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.algorithm, func(t *testing.T) {
+			// Setup
+			hasher := cheapHasher(tt.algorithm)
+
+			// Test
+			hash, err := hasher.Hash("correct-horse-battery")
+			assert.NoError(t, err)
+			other, err := hasher.Hash("correct-horse-battery")
+			assert.NoError(t, err)
+
+			// Assert
+			assert.True(t, strings.HasPrefix(hash, tt.prefix), hash)
+			assert.NotEqual(t, hash, other, "hashes must be salted")
+			assert.NoError(t, hasher.Verify(hash, "correct-horse-battery"))
+			assert.ErrorIs(t, hasher.Verify(hash, "Correct-horse-battery"), ErrMismatch)
+			assert.False(t, hasher.NeedsRehash(hash))
+			assert.Equal(t, tt.algorithm, AlgorithmOf(hash))
+			assert.Equal(t, tt.prefix, Params(hash))
+		})
+	}
+}
+
+func TestHasher_VerifyOtherAlgorithm(t *testing.T) {
+	// Hashes keep verifying after the algorithm is switched
+	bcryptHash, err := cheapHasher(AlgorithmBcrypt).Hash("correct-horse-battery")
+	assert.NoError(t, err)
+
+	hasher := cheapHasher(AlgorithmArgon2id)
+	assert.NoError(t, hasher.Verify(bcryptHash, "correct-horse-battery"))
+	assert.ErrorIs(t, hasher.Verify(bcryptHash, "wrong"), ErrMismatch)
+	assert.True(t, hasher.NeedsRehash(bcryptHash))
+}
+
+func TestHasher_VerifyUnsupported(t *testing.T) {
+	hasher := cheapHasher(AlgorithmArgon2id)
+	for _, hash := range []string{
+		"",
+		"plaintext",
+		"$scrypt$ln=15,r=8,p=1$c2FsdA$aGFzaA",
+		"$argon2i$v=19$m=64,t=1,p=1$c2FsdHNhbHQ$aGFzaA",
+		"$argon2id$v=16$m=64,t=1,p=1$c2FsdHNhbHQ$aGFzaA",
+		"$argon2id$v=19$m=64,t=0,p=1$c2FsdHNhbHQ$aGFzaA",
+		"$argon2id$v=19$m=64,t=1,p=1$not base64!$aGFzaA",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHQ$",
+	} {
+		assert.ErrorIs(t, hasher.Verify(hash, "password"), ErrUnsupportedHash, hash)
+		assert.True(t, hasher.NeedsRehash(hash), hash)
+		assert.Empty(t, Params(hash), hash)
+		_, err := HasherFor(hash)
+		assert.Error(t, err, hash)
+	}
+	assert.Empty(t, AlgorithmOf("$scrypt$ln=15,r=8,p=1$c2FsdA$aGFzaA"))
+}
+
+func TestHasherFor(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmArgon2id, AlgorithmBcrypt} {
+		t.Run(algorithm, func(t *testing.T) {
+			// Setup
+			stored, err := cheapHasher(algorithm).Hash("correct-horse-battery")
+			assert.NoError(t, err)
+
+			// Test
+			hasher, err := HasherFor(stored)
+			assert.NoError(t, err)
+			hash, err := hasher.Hash("dummy")
+
+			// Assert: new hashes take the same work as the stored one
+			assert.NoError(t, err)
+			assert.Equal(t, Params(stored), Params(hash))
+			assert.False(t, hasher.NeedsRehash(stored))
+		})
+	}
+}
+
+func TestHasher_NeedsRehash(t *testing.T) {
+	current := cheapHasher(AlgorithmArgon2id)
+	hash, err := current.Hash("correct-horse-battery")
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		change func(h *Hasher)
+	}{
+		{name: "Memory", change: func(h *Hasher) { h.Argon2.Memory *= 2 }},
+		{name: "Iterations", change: func(h *Hasher) { h.Argon2.Iterations++ }},
+		{name: "Parallelism", change: func(h *Hasher) { h.Argon2.Parallelism++ }},
+		{name: "Salt length", change: func(h *Hasher) { h.Argon2.SaltLength *= 2 }},
+		{name: "Key length", change: func(h *Hasher) { h.Argon2.KeyLength *= 2 }},
+		{name: "Algorithm", change: func(h *Hasher) { h.Algorithm = AlgorithmBcrypt }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hasher := cheapHasher(AlgorithmArgon2id)
+			tt.change(hasher)
+			assert.True(t, hasher.NeedsRehash(hash))
+		})
+	}
+
+	// A bcrypt hash of another cost
+	bcryptHash, err := cheapHasher(AlgorithmBcrypt).Hash("correct-horse-battery")
+	assert.NoError(t, err)
+	stronger := cheapHasher(AlgorithmBcrypt)
+	stronger.BcryptCost++
+	assert.True(t, stronger.NeedsRehash(bcryptHash))
+}
+
+func TestHasherFromConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.Config
+		wantErr bool
+	}{
+		{name: "Defaults", cfg: config.Config{}},
+		{name: "Bcrypt", cfg: config.Config{PasswordHashAlgorithm: AlgorithmBcrypt, BcryptCost: 12}},
+		{name: "Unknown algorithm", cfg: config.Config{PasswordHashAlgorithm: "md5"}, wantErr: true},
+		{name: "Bcrypt cost too high", cfg: config.Config{BcryptCost: 32}, wantErr: true},
+		{name: "Negative iterations", cfg: config.Config{Argon2Iterations: -1}, wantErr: true},
+		{name: "Parallelism too high", cfg: config.Config{Argon2Parallelism: 256}, wantErr: true},
+		{name: "Memory too low", cfg: config.Config{Argon2Memory: 16, Argon2Parallelism: 4}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hasher, err := HasherFromConfig(&tt.cfg)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.cfg.PasswordHashAlgorithm == AlgorithmBcrypt, hasher.Algorithm == AlgorithmBcrypt)
+		})
+	}
+}
@@ -0,0 +1,181 @@
+// Package password decides which passwords users may choose.
+package password
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"example.com/ginhello/config"
+)
+
+// Violation codes, reported as the code of field errors
+const (
+	CodeMinLength  = "min"
+	CodeMaxBytes   = "max"
+	CodeClasses    = "character_classes"
+	CodeUsername   = "username"
+	CodeEmail      = "email"
+	CodeBannedWord = "banned_word"
+	CodeWeak       = "weak"
+	CodeBreached   = "breached"
+)
+
+// minBannedLength is the shortest username, email local part or banned word
+// that a password must not contain; shorter ones would ban too much
+const minBannedLength = 4
+
+// Violation is a rule a password breaks
+type Violation struct {
+	Code    string
+	Message string
+}
+
+// User holds the account details a password must not contain
+type User struct {
+	Username string
+	Email    string
+}
+
+// Policy is a set of rules for new passwords. Zero values disable a rule.
+type Policy struct {
+	MinLength   int      // Minimum number of characters
+	MaxBytes    int      // Maximum length in bytes
+	MinClasses  int      // Minimum number of character classes: lower, upper, digit, other
+	MinEntropy  float64  // Minimum estimated entropy in bits, see Entropy
+	BannedWords []string // Case-insensitive substrings passwords must not contain
+
+	// Breaches rejects passwords known from data breaches when set
+	Breaches BreachChecker
+}
+
+// DefaultPolicy returns the policy used when none is configured
+func DefaultPolicy() *Policy {
+	return &Policy{
+		MinLength:  config.DefaultPasswordMinLength,
+		MaxBytes:   config.DefaultPasswordMaxBytes, // bcrypt ignores everything past 72 bytes
+		MinClasses: config.DefaultPasswordMinClasses,
+		MinEntropy: config.DefaultPasswordMinEntropy,
+	}
+}
+
+// PolicyFromConfig creates the policy configured in cfg. The breach checker
+// is opened separately, see OpenBreachFile.
+func PolicyFromConfig(cfg *config.Config) *Policy {
+	return &Policy{
+		MinLength:   cfg.PasswordMinLength,
+		MaxBytes:    cfg.PasswordMaxBytes,
+		MinClasses:  cfg.PasswordMinClasses,
+		MinEntropy:  float64(cfg.PasswordMinEntropy),
+		BannedWords: cfg.PasswordBannedWords,
+	}
+}
+
+// Check returns the rules password breaks for user. The error reports a
+// failed breach lookup; the violations of all other rules are still returned.
+func (p *Policy) Check(ctx context.Context, password string, user User) ([]Violation, error) {
+	var violations []Violation
+	if p.MinLength > 0 && utf8.RuneCountInString(password) < p.MinLength {
+		violations = append(violations, Violation{CodeMinLength, fmt.Sprintf("must be at least %d characters long", p.MinLength)})
+	}
+	if p.MaxBytes > 0 && len(password) > p.MaxBytes {
+		violations = append(violations, Violation{CodeMaxBytes, fmt.Sprintf("must be at most %d bytes long", p.MaxBytes)})
+	}
+	if p.MinClasses > 0 && characterClasses(password) < p.MinClasses {
+		violations = append(violations, Violation{CodeClasses,
+			fmt.Sprintf("must contain at least %d of lowercase letters, uppercase letters, digits and other characters", p.MinClasses)})
+	}
+
+	lower := strings.ToLower(password)
+	if containsWord(lower, user.Username) {
+		violations = append(violations, Violation{CodeUsername, "must not contain the username"})
+	}
+	if local, _, _ := strings.Cut(user.Email, "@"); containsWord(lower, local) {
+		violations = append(violations, Violation{CodeEmail, "must not contain the email address"})
+	}
+	for _, word := range p.BannedWords {
+		if containsWord(lower, word) {
+			violations = append(violations, Violation{CodeBannedWord, "must not contain common words such as " + word})
+			break
+		}
+	}
+
+	if p.MinEntropy > 0 && Entropy(password) < p.MinEntropy {
+		violations = append(violations, Violation{CodeWeak, "is too easy to guess"})
+	}
+
+	if p.Breaches != nil {
+		breached, err := p.Breaches.Breached(ctx, password)
+		if err != nil {
+			return violations, err
+		}
+		if breached {
+			violations = append(violations, Violation{CodeBreached, "has appeared in a data breach and must not be used"})
+		}
+	}
+	return violations, nil
+}
+
+// containsWord reports whether lowerPassword contains word, ignoring case and
+// words that are too short to matter
+func containsWord(lowerPassword, word string) bool {
+	if utf8.RuneCountInString(word) < minBannedLength {
+		return false
+	}
+	return strings.Contains(lowerPassword, strings.ToLower(word))
+}
+
+// characterClasses counts the classes of characters in password
+func characterClasses(password string) int {
+	var classes int
+	for _, in := range []func(rune) bool{unicode.IsLower, unicode.IsUpper, unicode.IsDigit, isOther} {
+		if strings.IndexFunc(password, in) >= 0 {
+			classes++
+		}
+	}
+	return classes
+}
+
+// Entropy estimates the entropy of password in bits. Each character adds
+// log2 of the size of the alphabet the password draws from, except that a
+// character repeating or adjacent to the previous one (as in "aaa", "abc" or
+// "321") adds a single bit.
+func Entropy(password string) float64 {
+	var pool int
+	if strings.IndexFunc(password, unicode.IsLower) >= 0 {
+		pool += 26
+	}
+	if strings.IndexFunc(password, unicode.IsUpper) >= 0 {
+		pool += 26
+	}
+	if strings.IndexFunc(password, unicode.IsDigit) >= 0 {
+		pool += 10
+	}
+	if strings.IndexFunc(password, isOther) >= 0 {
+		pool += 33 // Printable ASCII punctuation and space
+	}
+	if pool == 0 {
+		return 0
+	}
+
+	perChar := math.Log2(float64(pool))
+	var bits float64
+	prev := rune(-2)
+	for _, r := range password {
+		if d := r - prev; d >= -1 && d <= 1 {
+			bits++
+		} else {
+			bits += perChar
+		}
+		prev = r
+	}
+	return bits
+}
+
+// isOther reports whether r is neither a letter of either case nor a digit
+func isOther(r rune) bool {
+	return !unicode.IsLower(r) && !unicode.IsUpper(r) && !unicode.IsDigit(r)
+}
@@ -0,0 +1,87 @@
+package password
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// codes returns the codes of violations
+func codes(violations []Violation) []string {
+	result := make([]string, len(violations))
+	for i, v := range violations {
+		result[i] = v.Code
+	}
+	return result
+}
+
+func TestPolicy_Check(t *testing.T) {
+	policy := DefaultPolicy()
+	policy.MinClasses = 3
+	policy.BannedWords = []string{"ginhello"}
+	user := User{Username: "alice", Email: "alice.smith@example.com"}
+
+	tests := []struct {
+		name     string
+		password string
+		expected []string
+	}{
+		{name: "Strong", password: "Tr0ub4dor&3x", expected: []string{}},
+		{name: "Too short", password: "Ab1!", expected: []string{CodeMinLength, CodeWeak}},
+		{name: "Too long", password: "Ab1!" + string(make([]byte, 70)), expected: []string{CodeMaxBytes}},
+		{name: "Too few classes", password: "trombonevalley", expected: []string{CodeClasses}},
+		{name: "Contains username", password: "xX-ALICE-99", expected: []string{CodeUsername}},
+		{name: "Contains email", password: "Alice.Smith#1", expected: []string{CodeUsername, CodeEmail}},
+		{name: "Banned word", password: "MyGinHello#1", expected: []string{CodeBannedWord}},
+		{name: "Predictable", password: "Aaaaaaa1234", expected: []string{CodeWeak}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			violations, err := policy.Check(context.Background(), tc.password, user)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, codes(violations))
+		})
+	}
+}
+
+func TestPolicy_Check_ZeroPolicy(t *testing.T) {
+	violations, err := (&Policy{}).Check(context.Background(), "a", User{})
+	assert.NoError(t, err)
+	assert.Empty(t, violations)
+}
+
+// fakeBreaches reports the passwords in the set as breached
+type fakeBreaches struct {
+	passwords map[string]bool
+	err       error
+}
+
+func (f fakeBreaches) Breached(ctx context.Context, password string) (bool, error) {
+	return f.passwords[password], f.err
+}
+
+func TestPolicy_Check_Breaches(t *testing.T) {
+	policy := &Policy{Breaches: fakeBreaches{passwords: map[string]bool{"P@ssw0rd!": true}}}
+
+	violations, err := policy.Check(context.Background(), "P@ssw0rd!", User{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{CodeBreached}, codes(violations))
+
+	// Lookup errors are returned along with the other violations
+	policy.MinLength = 20
+	policy.Breaches = fakeBreaches{err: errors.New("disk on fire")}
+	violations, err = policy.Check(context.Background(), "P@ssw0rd!", User{})
+	assert.Error(t, err)
+	assert.Equal(t, []string{CodeMinLength}, codes(violations))
+}
+
+func TestEntropy(t *testing.T) {
+	assert.Zero(t, Entropy(""))
+	// Runs and repeats add a single bit per character
+	assert.InDelta(t, 4.7+7, Entropy("abcdefgh"), 0.01)
+	assert.InDelta(t, 4.7+7, Entropy("aaaaaaaa"), 0.01)
+	assert.Greater(t, Entropy("Tr0ub4dor&3x"), Entropy("password"))
+}
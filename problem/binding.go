@@ -0,0 +1,140 @@
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+func init() {
+	// Report fields by their JSON names rather than their Go names
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(jsonFieldName)
+	}
+}
+
+// BindError renders the error returned by c.ShouldBind* as a problem
+func BindError(c *gin.Context, err error) {
+	Render(c, FromBindError(err))
+}
+
+// FromBindError translates a binding error into a problem with one entry per
+// rejected field, so that raw validator and decoder messages are never
+// returned to clients
+func FromBindError(err error) *Problem {
+	var validationErrors validator.ValidationErrors
+	var typeError *json.UnmarshalTypeError
+	var syntaxError *json.SyntaxError
+
+	switch {
+	case errors.As(err, &validationErrors):
+		fieldErrors := make([]FieldError, len(validationErrors))
+		for i, fe := range validationErrors {
+			fieldErrors[i] = FieldError{
+				Field:   fieldPath(fe),
+				Code:    fe.Tag(),
+				Message: fieldMessage(fe),
+			}
+		}
+		return Validation(fieldErrors...)
+
+	case errors.As(err, &typeError):
+		return Validation(FieldError{
+			Field:   typeError.Field,
+			Code:    "type",
+			Message: "must be of type " + jsonTypeName(typeError.Type),
+		})
+
+	case errors.Is(err, io.EOF):
+		return New(http.StatusBadRequest, CodeMalformedBody, "Request body is required")
+
+	case errors.As(err, &syntaxError), errors.Is(err, io.ErrUnexpectedEOF):
+		return New(http.StatusBadRequest, CodeMalformedBody, "Request body is not valid JSON")
+
+	default:
+		return New(http.StatusBadRequest, CodeInvalidRequest, "Invalid request")
+	}
+}
+
+// Validation creates a validation problem listing the rejected fields
+func Validation(fieldErrors ...FieldError) *Problem {
+	messages := make([]string, len(fieldErrors))
+	for i, fe := range fieldErrors {
+		messages[i] = fe.Field + " " + fe.Message
+	}
+	p := New(http.StatusBadRequest, CodeValidationFailed, strings.Join(messages, "; "))
+	p.Errors = fieldErrors
+	return p
+}
+
+// fieldPath returns the JSON path of a rejected field without the name of
+// the top-level struct
+func fieldPath(fe validator.FieldError) string {
+	namespace := fe.Namespace()
+	if i := strings.IndexByte(namespace, '.'); i >= 0 {
+		return namespace[i+1:]
+	}
+	return fe.Field()
+}
+
+// fieldMessage returns a human readable message for a failed validation
+func fieldMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "min":
+		if fe.Kind() == reflect.String {
+			return fmt.Sprintf("must be at least %s characters long", fe.Param())
+		}
+		return "must be at least " + fe.Param()
+	case "max":
+		if fe.Kind() == reflect.String {
+			return fmt.Sprintf("must be at most %s characters long", fe.Param())
+		}
+		return "must be at most " + fe.Param()
+	case "oneof":
+		return "must be one of: " + strings.ReplaceAll(fe.Param(), " ", ", ")
+	default:
+		return "is invalid"
+	}
+}
+
+// jsonFieldName returns the name a struct field has in JSON
+func jsonFieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	switch name {
+	case "-":
+		return ""
+	case "":
+		return field.Name
+	}
+	return name
+}
+
+// jsonTypeName names a Go type the way a JSON client would think of it
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	default:
+		return "object"
+	}
+}
@@ -0,0 +1,128 @@
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ContentType is the media type of problem details documents (RFC 9457)
+const ContentType = "application/problem+json"
+
+// TypePrefix prefixes the error code to form the problem type URI
+const TypePrefix = "urn:ginhello:problem:"
+
+// Stable machine-readable error codes. Clients may rely on these; messages
+// may change.
+const (
+	CodeInvalidRequest         = "invalid_request"
+	CodeMalformedBody          = "malformed_body"
+	CodeValidationFailed       = "validation_failed"
+	CodeAuthenticationRequired = "authentication_required"
+	CodeInvalidCredentials     = "invalid_credentials"
+	CodeEmailNotVerified       = "email_not_verified"
+	CodeInvalidToken           = "invalid_token"
+	CodeInsufficientScope      = "insufficient_scope"
+	CodeForbidden              = "forbidden"
+	CodeNotFound               = "not_found"
+	CodeConflict               = "conflict"
+	CodePreconditionRequired   = "precondition_required"
+	CodePreconditionFailed     = "precondition_failed"
+	CodeUnsupportedMediaType   = "unsupported_media_type"
+	CodeRateLimited            = "rate_limited"
+	CodeInternal               = "internal_error"
+	CodeUnavailable            = "service_unavailable"
+)
+
+// Problem is an RFC 9457 problem details object. Code, Errors and RequestID
+// are extension members.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      string       `json:"code"`
+	Errors    []FieldError `json:"errors,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+}
+
+// FieldError describes why one field of a request was rejected
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// New creates a problem with the given status, error code and detail message
+func New(status int, code, detail string) *Problem {
+	return &Problem{
+		Type:   TypePrefix + code,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// Render writes p in the format the client accepts and aborts the handler
+// chain. Clients asking for application/problem+json get problem details;
+// all others get the legacy {"error": ..., "error_code": ...} object.
+func Render(c *gin.Context, p *Problem) {
+	if p.Instance == "" {
+		p.Instance = c.Request.URL.Path
+	}
+	if p.RequestID == "" {
+		p.RequestID = c.GetString(requestIDContextKey)
+	}
+
+	if !wantsProblem(c) {
+		c.AbortWithStatusJSON(p.Status, legacyBody(p))
+		return
+	}
+
+	c.Abort()
+	c.Render(p.Status, problemJSON{p})
+}
+
+// Error renders a problem built from status, code and detail
+func Error(c *gin.Context, status int, code, detail string) {
+	Render(c, New(status, code, detail))
+}
+
+// requestIDContextKey matches middleware.RequestIDContextKey, which cannot
+// be imported here because the middleware renders problems itself
+const requestIDContextKey = "request_id"
+
+// wantsProblem reports whether the client prefers problem details over the
+// legacy error object
+func wantsProblem(c *gin.Context) bool {
+	return c.NegotiateFormat(gin.MIMEJSON, ContentType) == ContentType
+}
+
+// legacyBody returns the error object returned before problem details were
+// introduced, extended with the error code
+func legacyBody(p *Problem) gin.H {
+	message := p.Detail
+	if message == "" {
+		message = p.Title
+	}
+	return gin.H{"error": message, "error_code": p.Code}
+}
+
+// problemJSON renders a problem with the problem+json content type
+type problemJSON struct {
+	problem *Problem
+}
+
+// Render implements render.Render
+func (r problemJSON) Render(w http.ResponseWriter) error {
+	r.WriteContentType(w)
+	return json.NewEncoder(w).Encode(r.problem)
+}
+
+// WriteContentType implements render.Render
+func (r problemJSON) WriteContentType(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", ContentType)
+}
@@ -0,0 +1,140 @@
+package problem
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type testRequest struct {
+	Username string `json:"username" binding:"required"`
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required,min=8"`
+	Age      int    `json:"age"`
+}
+
+func TestRender(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name                string
+		accept              string
+		expectedContentType string
+		expectProblem       bool
+	}{
+		{"No Accept header", "", "application/json; charset=utf-8", false},
+		{"JSON", "application/json", "application/json; charset=utf-8", false},
+		{"Anything", "*/*", "application/json; charset=utf-8", false},
+		{"Problem details", "application/problem+json", ContentType, true},
+		{"Problem details preferred", "application/problem+json, application/json", ContentType, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("GET", "/api/users/42", nil)
+			if tc.accept != "" {
+				c.Request.Header.Set("Accept", tc.accept)
+			}
+			c.Set("request_id", "req-1")
+
+			Error(c, http.StatusNotFound, CodeNotFound, "User not found")
+
+			assert.True(t, c.IsAborted())
+			assert.Equal(t, http.StatusNotFound, w.Code)
+			assert.Equal(t, tc.expectedContentType, w.Header().Get("Content-Type"))
+
+			var body map[string]interface{}
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+			if tc.expectProblem {
+				assert.Equal(t, map[string]interface{}{
+					"type":       "urn:ginhello:problem:not_found",
+					"title":      "Not Found",
+					"status":     float64(http.StatusNotFound),
+					"detail":     "User not found",
+					"instance":   "/api/users/42",
+					"code":       "not_found",
+					"request_id": "req-1",
+				}, body)
+			} else {
+				assert.Equal(t, map[string]interface{}{
+					"error":      "User not found",
+					"error_code": "not_found",
+				}, body)
+			}
+		})
+	}
+}
+
+func TestFromBindError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name           string
+		body           string
+		expectedCode   string
+		expectedDetail string
+		expectedErrors []FieldError
+	}{
+		{
+			name:           "Missing and invalid fields",
+			body:           `{"email":"not-an-email","password":"short"}`,
+			expectedCode:   CodeValidationFailed,
+			expectedDetail: "username is required; email must be a valid email address; password must be at least 8 characters long",
+			expectedErrors: []FieldError{
+				{Field: "username", Code: "required", Message: "is required"},
+				{Field: "email", Code: "email", Message: "must be a valid email address"},
+				{Field: "password", Code: "min", Message: "must be at least 8 characters long"},
+			},
+		},
+		{
+			name:           "Wrong type",
+			body:           `{"username":"u","email":"u@example.com","password":"password","age":"old"}`,
+			expectedCode:   CodeValidationFailed,
+			expectedDetail: "age must be of type number",
+			expectedErrors: []FieldError{{Field: "age", Code: "type", Message: "must be of type number"}},
+		},
+		{
+			name:           "Empty body",
+			body:           ``,
+			expectedCode:   CodeMalformedBody,
+			expectedDetail: "Request body is required",
+		},
+		{
+			name:           "Invalid JSON",
+			body:           `{"username":`,
+			expectedCode:   CodeMalformedBody,
+			expectedDetail: "Request body is not valid JSON",
+		},
+		{
+			name:           "Not JSON",
+			body:           `username=u`,
+			expectedCode:   CodeMalformedBody,
+			expectedDetail: "Request body is not valid JSON",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("POST", "/", bytes.NewBufferString(tc.body))
+			c.Request.Header.Set("Content-Type", "application/json")
+
+			var req testRequest
+			err := c.ShouldBindJSON(&req)
+			assert.Error(t, err)
+
+			p := FromBindError(err)
+			assert.Equal(t, http.StatusBadRequest, p.Status)
+			assert.Equal(t, tc.expectedCode, p.Code)
+			assert.Equal(t, tc.expectedDetail, p.Detail)
+			assert.Equal(t, tc.expectedErrors, p.Errors)
+		})
+	}
+}
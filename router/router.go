@@ -2,34 +2,175 @@ package router
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 
+	"example.com/ginhello/audit"
 	"example.com/ginhello/auth"
 	"example.com/ginhello/config"
 	"example.com/ginhello/handlers"
+	"example.com/ginhello/mail"
+	"example.com/ginhello/metrics"
 	"example.com/ginhello/middleware"
+	"example.com/ginhello/password"
+	"example.com/ginhello/tracing"
 )
 
+// options holds optional dependencies for SetupRouter
+type options struct {
+	registry       *prometheus.Registry
+	tracerProvider trace.TracerProvider
+	auditRecorder  *audit.Recorder
+	mailer         mail.Mailer
+	passwordPolicy *password.Policy
+	passwordHasher *password.Hasher
+}
+
+// Option configures optional router dependencies
+type Option func(*options)
+
+// WithRegistry sets the Prometheus registry that metrics are registered with
+// and served from on /metrics. Tests use it to inspect recorded metrics.
+func WithRegistry(registry *prometheus.Registry) Option {
+	return func(o *options) {
+		o.registry = registry
+	}
+}
+
+// WithTracerProvider sets the OpenTelemetry tracer provider used for request
+// and JWT spans. Without it the global provider is used, which is a no-op
+// unless tracing has been set up.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(o *options) {
+		o.tracerProvider = tp
+	}
+}
+
+// WithAuditRecorder sets the recorder for security events. Without it events
+// are only stored in the database.
+func WithAuditRecorder(recorder *audit.Recorder) Option {
+	return func(o *options) {
+		o.auditRecorder = recorder
+	}
+}
+
+// WithMailer sets the mailer that password reset emails are sent through.
+// Without it the password reset endpoints are disabled.
+func WithMailer(mailer mail.Mailer) Option {
+	return func(o *options) {
+		o.mailer = mailer
+	}
+}
+
+// WithPasswordPolicy sets the rules for new passwords. Without it the policy
+// is built from the configuration, without a breached password check.
+func WithPasswordPolicy(policy *password.Policy) Option {
+	return func(o *options) {
+		o.passwordPolicy = policy
+	}
+}
+
+// WithPasswordHasher sets how passwords are hashed. Without it the hasher is
+// built from the configuration, falling back to the default hasher if the
+// configuration is invalid.
+func WithPasswordHasher(hasher *password.Hasher) Option {
+	return func(o *options) {
+		o.passwordHasher = hasher
+	}
+}
+
 // SetupRouter configures the Gin router with all routes and middleware
-func SetupRouter(cfg *config.Config, db *gorm.DB, logger *zap.Logger) *gin.Engine {
+func SetupRouter(cfg *config.Config, db *gorm.DB, logger *zap.Logger, opts ...Option) *gin.Engine {
 	// Set Gin to release mode
 	gin.SetMode(gin.ReleaseMode)
 
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.registry == nil {
+		o.registry = newRegistry()
+	}
+	if o.tracerProvider == nil {
+		o.tracerProvider = otel.GetTracerProvider()
+	}
+	if o.auditRecorder == nil {
+		o.auditRecorder = audit.NewRecorder(db, logger)
+	}
+	if o.passwordPolicy == nil {
+		o.passwordPolicy = password.PolicyFromConfig(cfg)
+	}
+	if o.passwordHasher == nil {
+		hasher, err := password.HasherFromConfig(cfg)
+		if err != nil {
+			logger.Error("Invalid password hash configuration, using defaults", zap.Error(err))
+			hasher = password.DefaultHasher()
+		}
+		o.passwordHasher = hasher
+	}
+	httpMetrics := metrics.NewHTTPMetrics(o.registry)
+	authMetrics := metrics.NewAuthMetrics(o.registry)
+
 	// Initialize JWT service
-	jwtService := auth.NewJWTService(cfg, logger)
+	jwtService := auth.NewJWTService(cfg, logger,
+		auth.WithMetrics(authMetrics),
+		auth.WithTracerProvider(o.tracerProvider),
+		auth.WithRevocationChecker(auth.NewUserRevocationChecker(db)),
+		auth.WithUserDB(db),
+	)
+
+	// Email verification needs a mailer to send links with
+	var verification *handlers.EmailVerification
+	if o.mailer != nil {
+		verification = &handlers.EmailVerification{
+			Mailer:         o.mailer,
+			URL:            cfg.EmailVerificationURL,
+			Expiry:         cfg.EmailVerificationExpiry,
+			ResendInterval: cfg.EmailVerificationResendInterval,
+			BlockLogin:     cfg.UnverifiedEmailPolicy == config.UnverifiedEmailBlock,
+		}
+	}
 
 	// Initialize handlers with DB dependency
-	authHandler := handlers.NewAuthHandler(jwtService, db, logger)
-	userHandler := handlers.NewUserHandler(db, logger)
+	authHandler := handlers.NewAuthHandler(jwtService, db, logger,
+		handlers.WithLoginLimiters(newLoginLimiter(cfg, cfg.LockoutThreshold), newLoginLimiter(cfg, cfg.IPLockoutThreshold)),
+		handlers.WithAuditRecorder(o.auditRecorder),
+		handlers.WithPasswordReset(o.mailer, cfg.PasswordResetURL, cfg.PasswordResetExpiry, cfg.PasswordResetResendInterval),
+		handlers.WithEmailVerification(verification),
+		handlers.WithPasswordPolicy(o.passwordPolicy),
+		handlers.WithPasswordHasher(o.passwordHasher),
+	)
+	userHandler := handlers.NewUserHandler(db, logger,
+		handlers.WithUserAuditRecorder(o.auditRecorder),
+		handlers.WithUserEmailVerification(verification),
+		handlers.WithUserPasswordPolicy(o.passwordPolicy),
+		handlers.WithUserPasswordHasher(o.passwordHasher),
+	)
+	auditHandler := handlers.NewAuditHandler(o.auditRecorder, logger)
+
+	// Rate limit buckets are shared by all route groups
+	rateLimitStore := middleware.NewMemoryRateLimitStore()
 
 	r := gin.New()
+	// Client IPs key rate limits, lockouts and audit events, so forwarding
+	// headers are only believed from configured proxies
+	if err := r.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		logger.Error("Invalid trusted proxies, trusting none", zap.Strings("proxies", cfg.TrustedProxies), zap.Error(err))
+		_ = r.SetTrustedProxies(nil)
+	}
+	r.Use(middleware.RequestID())
+	r.Use(middleware.Tracing(o.tracerProvider, tracing.Propagator()))
 	r.Use(middleware.ZapLogger(logger))
-	r.Use(gin.Recovery())
+	r.Use(middleware.PrometheusMetrics(httpMetrics))
+	r.Use(middleware.ZapRecovery(logger, httpMetrics))
 
 	// Add Prometheus metrics endpoint
-	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	r.GET("/metrics", gin.WrapH(promhttp.HandlerFor(o.registry, promhttp.HandlerOpts{Registry: o.registry})))
 
 	// Public routes
 	api := r.Group("/api")
@@ -39,24 +180,101 @@ func SetupRouter(cfg *config.Config, db *gorm.DB, logger *zap.Logger) *gin.Engin
 
 		// Authentication routes
 		auth := api.Group("/auth")
+		if cfg.LoginRateLimit > 0 {
+			auth.Use(middleware.RateLimiter(rateLimitStore, middleware.RateLimit{
+				Name:     "auth",
+				Requests: cfg.LoginRateLimit,
+				Per:      cfg.LoginRateWindow,
+			}, middleware.KeyByIP, logger))
+		}
 		{
 			auth.POST("/login", authHandler.Login)
 			auth.POST("/refresh", authHandler.RefreshToken)
+			auth.POST("/password/forgot", authHandler.ForgotPassword)
+			auth.POST("/password/reset", authHandler.ResetPassword)
+			auth.POST("/email/verify", authHandler.VerifyEmail)
+			auth.POST("/email/resend", authHandler.ResendVerification)
 		}
 	}
 
 	// Protected routes
 	protected := api.Group("/")
-	protected.POST("/users", userHandler.CreateUser)
+
+	// Registration is public and expensive (password hashing, breach lookup,
+	// verification email), so it is limited per IP like the auth routes
+	var register []gin.HandlerFunc
+	if cfg.LoginRateLimit > 0 {
+		register = append(register, middleware.RateLimiter(rateLimitStore, middleware.RateLimit{
+			Name:     "register",
+			Requests: cfg.LoginRateLimit,
+			Per:      cfg.LoginRateWindow,
+		}, middleware.KeyByIP, logger))
+	}
+	protected.POST("/users", append(register, userHandler.CreateUser)...)
 	protected.Use(middleware.JWTAuthMiddleware(jwtService, logger))
+	if cfg.APIRateLimit > 0 {
+		protected.Use(middleware.RateLimiter(rateLimitStore, middleware.RateLimit{
+			Name:     "api",
+			Requests: cfg.APIRateLimit,
+			Per:      cfg.APIRateWindow,
+		}, middleware.KeyByUser, logger))
+	}
 	{
+		// The caller's own account, which stays usable before the email
+		// address has been verified
+		me := protected.Group("/users/me")
+		{
+			me.GET("", userHandler.GetMe)
+			me.PATCH("", userHandler.UpdateMe)
+			me.DELETE("", userHandler.DeleteMe)
+			me.POST("/password", authHandler.ChangePassword)
+		}
+
+		verified := protected.Group("/")
+		if verification != nil && cfg.UnverifiedEmailPolicy == config.UnverifiedEmailRestrict {
+			verified.Use(middleware.RequireVerifiedEmail())
+		}
+
 		// User endpoints
-		users := protected.Group("/users")
+		users := verified.Group("/users")
 		{
 			users.GET("", userHandler.GetUsers)
 			users.GET("/:id", userHandler.GetUserByID)
+			users.PATCH("/:id", userHandler.UpdateUser)
+			users.DELETE("/:id", userHandler.DeleteUser)
+
+			// Admin endpoints
+			users.POST("/:id/restore", middleware.RequirePermission(auth.PermissionAdmin), userHandler.RestoreUser)
+			users.POST("/:id/unlock", middleware.RequirePermission(auth.PermissionAdmin), authHandler.Unlock)
 		}
+
+		// Audit log (admin only)
+		verified.GET("/audit", middleware.RequirePermission(auth.PermissionAdmin), auditHandler.List)
 	}
 
 	return r
 }
+
+// newLoginLimiter creates a failed login limiter, or nil when tracking is disabled
+func newLoginLimiter(cfg *config.Config, threshold int) *auth.AttemptLimiter {
+	if threshold <= 0 {
+		return nil
+	}
+	return auth.NewAttemptLimiter(auth.LockoutPolicy{
+		Threshold:       threshold,
+		LockoutDuration: cfg.LockoutDuration,
+		BaseDelay:       cfg.LoginBackoffBase,
+		MaxDelay:        cfg.LoginBackoffMax,
+	})
+}
+
+// newRegistry creates a registry with the Go runtime and process collectors
+// that the default Prometheus registry provides
+func newRegistry() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return registry
+}
@@ -1,14 +1,22 @@
 package router_test
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 
 	"example.com/ginhello/auth"
+	"example.com/ginhello/config"
+	"example.com/ginhello/handlers"
+	"example.com/ginhello/mail"
 	"example.com/ginhello/router"
 	"example.com/ginhello/testutils"
 )
@@ -54,6 +62,24 @@ func TestSetupRouter_PublicRoutes(t *testing.T) {
 			path:           "/api/auth/refresh",
 			expectedStatus: http.StatusBadRequest, // Expecting bad request without body
 		},
+		{
+			name:           "Create user (registration) endpoint exists (requires body)",
+			method:         "POST",
+			path:           "/api/users",
+			expectedStatus: http.StatusBadRequest, // Expecting bad request without body
+		},
+		{
+			name:           "Password reset is disabled without a mailer",
+			method:         "POST",
+			path:           "/api/auth/password/forgot",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Email verification is disabled without a mailer",
+			method:         "POST",
+			path:           "/api/auth/email/verify",
+			expectedStatus: http.StatusNotFound,
+		},
 	}
 
 	for _, tc := range tests {
@@ -77,7 +103,12 @@ func TestSetupRouter_ProtectedRoutes_Unauthorized(t *testing.T) {
 	}{
 		{name: "Get users", method: "GET", path: "/api/users"},
 		{name: "Get user by ID", method: "GET", path: "/api/users/1"},
-		{name: "Create user", method: "POST", path: "/api/users"},
+		{name: "Update user", method: "PATCH", path: "/api/users/1"},
+		{name: "Delete user", method: "DELETE", path: "/api/users/1"},
+		{name: "Restore user", method: "POST", path: "/api/users/1/restore"},
+		{name: "Get current user", method: "GET", path: "/api/users/me"},
+		{name: "Update current user", method: "PATCH", path: "/api/users/me"},
+		{name: "Delete current user", method: "DELETE", path: "/api/users/me"},
 	}
 
 	for _, tc := range tests {
@@ -110,6 +141,7 @@ func TestSetupRouter_ProtectedRoutes_Authorized(t *testing.T) {
 		{name: "Get users", method: "GET", path: "/api/users", expectedStatus: http.StatusOK},
 		{name: "Get user by ID (self)", method: "GET", path: "/api/users/" + fmt.Sprintf("%d", testUser.ID), expectedStatus: http.StatusOK},
 		{name: "Get user by ID (not found)", method: "GET", path: "/api/users/9999", expectedStatus: http.StatusNotFound},
+		{name: "Get current user", method: "GET", path: "/api/users/me", expectedStatus: http.StatusOK},
 		{name: "Create user (requires body)", method: "POST", path: "/api/users", expectedStatus: http.StatusBadRequest},
 	}
 
@@ -120,3 +152,292 @@ func TestSetupRouter_ProtectedRoutes_Authorized(t *testing.T) {
 		})
 	}
 }
+
+func TestSetupRouter_LoginRateLimit(t *testing.T) {
+	// Setup
+	db, logger := testutils.SetupTestDB(t)
+	cfg := testutils.SetupTestConfig(t)
+	cfg.LoginRateLimit = 2
+	cfg.LoginRateWindow = time.Minute
+	routerEngine := router.SetupRouter(cfg, db, logger)
+
+	// The first requests reach the handler, then the limit kicks in
+	for i := 0; i < 2; i++ {
+		w := performRequest(routerEngine, "POST", "/api/auth/login", "")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	}
+
+	w := performRequest(routerEngine, "POST", "/api/auth/login", "")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+	// Other route groups are not limited by the login quota
+	w = performRequest(routerEngine, "GET", "/api/healthcheck", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestSetupRouter_RegistrationRateLimit(t *testing.T) {
+	// Setup
+	db, logger := testutils.SetupTestDB(t)
+	cfg := testutils.SetupTestConfig(t)
+	cfg.LoginRateLimit = 2
+	cfg.LoginRateWindow = time.Minute
+	routerEngine := router.SetupRouter(cfg, db, logger)
+
+	// Test
+	for i := 0; i < 2; i++ {
+		w := performRequest(routerEngine, "POST", "/api/users", "")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	}
+	w := performRequest(routerEngine, "POST", "/api/users", "")
+
+	// Assert: registration has its own quota, separate from logins
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	w = performRequest(routerEngine, "POST", "/api/auth/login", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestSetupRouter_LoginRateLimit_SpoofedForwardedFor(t *testing.T) {
+	// Setup
+	db, logger := testutils.SetupTestDB(t)
+	cfg := testutils.SetupTestConfig(t)
+	cfg.LoginRateLimit = 2
+	cfg.LoginRateWindow = time.Minute
+	routerEngine := router.SetupRouter(cfg, db, logger)
+
+	login := func(forwardedFor string) int {
+		req := httptest.NewRequest("POST", "/api/auth/login", nil)
+		req.RemoteAddr = "203.0.113.9:4321"
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		w := httptest.NewRecorder()
+		routerEngine.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Test: a new forwarded IP on every request does not get a new bucket
+	assert.Equal(t, http.StatusBadRequest, login("1.2.3.4"))
+	assert.Equal(t, http.StatusBadRequest, login("1.2.3.5"))
+	assert.Equal(t, http.StatusTooManyRequests, login("1.2.3.6"))
+}
+
+func TestSetupRouter_LoginRateLimit_TrustedProxy(t *testing.T) {
+	// Setup
+	db, logger := testutils.SetupTestDB(t)
+	cfg := testutils.SetupTestConfig(t)
+	cfg.LoginRateLimit = 1
+	cfg.LoginRateWindow = time.Minute
+	cfg.TrustedProxies = []string{"10.0.0.0/8"}
+	routerEngine := router.SetupRouter(cfg, db, logger)
+
+	login := func(forwardedFor string) int {
+		req := httptest.NewRequest("POST", "/api/auth/login", nil)
+		req.RemoteAddr = "10.0.0.2:4321"
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		w := httptest.NewRecorder()
+		routerEngine.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Test: behind a trusted proxy each client has its own bucket
+	assert.Equal(t, http.StatusBadRequest, login("198.51.100.1"))
+	assert.Equal(t, http.StatusBadRequest, login("198.51.100.2"))
+	assert.Equal(t, http.StatusTooManyRequests, login("198.51.100.1"))
+}
+
+func TestSetupRouter_AdminRoutes(t *testing.T) {
+	// Setup
+	db, logger := testutils.SetupTestDB(t)
+	cfg := testutils.SetupTestConfig(t)
+	routerEngine := router.SetupRouter(cfg, db, logger)
+	jwtService := auth.NewJWTService(cfg, logger)
+
+	regularUser := testutils.CreateTestUser(t, db, "regularuser", "regular@example.com", "pw")
+	regularTokens, err := jwtService.GenerateTokenPair(&regularUser)
+	assert.NoError(t, err)
+
+	adminUser := testutils.CreateTestUser(t, db, "adminuser", "admin@example.com", "pw")
+	adminUser.IsAdmin = true
+	adminTokens, err := jwtService.GenerateTokenPair(&adminUser)
+	assert.NoError(t, err)
+
+	unlockPath := fmt.Sprintf("/api/users/%d/unlock", regularUser.ID)
+
+	w := performRequest(routerEngine, "POST", unlockPath, "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = performRequest(routerEngine, "POST", unlockPath, regularTokens.AccessToken)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, `Bearer realm="test_issuer", error="insufficient_scope", error_description="Insufficient permissions", scope="admin"`, w.Header().Get("WWW-Authenticate"))
+
+	w = performRequest(routerEngine, "POST", unlockPath, adminTokens.AccessToken)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
+
+func TestSetupRouter_Metrics(t *testing.T) {
+	// Setup
+	db, logger := testutils.SetupTestDB(t)
+	cfg := testutils.SetupTestConfig(t)
+	registry := prometheus.NewRegistry()
+	routerEngine := router.SetupRouter(cfg, db, logger, router.WithRegistry(registry))
+
+	w := performRequest(routerEngine, "GET", "/api/healthcheck", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// The request is recorded in the injected registry and exposed on /metrics
+	count, err := testutil.GatherAndCount(registry, "ginhello_http_requests_total")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	w = performRequest(routerEngine, "GET", "/metrics", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `ginhello_http_requests_total{method="GET",route="/api/healthcheck",status="2xx"} 1`)
+}
+
+func TestSetupRouter_AuthMetrics(t *testing.T) {
+	// Setup
+	db, logger := testutils.SetupTestDB(t)
+	cfg := testutils.SetupTestConfig(t)
+	registry := prometheus.NewRegistry()
+	routerEngine := router.SetupRouter(cfg, db, logger, router.WithRegistry(registry))
+
+	// A request with an expired token is counted by cause
+	expiredCfg := testutils.SetupTestConfig(t)
+	expiredCfg.JWTAccessExpiry = -time.Minute
+	testUser := testutils.CreateTestUser(t, db, "metricsuser", "metrics@example.com", "pw")
+	expiredTokens, err := auth.NewJWTService(expiredCfg, logger).GenerateTokenPair(&testUser)
+	assert.NoError(t, err)
+
+	w := performRequest(routerEngine, "GET", "/api/users", expiredTokens.AccessToken)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = performRequest(routerEngine, "GET", "/metrics", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `ginhello_auth_token_validation_failures_total{cause="expired"} 1`)
+}
+
+func TestSetupRouter_Audit(t *testing.T) {
+	// Setup
+	db, logger := testutils.SetupTestDB(t)
+	cfg := testutils.SetupTestConfig(t)
+	routerEngine := router.SetupRouter(cfg, db, logger)
+	jwtService := auth.NewJWTService(cfg, logger)
+
+	regularUser := testutils.CreateTestUser(t, db, "regularuser", "regular@example.com", "pw")
+	regularTokens, err := jwtService.GenerateTokenPair(&regularUser)
+	assert.NoError(t, err)
+
+	adminUser := testutils.CreateTestUser(t, db, "adminuser", "admin@example.com", "pw")
+	adminUser.IsAdmin = true
+	adminTokens, err := jwtService.GenerateTokenPair(&adminUser)
+	assert.NoError(t, err)
+
+	// The unlock is audited with the admin as actor
+	w := performRequest(routerEngine, "POST", fmt.Sprintf("/api/users/%d/unlock", regularUser.ID), adminTokens.AccessToken)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = performRequest(routerEngine, "GET", "/api/audit", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = performRequest(routerEngine, "GET", "/api/audit", regularTokens.AccessToken)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = performRequest(routerEngine, "GET", "/api/audit?action=user.unlock", adminTokens.AccessToken)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response handlers.AuditListResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	if assert.Len(t, response.Events, 1) {
+		event := response.Events[0]
+		assert.Equal(t, adminUser.ID, *event.ActorID)
+		assert.Equal(t, fmt.Sprint(regularUser.ID), event.TargetID)
+		assert.NotEmpty(t, event.RequestID)
+	}
+}
+
+func TestSetupRouter_DeletedUserTokensRevoked(t *testing.T) {
+	// Setup
+	db, logger := testutils.SetupTestDB(t)
+	cfg := testutils.SetupTestConfig(t)
+	routerEngine := router.SetupRouter(cfg, db, logger)
+	jwtService := auth.NewJWTService(cfg, logger)
+
+	testUser := testutils.CreateTestUser(t, db, "leaving", "leaving@example.com", "pw")
+	tokens, err := jwtService.GenerateTokenPair(&testUser)
+	assert.NoError(t, err)
+
+	adminUser := testutils.CreateTestUser(t, db, "adminuser", "admin@example.com", "pw")
+	adminUser.IsAdmin = true
+	adminTokens, err := jwtService.GenerateTokenPair(&adminUser)
+	assert.NoError(t, err)
+
+	w := performRequest(routerEngine, "DELETE", fmt.Sprintf("/api/users/%d", testUser.ID), tokens.AccessToken)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	// The deleted user's token is rejected straight away
+	w = performRequest(routerEngine, "GET", "/api/users", tokens.AccessToken)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Header().Get("WWW-Authenticate"), `error_description="Token has been revoked"`)
+
+	// Restoring the user does not bring the old token back
+	w = performRequest(routerEngine, "POST", fmt.Sprintf("/api/users/%d/restore", testUser.ID), adminTokens.AccessToken)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = performRequest(routerEngine, "GET", "/api/users", tokens.AccessToken)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestSetupRouter_ChangePasswordRevokesTokens(t *testing.T) {
+	// Setup
+	db, logger := testutils.SetupTestDB(t)
+	cfg := testutils.SetupTestConfig(t)
+	routerEngine := router.SetupRouter(cfg, db, logger)
+	jwtService := auth.NewJWTService(cfg, logger)
+
+	testUser := testutils.CreateTestUser(t, db, "changer", "changer@example.com", "old-password")
+	oldTokens, err := jwtService.GenerateTokenPair(&testUser)
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest("POST", "/api/users/me/password",
+		strings.NewReader(`{"current_password":"old-password","new_password":"new-password"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+oldTokens.AccessToken)
+	w := httptest.NewRecorder()
+	routerEngine.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var newTokens auth.TokenPair
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &newTokens))
+
+	// Tokens issued before the change are revoked, the returned ones work
+	w = performRequest(routerEngine, "GET", "/api/users/me", oldTokens.AccessToken)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Header().Get("WWW-Authenticate"), `error_description="Token has been revoked"`)
+
+	w = performRequest(routerEngine, "GET", "/api/users/me", newTokens.AccessToken)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestSetupRouter_UnverifiedEmailRestricted(t *testing.T) {
+	// Setup
+	db, logger := testutils.SetupTestDB(t)
+	cfg := testutils.SetupTestConfig(t)
+	cfg.UnverifiedEmailPolicy = config.UnverifiedEmailRestrict
+	routerEngine := router.SetupRouter(cfg, db, logger, router.WithMailer(mail.NewMemoryMailer()))
+	jwtService := auth.NewJWTService(cfg, logger)
+
+	testUser := testutils.CreateTestUser(t, db, "restricted", "restricted@example.com", "password123")
+	tokens, err := jwtService.GenerateTokenPair(&testUser)
+	assert.NoError(t, err)
+
+	// Unverified users can only use their own account
+	w := performRequest(routerEngine, "GET", "/api/users/me", tokens.AccessToken)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = performRequest(routerEngine, "GET", "/api/users", tokens.AccessToken)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Header().Get("WWW-Authenticate"), `scope="email_verified"`)
+
+	verifiedAt := time.Now()
+	testUser.EmailVerifiedAt = &verifiedAt
+	tokens, err = jwtService.GenerateTokenPair(&testUser)
+	assert.NoError(t, err)
+	w = performRequest(routerEngine, "GET", "/api/users", tokens.AccessToken)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -10,6 +10,7 @@ import (
 	"example.com/ginhello/config"
 	"example.com/ginhello/database"
 	"example.com/ginhello/models"
+	"example.com/ginhello/password"
 )
 
 // SetupTestDB initializes an in-memory SQLite database for testing
@@ -25,7 +26,7 @@ func SetupTestDB(t *testing.T) (*gorm.DB, *zap.Logger) {
 	}
 
 	// Migrate the schema
-	err = db.AutoMigrate(&models.User{})
+	err = database.Migrate(db, logger)
 	if err != nil {
 		t.Fatalf("Failed to migrate test database schema: %v", err)
 	}
@@ -53,10 +54,10 @@ func SetupTestConfig(t *testing.T) *config.Config {
 }
 
 // CreateTestUser creates a user in the test database and returns it
-func CreateTestUser(t *testing.T, db *gorm.DB, username, email, password string) models.User {
+func CreateTestUser(t *testing.T, db *gorm.DB, username, email, plaintext string) models.User {
 	t.Helper()
 
-	hashedPassword, err := database.HashPassword(password)
+	hashedPassword, err := password.DefaultHasher().Hash(plaintext)
 	if err != nil {
 		t.Fatalf("Failed to hash password: %v", err)
 	}
@@ -0,0 +1,69 @@
+package testutils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// principalContextKey matches auth.PrincipalContextKey, which cannot be
+// imported here because the auth package's own tests use testutils
+const principalContextKey = "auth.principal"
+
+// HandlerRequest describes the request CallHandler sends to a handler
+type HandlerRequest struct {
+	Method string
+	Path   string
+	// Body is sent as is when it is a string and encoded as JSON otherwise
+	Body interface{}
+	// Header values that are empty are left out
+	Header map[string]string
+	Params gin.Params
+	// Principal is the authenticated caller (an *auth.Principal), or nil
+	Principal interface{}
+}
+
+// CallHandler runs handler against req and returns the recorded response
+func CallHandler(handler gin.HandlerFunc, req HandlerRequest) *httptest.ResponseRecorder {
+	var body io.Reader
+	contentType := ""
+	switch b := req.Body.(type) {
+	case nil:
+	case string:
+		body = strings.NewReader(b)
+	default:
+		data, _ := json.Marshal(b)
+		body = bytes.NewReader(data)
+		contentType = "application/json"
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(req.Method, req.Path, body)
+	if contentType != "" {
+		c.Request.Header.Set("Content-Type", contentType)
+	}
+	for name, value := range req.Header {
+		if value != "" {
+			c.Request.Header.Set(name, value)
+		}
+	}
+	c.Params = req.Params
+	if req.Principal != nil {
+		c.Set(principalContextKey, req.Principal)
+	}
+
+	handler(c)
+	c.Writer.WriteHeaderNow()
+	return w
+}
+
+// IDParam returns the route params of a /:id route
+func IDParam(id uint) gin.Params {
+	return gin.Params{{Key: "id", Value: fmt.Sprint(id)}}
+}
@@ -0,0 +1,97 @@
+package tracing
+
+import (
+	"errors"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// spanInstanceKey stores the active span on a GORM statement between callbacks
+const spanInstanceKey = "tracing:span"
+
+// gormPlugin creates a client span for every GORM operation
+type gormPlugin struct {
+	tracer trace.Tracer
+}
+
+// GormPlugin returns a GORM plugin that traces queries as children of the span
+// in the statement context, so handlers must use db.WithContext(ctx)
+func GormPlugin(tp trace.TracerProvider) gorm.Plugin {
+	return &gormPlugin{tracer: Tracer(tp)}
+}
+
+// Name implements gorm.Plugin
+func (p *gormPlugin) Name() string {
+	return "tracing"
+}
+
+// Initialize implements gorm.Plugin
+func (p *gormPlugin) Initialize(db *gorm.DB) error {
+	callbacks := db.Callback()
+	processors := []struct {
+		operation string
+		before    func(name string, fn func(*gorm.DB)) error
+		after     func(name string, fn func(*gorm.DB)) error
+	}{
+		{"create", callbacks.Create().Before("gorm:create").Register, callbacks.Create().After("gorm:create").Register},
+		{"query", callbacks.Query().Before("gorm:query").Register, callbacks.Query().After("gorm:query").Register},
+		{"update", callbacks.Update().Before("gorm:update").Register, callbacks.Update().After("gorm:update").Register},
+		{"delete", callbacks.Delete().Before("gorm:delete").Register, callbacks.Delete().After("gorm:delete").Register},
+		{"row", callbacks.Row().Before("gorm:row").Register, callbacks.Row().After("gorm:row").Register},
+		{"raw", callbacks.Raw().Before("gorm:raw").Register, callbacks.Raw().After("gorm:raw").Register},
+	}
+
+	for _, processor := range processors {
+		if err := processor.before("tracing:before_"+processor.operation, p.before(processor.operation)); err != nil {
+			return err
+		}
+		if err := processor.after("tracing:after_"+processor.operation, p.after); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *gormPlugin) before(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		ctx, span := p.tracer.Start(db.Statement.Context, "gorm."+operation,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				attribute.String("db.system", db.Dialector.Name()),
+				attribute.String("db.operation.name", operation),
+			),
+		)
+		db.Statement.Context = ctx
+		db.InstanceSet(spanInstanceKey, span)
+	}
+}
+
+func (p *gormPlugin) after(db *gorm.DB) {
+	value, ok := db.InstanceGet(spanInstanceKey)
+	if !ok {
+		return
+	}
+	span, ok := value.(trace.Span)
+	if !ok {
+		return
+	}
+	defer span.End()
+
+	if db.Statement.Table != "" {
+		span.SetAttributes(attribute.String("db.collection.name", db.Statement.Table))
+	}
+	// The statement text holds placeholders only, never the bound values
+	span.SetAttributes(
+		attribute.String("db.query.text", db.Statement.SQL.String()),
+		attribute.Int64("db.rows_affected", db.Statement.RowsAffected),
+	)
+
+	// A missing record is an expected outcome, not a failed query
+	if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+		span.RecordError(db.Error)
+		span.SetStatus(codes.Error, db.Error.Error())
+	}
+}
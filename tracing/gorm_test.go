@@ -0,0 +1,57 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type tracedRecord struct {
+	ID   uint
+	Name string
+}
+
+func TestGormPlugin(t *testing.T) {
+	// Setup
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	db, err := gorm.Open(sqlite.Open("file:gorm_tracing?mode=memory"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&tracedRecord{}))
+	assert.NoError(t, db.Use(GormPlugin(tp)))
+
+	// Run queries under a parent span
+	ctx, parent := Tracer(tp).Start(context.Background(), "parent")
+	tx := db.WithContext(ctx)
+	assert.NoError(t, tx.Create(&tracedRecord{Name: "a"}).Error)
+	var record tracedRecord
+	assert.NoError(t, tx.First(&record).Error)
+	assert.ErrorIs(t, tx.First(&record, 9999).Error, gorm.ErrRecordNotFound)
+	assert.Error(t, tx.Table("missing_table").Find(&[]tracedRecord{}).Error)
+	parent.End()
+
+	// Assert
+	spans := exporter.GetSpans()
+	byName := map[string][]tracetest.SpanStub{}
+	for _, span := range spans {
+		byName[span.Name] = append(byName[span.Name], span)
+	}
+
+	assert.Len(t, byName["gorm.create"], 1)
+	assert.Len(t, byName["gorm.query"], 3)
+	for _, span := range append(byName["gorm.create"], byName["gorm.query"]...) {
+		assert.Equal(t, parent.SpanContext().SpanID(), span.Parent.SpanID())
+		assert.Equal(t, parent.SpanContext().TraceID(), span.SpanContext.TraceID())
+	}
+
+	// A missing record is not an error, a failing query is
+	assert.Equal(t, codes.Unset, byName["gorm.query"][1].Status.Code)
+	assert.Equal(t, codes.Error, byName["gorm.query"][2].Status.Code)
+}
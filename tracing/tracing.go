@@ -0,0 +1,77 @@
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"go.uber.org/zap"
+
+	"example.com/ginhello/config"
+)
+
+// InstrumentationName identifies the tracers created by this application
+const InstrumentationName = "example.com/ginhello"
+
+// Setup configures OpenTelemetry tracing. When tracing is disabled it returns a
+// no-op provider. Otherwise spans are exported over OTLP/HTTP to the endpoint
+// configured by the standard OTEL_EXPORTER_OTLP_* environment variables, and
+// the provider and W3C trace context propagator are installed globally.
+// The returned function flushes and stops the exporter.
+func Setup(ctx context.Context, cfg *config.Config, logger *zap.Logger) (trace.TracerProvider, func(context.Context) error, error) {
+	if !cfg.TracingEnabled {
+		return noop.NewTracerProvider(), func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(cfg.ServiceName),
+	))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(Propagator())
+
+	logger.Info("OpenTelemetry tracing enabled", zap.String("service", cfg.ServiceName))
+	return tp, tp.Shutdown, nil
+}
+
+// Propagator returns the W3C trace context and baggage propagator
+func Propagator() propagation.TextMapPropagator {
+	return propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+}
+
+// Tracer returns the application tracer from tp
+func Tracer(tp trace.TracerProvider) trace.Tracer {
+	return tp.Tracer(InstrumentationName)
+}
+
+// LogFields returns zap fields identifying the span in ctx, or nil when ctx
+// carries no valid span
+func LogFields(ctx context.Context) []zap.Field {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.IsValid() {
+		return nil
+	}
+	return []zap.Field{
+		zap.String("trace_id", spanContext.TraceID().String()),
+		zap.String("span_id", spanContext.SpanID().String()),
+	}
+}
@@ -0,0 +1,39 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+	"go.uber.org/zap"
+
+	"example.com/ginhello/config"
+)
+
+func TestSetup_Disabled(t *testing.T) {
+	tp, shutdown, err := Setup(context.Background(), &config.Config{TracingEnabled: false}, zap.NewNop())
+
+	assert.NoError(t, err)
+	assert.IsType(t, noop.TracerProvider{}, tp)
+	assert.NoError(t, shutdown(context.Background()))
+}
+
+func TestLogFields(t *testing.T) {
+	// No span, no fields
+	assert.Nil(t, LogFields(context.Background()))
+
+	// A recording span yields its IDs
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(tracetest.NewInMemoryExporter()))
+	ctx, span := Tracer(tp).Start(context.Background(), "test")
+	defer span.End()
+
+	fields := LogFields(ctx)
+	assert.Len(t, fields, 2)
+	assert.Equal(t, "trace_id", fields[0].Key)
+	assert.Equal(t, span.SpanContext().TraceID().String(), fields[0].String)
+	assert.Equal(t, "span_id", fields[1].Key)
+	assert.Equal(t, span.SpanContext().SpanID().String(), fields[1].String)
+}